@@ -2,24 +2,182 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"github.com/eugene-vodyanko/orgonaut/internal/app"
 	"github.com/eugene-vodyanko/orgonaut/internal/config"
+	"github.com/eugene-vodyanko/orgonaut/internal/model"
 	"github.com/eugene-vodyanko/orgonaut/pkg/logger"
 	"github.com/eugene-vodyanko/orgonaut/pkg/util"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"log"
 )
 
-var configPath string
+const usage = `Usage: orgonaut [command] [flags]
+
+Commands:
+  run       run the replication (default)
+  validate  check the config, the database and Kafka
+  status    print the outbox state per group and part
+  lag       print the parts with the waiting events, the most lagging first
+  pause     pause the task or the group
+  resume    resume the task or the group
+  backfill  re-emit the current state of the rows of the task
+  replay    re-deliver the processed outbox events of the task in the time window
+  trigger   generate the outbox trigger of the table of the task
+  schema    install or upgrade the database objects (schema install|upgrade|status)
+  version   print the build info
+
+Run "orgonaut <command> -h" for the command flags.
+`
 
 func main() {
-	flag.Parse()
-	log.Println("main - cfg path:", configPath)
+	cmd, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	configPath := fs.String("config-path", "configs/application.yml", "path to config file")
+
+	var err error
+	switch cmd {
+	case "run":
+		parse(fs, args)
+		err = run(*configPath)
+	case "validate":
+		parse(fs, args)
+		err = app.Validate(loadConfig(*configPath), os.Stdout)
+	case "status", "lag":
+		groupId := fs.String("group", "", "group id, empty - all groups")
+		parse(fs, args)
+		err = app.Status(loadConfig(*configPath), os.Stdout, *groupId, cmd == "lag")
+	case "pause", "resume":
+		tag := fs.String("task", "", "task tag, e.g. task_group_1_0")
+		groupId := fs.String("group", "", "group id (all parts of the group)")
+		adminURL := fs.String("admin-url", "", "admin api url, by default it is made of admin.address")
+		useDB := fs.Bool("db", false, "write the state to the database instead of the admin api (applied on the next start)")
+		parse(fs, args)
+		err = app.SetPaused(loadConfig(*configPath), os.Stdout, cmd == "pause", *tag, *groupId, *adminURL, *useDB)
+	case "backfill":
+		name := fs.String("task", "", "task name, e.g. task_1")
+		fromPk := fs.String("from", "", "lower bound of the key (inclusive)")
+		toPk := fs.String("to", "", "upper bound of the key (inclusive)")
+		keys := fs.String("keys", "", "comma-separated list of the keys")
+		var conds []model.Condition
+		fs.Func("cond", "condition on the column of the task query: \"column op value\", e.g. \"region = EU\" (repeatable)",
+			func(s string) error {
+				v, err := condition(s)
+				conds = append(conds, v)
+				return err
+			})
+		batchSize := fs.Int("batch-size", 0, "rows number in a chunk, by default the task batch_size is used")
+		parse(fs, args)
+
+		filter := model.Filter{FromPk: *fromPk, ToPk: *toPk, Conditions: conds}
+		if *keys != "" {
+			for _, v := range strings.Split(*keys, ",") {
+				filter.Keys = append(filter.Keys, strings.TrimSpace(v))
+			}
+		}
+		err = app.Backfill(loadConfig(*configPath), os.Stdout, *name, filter, *batchSize)
+	case "replay":
+		name := fs.String("task", "", "task name, e.g. task_1")
+		from := fs.String("from", "", "lower bound of the event time (inclusive), e.g. \"2024-05-01 10:00:00\" (database time zone)")
+		to := fs.String("to", "", "upper bound of the event time (exclusive)")
+		parts := fs.String("parts", "", "comma-separated list of the parts, empty - all the parts")
+		mode := fs.String("mode", app.ReplayCount, "count (dry run), reset (mark the events as new) or stream (send by this command)")
+		topic := fs.String("topic", "", "target topic of the stream mode, by default the task topic is used")
+		batchSize := fs.Int("batch-size", 0, "events number in a chunk, by default the task batch_size is used")
+		parse(fs, args)
+
+		var window model.ReplayWindow
+		window, err = replayWindow(*from, *to, *parts)
+		if err == nil {
+			err = app.Replay(loadConfig(*configPath), os.Stdout, *name, window, *mode, *topic, *batchSize)
+		}
+	case "trigger":
+		name := fs.String("task", "", "task name, e.g. task_1")
+		table := fs.String("table", "", "table of the trigger, e.g. orgon.test_tab, by default the query source of the task")
+		mode := fs.String("mode", app.TriggerPrint, "print (the install and uninstall scripts), install or uninstall")
+		parse(fs, args)
+		err = app.Trigger(loadConfig(*configPath), os.Stdout, *name, *table, *mode)
+	case "schema":
+		action := app.SchemaStatus
+		if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+			action, args = args[0], args[1:]
+		}
+		parse(fs, args)
+		err = app.Schema(loadConfig(*configPath), os.Stdout, action)
+	case "version":
+		fmt.Println(buildInfo())
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func parse(fs *flag.FlagSet, args []string) {
+	// The flag set exits on error
+	_ = fs.Parse(args)
+}
 
+func replayWindow(from, to, parts string) (model.ReplayWindow, error) {
+	var w model.ReplayWindow
+	var err error
+
+	// The time is compared with the event time of the database, so no time zone conversion is done
+	if w.From, err = time.Parse(time.DateTime, from); err != nil {
+		return w, fmt.Errorf("main - invalid from: %w", err)
+	}
+	if w.To, err = time.Parse(time.DateTime, to); err != nil {
+		return w, fmt.Errorf("main - invalid to: %w", err)
+	}
+
+	if parts != "" {
+		for _, v := range strings.Split(parts, ",") {
+			p, err := strconv.Atoi(strings.TrimSpace(v))
+			if err != nil {
+				return w, fmt.Errorf("main - invalid part: %w", err)
+			}
+			w.Parts = append(w.Parts, p)
+		}
+	}
+
+	return w, nil
+}
+
+// condition parses the condition of the backfill filter: the column, the operator and the value
+// separated by the spaces, the value is the rest of the string (see model.Condition).
+func condition(s string) (model.Condition, error) {
+	items := strings.SplitN(strings.TrimSpace(s), " ", 3)
+	if len(items) < 3 {
+		return model.Condition{}, fmt.Errorf("condition %q: column, operator and value are required", s)
+	}
+	return model.Condition{Column: items[0], Op: strings.ToLower(items[1]), Value: items[2]}, nil
+}
+
+func loadConfig(configPath string) *config.Config {
 	cfg, err := config.NewConfig(configPath)
 	if err != nil {
 		log.Fatalf("main - cfg error: %s", err)
 	}
+	return cfg
+}
+
+func run(configPath string) error {
+	log.Println("main - cfg path:", configPath)
+
+	cfg := loadConfig(configPath)
 
 	teardown, err := logger.SetupDefaultLogger(
 		cfg.Logger.Root.LogLevel,
@@ -38,13 +196,10 @@ func main() {
 		}
 	}()
 
-	if err = app.Run(cfg); err != nil {
-		log.Fatal(err)
+	if err = app.Run(cfg, configPath); err != nil {
+		return err
 	}
 
 	util.PrintResourceUsage()
-}
-
-func init() {
-	flag.StringVar(&configPath, "config-path", "configs/application.yml", "path to config file")
+	return nil
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// The build info is set by the linker, see Makefile
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
+
+func buildInfo() string {
+	rev, at, modified := commit, date, false
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, v := range info.Settings {
+			switch v.Key {
+			case "vcs.revision":
+				if rev == "" {
+					rev = v.Value
+				}
+			case "vcs.time":
+				if at == "" {
+					at = v.Value
+				}
+			case "vcs.modified":
+				modified = v.Value == "true"
+			}
+		}
+	}
+
+	if rev == "" {
+		rev = "unknown"
+	}
+	if modified {
+		rev += "-dirty"
+	}
+	if at == "" {
+		at = "unknown"
+	}
+
+	return fmt.Sprintf("orgonaut %s (commit: %s, built: %s, %s %s/%s)",
+		version, rev, at, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
@@ -2,36 +2,36 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/eugene-vodyanko/orgonaut/internal/config"
+	"github.com/eugene-vodyanko/orgonaut/internal/controller/admin"
 	"github.com/eugene-vodyanko/orgonaut/internal/controller/task"
 	"github.com/eugene-vodyanko/orgonaut/internal/infrastructure/broker"
 	"github.com/eugene-vodyanko/orgonaut/internal/infrastructure/repository"
 	"github.com/eugene-vodyanko/orgonaut/internal/service"
+	"github.com/eugene-vodyanko/orgonaut/pkg/health"
 	"github.com/eugene-vodyanko/orgonaut/pkg/kafka/kafkakit"
 	"github.com/eugene-vodyanko/orgonaut/pkg/oracle"
 	"github.com/eugene-vodyanko/orgonaut/pkg/runner"
 	"github.com/eugene-vodyanko/orgonaut/pkg/util"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 )
 
-func Run(cfg *config.Config) error {
+const _preflightTimeout = time.Minute
+
+// Run starts the replication with the configuration read from configPath.
+// On SIGHUP (or on the file change, if enabled), the tasks are reloaded from the same path.
+func Run(cfg *config.Config, configPath string) error {
 
 	// Init DB
-	ora, err := oracle.New(
-		cfg.DB.Username,
-		cfg.DB.Password,
-		cfg.DB.URL,
-		cfg.DB.Schema,
-		cfg.DB.Pool.MaxOpenConns,
-		cfg.DB.Pool.MaxIdleConns,
-		cfg.DB.Pool.MaxLifetime,
-		cfg.DB.Pool.MaxIdleTime,
-	)
+	ora, err := newOracle(cfg)
 	if err != nil {
 		log.Fatal(fmt.Errorf("app - oracle init error: %w", err))
 	}
@@ -44,32 +44,65 @@ func Run(cfg *config.Config) error {
 	}()
 
 	// Init Kafka writer
-	writer, err := kafkakit.NewWriter(cfg.Kafka.Brokers, "",
-		cfg.Kafka.Compress,
-		cfg.Kafka.BatchSize,
-		time.Duration(cfg.Kafka.BatchTimeout)*time.Millisecond,
-		cfg.Kafka.RequiredAcks,
-		cfg.Kafka.CreateTopic,
-		cfg.Kafka.MaxReqSize,
-	)
-
+	writer, err := newWriter(cfg)
 	if err != nil {
 		log.Fatal(fmt.Errorf("app - kafka writer init error: %w", err))
 	}
 
 	// Init service
+	repo := repository.NewRepository(cfg.DB.Schema, ora)
+
+	// Check the version of the packages
+	checkCtx, cancelCheck := context.WithTimeout(context.Background(), _preflightTimeout)
+	err = checkAPIVersion(checkCtx, repo)
+	if err == nil && cfg.Runner.WakeUp.Enabled {
+		checkSignal(checkCtx, repo)
+	}
+	cancelCheck()
+	if err != nil {
+		log.Fatal(err)
+	}
+	sink := broker.NewBroker(writer)
 	srv := service.New(
-		repository.NewRepository(cfg.DB.Schema, ora),
+		repo,
 		repository.NewTxManager(ora.Db),
-		broker.NewBroker(writer),
+		sink,
 	)
 
+	// Init sink health gate
+	gate := health.NewGate("kafka", sink.Probe, time.Duration(cfg.Kafka.ProbeInterval)*time.Millisecond)
+
 	// Init routes
-	routes, err := task.NewRoutes(cfg.Tasks, srv)
+	router := task.NewRouter(srv)
+	newRoutes := func(tasks map[string]config.Task) (map[string][]runner.Task, error) {
+		set, err := router.NewRouteSet(tasks)
+		if err != nil {
+			return nil, err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), _preflightTimeout)
+		defer cancel()
+
+		if err = preflight(ctx, tasks, cfg.Kafka.CreateTopic, repo, writer); err != nil {
+			return nil, fmt.Errorf("preflight error:\n%w", err)
+		}
+
+		for k, v := range set {
+			set[k] = gateTasks(gate, v)
+		}
+		return set, nil
+	}
+
+	set, err := newRoutes(cfg.Tasks)
 	if err != nil {
 		log.Fatal(fmt.Errorf("app - routes init error: %w", err))
 	}
 
+	var routes []runner.Task
+	for _, v := range set {
+		routes = append(routes, v...)
+	}
+
 	// Init runner
 	r := runner.NewRunner("",
 		cfg.Runner.RepeatPolicy.InitialInterval,
@@ -78,23 +111,163 @@ func Run(cfg *config.Config) error {
 		cfg.Runner.MaxWorkers,
 		routes...,
 	)
+	r.SetHandlerTimeout(time.Duration(cfg.Runner.HandlerTimeout) * time.Millisecond)
+	r.SetGracePeriod(time.Duration(cfg.Runner.Shutdown.GracePeriod) * time.Millisecond)
+	r.SetErrorPolicy(runner.ErrorPolicy{
+		InitialInterval:    cfg.Runner.ErrorPolicy.InitialInterval,
+		MaxInterval:        cfg.Runner.ErrorPolicy.MaxInterval,
+		BackoffCoefficient: cfg.Runner.ErrorPolicy.BackoffCoefficient,
+		Jitter:             cfg.Runner.ErrorPolicy.Jitter,
+		FailureThreshold:   cfg.Runner.ErrorPolicy.FailureThreshold,
+		OpenInterval:       cfg.Runner.ErrorPolicy.OpenInterval,
+	})
+
+	// Init admin controller
+	var store admin.StateStore
+	if cfg.Admin.PersistPauses {
+		store = repo
+	}
+	ctl := admin.New(r, router, store)
+	ctl.SetToken(cfg.Admin.Token)
+
+	backfillCtx, stopBackfills := context.WithCancel(context.Background())
+	defer stopBackfills()
+	ctl.SetBackfiller(backfillCtx, srv)
 
 	// Run tasks
 	defer util.Timer("uptime")()
 
 	ctx := context.Background()
+
+	err = ctl.RestorePaused(ctx)
+	if err != nil {
+		log.Fatal(fmt.Errorf("app - restore paused tasks error: %w", err))
+	}
+
+	gateCtx, stopGate := context.WithCancel(ctx)
+	defer stopGate()
+
+	gate.OnRecover(func() {
+		for _, v := range r.Tasks() {
+			r.Wake(v.Tag)
+		}
+	})
+	go gate.Run(gateCtx)
+
 	err = r.RunTasks(ctx)
 	if err != nil {
 		log.Fatal(fmt.Errorf("app - run tasks error: %w", err))
 	}
 
-	// Wait stop signal
+	// Run wake-up listener
+	listener := newAlertListener(repo, r, time.Duration(cfg.Runner.WakeUp.WaitTimeout)*time.Second)
+	if cfg.Runner.WakeUp.Enabled {
+		listener.restart(ctx, cfg.Tasks)
+	}
+
+	// Init config reloader
+	rl := newReloader(configPath, cfg, r, newRoutes, func(tasks map[string]config.Task) {
+		if cfg.Runner.WakeUp.Enabled {
+			listener.restart(ctx, tasks)
+		}
+
+		if err := ctl.RestorePaused(ctx); err != nil {
+			slog.Error("app - restore paused tasks error", "err", err)
+		}
+	})
+
+	watchCtx, stopWatch := context.WithCancel(ctx)
+	defer stopWatch()
+
+	if cfg.Reload.WatchInterval > 0 {
+		go rl.watch(watchCtx, time.Duration(cfg.Reload.WatchInterval)*time.Millisecond)
+	}
+
+	// Run admin API
+	var adminSrv *http.Server
+	if cfg.Admin.Address != "" {
+		adminSrv = &http.Server{
+			Addr:              cfg.Admin.Address,
+			Handler:           ctl.Handler(),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+
+		if cfg.Admin.Token == "" {
+			slog.Warn("app - admin token is not set, the admin api is read-only")
+		}
+
+		go func() {
+			slog.Info("app - admin api started", "address", cfg.Admin.Address)
+			if err := adminSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("app - admin api error", "err", err)
+			}
+		}()
+	}
+
+	// Wait stop signal, reload config on SIGHUP
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGTERM, syscall.SIGINT)
 
-	<-quit
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+loop:
+	for {
+		select {
+		case <-quit:
+			break loop
+		case <-hup:
+			if err := rl.reload(); err != nil {
+				slog.Error("app - config is rejected, the previous one keeps running", "err", err)
+			}
+		}
+	}
 
-	r.Stop(ctx)
+	stopWatch()
+	listener.stop()
+
+	if adminSrv != nil {
+		if err := adminSrv.Shutdown(ctx); err != nil {
+			slog.Error("app - admin api shutdown error", "err", err)
+		}
+	}
+	stopBackfills()
+
+	stopCtx := ctx
+	if cfg.Runner.Shutdown.Timeout > 0 {
+		var cancel context.CancelFunc
+		stopCtx, cancel = context.WithTimeout(ctx, time.Duration(cfg.Runner.Shutdown.Timeout)*time.Millisecond)
+		defer cancel()
+	}
+
+	err = r.Stop(stopCtx)
+	if err != nil {
+		return fmt.Errorf("app - stop runner error: %w", err)
+	}
 
 	return nil
 }
+
+func newOracle(cfg *config.Config) (*oracle.Oracle, error) {
+	return oracle.New(
+		cfg.DB.Username,
+		cfg.DB.Password,
+		cfg.DB.URL,
+		cfg.DB.Schema,
+		cfg.DB.Pool.MaxOpenConns,
+		cfg.DB.Pool.MaxIdleConns,
+		cfg.DB.Pool.MaxLifetime,
+		cfg.DB.Pool.MaxIdleTime,
+	)
+}
+
+func newWriter(cfg *config.Config) (*kafkakit.Writer, error) {
+	return kafkakit.NewWriter(cfg.Kafka.Brokers, "",
+		cfg.Kafka.Compress,
+		cfg.Kafka.BatchSize,
+		time.Duration(cfg.Kafka.BatchTimeout)*time.Millisecond,
+		cfg.Kafka.RequiredAcks,
+		cfg.Kafka.CreateTopic,
+		cfg.Kafka.MaxReqSize,
+	)
+}
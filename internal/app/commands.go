@@ -0,0 +1,479 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/eugene-vodyanko/orgonaut/internal/config"
+	"github.com/eugene-vodyanko/orgonaut/internal/controller/task"
+	"github.com/eugene-vodyanko/orgonaut/internal/infrastructure/broker"
+	"github.com/eugene-vodyanko/orgonaut/internal/infrastructure/repository"
+	"github.com/eugene-vodyanko/orgonaut/internal/model"
+	"github.com/eugene-vodyanko/orgonaut/internal/service"
+	"io"
+	"net/http"
+	"net/url"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+const _commandTimeout = 30 * time.Second
+
+// Validate checks the configuration, the access to the outbox and the availability of Kafka,
+// then the tasks are checked as on start (see preflight). All the problems found are reported, not only the first one.
+func Validate(cfg *config.Config, w io.Writer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), _commandTimeout)
+	defer cancel()
+
+	var errs []error
+	check := func(name string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			_, _ = fmt.Fprintf(w, "%-8s FAIL %v\n", name, err)
+			return
+		}
+		_, _ = fmt.Fprintf(w, "%-8s OK\n", name)
+	}
+
+	// Config
+	_, err := task.NewRouteSet(cfg.Tasks, nil)
+	check("config", err)
+
+	// DB
+	var repo *repository.Repository
+	ora, err := newOracle(cfg)
+	if err == nil {
+		defer func() { _ = ora.Close() }()
+
+		repo = repository.NewRepository(cfg.DB.Schema, ora)
+		err = repo.Check(ctx)
+		if err == nil && cfg.Admin.PersistPauses {
+			_, err = repo.GetPaused(ctx)
+		}
+	}
+	check("database", err)
+
+	// Schema
+	if repo != nil {
+		check("schema", checkAPIVersion(ctx, repo))
+	}
+
+	// Kafka
+	writer, err := newWriter(cfg)
+	if err == nil {
+		defer func() { _ = writer.Close() }()
+		err = writer.Ping(ctx)
+	}
+	check("kafka", err)
+
+	// Tasks
+	if len(errs) == 0 {
+		err = preflight(ctx, cfg.Tasks, cfg.Kafka.CreateTopic, repo, writer)
+		check("tasks", err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Status prints the state of the outbox events per group and part, the named consumers of the groups
+// are printed by their positions (the key "<group_id>@<consumer>").
+// If lagOnly is set, only the parts with the waiting events are printed, the most lagging first.
+func Status(cfg *config.Config, w io.Writer, groupId string, lagOnly bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), _commandTimeout)
+	defer cancel()
+
+	ora, err := newOracle(cfg)
+	if err != nil {
+		return fmt.Errorf("app - oracle init error: %w", err)
+	}
+	defer func() { _ = ora.Close() }()
+
+	repo := repository.NewRepository(cfg.DB.Schema, ora)
+
+	parts, err := repo.GetOutboxState(ctx, groupId)
+	if err != nil {
+		return fmt.Errorf("app - outbox state error: %w", err)
+	}
+
+	consumers, err := repo.GetConsumerState(ctx, groupId)
+	if err != nil {
+		return fmt.Errorf("app - consumer state error: %w", err)
+	}
+
+	parts = mergeConsumers(cfg.Tasks, parts, consumers)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	if lagOnly {
+		_, _ = fmt.Fprintln(tw, "GROUP\tPART\tNEW\tLAG")
+		for _, v := range sortByLag(parts) {
+			_, _ = fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", model.GroupKey(v.GroupId, v.Consumer), v.PartId, v.New, v.Lag)
+		}
+	} else {
+		_, _ = fmt.Fprintln(tw, "GROUP\tPART\tNEW\tPROCESSED\tOLDEST NEW\tLAST PROCESSED\tLAG")
+		for _, v := range parts {
+			_, _ = fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t%s\t%s\n", model.GroupKey(v.GroupId, v.Consumer), v.PartId,
+				v.New, v.Processed, formatTime(v.OldestNew), formatTime(v.LastProcessed), v.Lag)
+		}
+	}
+
+	return tw.Flush()
+}
+
+// SetPaused pauses or resumes the task (by tag) or all the parts of the group.
+//
+// If useDB is false, the running instance is controlled over the admin API,
+// otherwise the state is written to the TASK_STATE table and applied on the next start.
+func SetPaused(cfg *config.Config, w io.Writer, paused bool, tag, groupId, adminURL string, useDB bool) error {
+	if (tag == "") == (groupId == "") {
+		return errors.New("app - either task tag or group id is required")
+	}
+
+	action := "resume"
+	if paused {
+		action = "pause"
+	}
+
+	if !useDB {
+		path := "/api/tasks/" + url.PathEscape(tag) + "/" + action
+		if groupId != "" {
+			path = "/api/groups/" + url.PathEscape(groupId) + "/" + action
+		}
+
+		return callAdmin(cfg, w, adminURL, path)
+	}
+
+	tags := []string{tag}
+	if groupId != "" {
+		tags = nil
+		for _, v := range cfg.Tasks {
+			if model.GroupKey(v.GroupId, v.Consumer) == groupId {
+				tags = task.Tags(v)
+			}
+		}
+
+		if len(tags) == 0 {
+			return fmt.Errorf("app - group[%s] is not configured", groupId)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), _commandTimeout)
+	defer cancel()
+
+	ora, err := newOracle(cfg)
+	if err != nil {
+		return fmt.Errorf("app - oracle init error: %w", err)
+	}
+	defer func() { _ = ora.Close() }()
+
+	repo := repository.NewRepository(cfg.DB.Schema, ora)
+	for _, v := range tags {
+		if err = repo.SavePaused(ctx, v, paused); err != nil {
+			return fmt.Errorf("app - %s task[%s] error: %w", action, v, err)
+		}
+		_, _ = fmt.Fprintf(w, "%s: %s (applied on the next start)\n", v, action)
+	}
+
+	return nil
+}
+
+func callAdmin(cfg *config.Config, w io.Writer, adminURL, path string) error {
+	if adminURL == "" {
+		if cfg.Admin.Address == "" {
+			return errors.New("app - admin api is disabled (see admin.address), use the database instead")
+		}
+
+		adminURL = "http://" + cfg.Admin.Address
+		if strings.HasPrefix(cfg.Admin.Address, ":") {
+			adminURL = "http://localhost" + cfg.Admin.Address
+		}
+	}
+
+	client := http.Client{Timeout: _commandTimeout}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(adminURL, "/")+path, nil)
+	if err != nil {
+		return fmt.Errorf("app - admin api error: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.Admin.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.Admin.Token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("app - admin api error: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("app - admin api error: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("app - admin api error: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	_, err = w.Write(body)
+	return err
+}
+
+// mergeConsumers adds the state of the named consumers to the state of the outbox events in order of the group,
+// the consumer and the part. The state of the events is not changed by the named consumers, so the events
+// of the groups consumed by the named consumers only are not lagging, such groups are reported by the consumers only.
+func mergeConsumers(tasks map[string]config.Task, parts, consumers []model.PartState) []model.PartState {
+	unnamed := make(map[string]bool)
+	named := make(map[string]bool)
+	for _, v := range tasks {
+		if v.Consumer == "" {
+			unnamed[v.GroupId] = true
+		} else {
+			named[v.GroupId] = true
+		}
+	}
+
+	var result []model.PartState
+	for _, v := range parts {
+		if unnamed[v.GroupId] || !named[v.GroupId] {
+			result = append(result, v)
+		}
+	}
+	result = append(result, consumers...)
+
+	sort.SliceStable(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.GroupId != b.GroupId {
+			return a.GroupId < b.GroupId
+		}
+		if a.Consumer != b.Consumer {
+			return a.Consumer < b.Consumer
+		}
+		return a.PartId < b.PartId
+	})
+
+	return result
+}
+
+func sortByLag(parts []model.PartState) []model.PartState {
+	var result []model.PartState
+	for _, v := range parts {
+		if v.New > 0 {
+			result = append(result, v)
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Lag > result[j].Lag
+	})
+
+	return result
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.DateTime)
+}
+
+// Backfill re-emits the current state of the rows of the task matching the filter
+// (see service.RelayService.Backfill), the progress is printed after each chunk.
+// It is stopped by SIGINT or SIGTERM.
+func Backfill(cfg *config.Config, w io.Writer, name string, filter model.Filter, batchSize int) error {
+	t, err := configTask(cfg, name, batchSize)
+	if err != nil {
+		return err
+	}
+
+	if t.IsPayload() {
+		return fmt.Errorf("app - backfill is not supported in %q mode", model.ModePayload)
+	}
+
+	if err := filter.Validate(); err != nil {
+		return fmt.Errorf("app - backfill filter error: %w", err)
+	}
+
+	srv, closeSrv, err := newService(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeSrv()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	start := time.Now()
+	sent, err := srv.Backfill(ctx, t, filter, func(sent int) {
+		_, _ = fmt.Fprintf(w, "%s: sent %d records\n", name, sent)
+	})
+	if err != nil {
+		return fmt.Errorf("app - backfill error (sent %d records): %w", sent, err)
+	}
+
+	_, _ = fmt.Fprintf(w, "%s: done, sent %d records in %s\n", name, sent, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// The modes of the replay
+const (
+	// ReplayCount only counts the events of the window (dry run)
+	ReplayCount = "count"
+	// ReplayReset marks the events as new, so the running tasks of the group relay them again to the task topic
+	ReplayReset = "reset"
+	// ReplayStream sends the events to the topic by this command, the state of the events is not changed
+	ReplayStream = "stream"
+)
+
+// Replay re-delivers the processed outbox events of the task in the window (see service.RelayService.Replay).
+// The number of the events per part is printed first, so the replay can be estimated (ReplayCount mode only prints it).
+// In ReplayStream mode the events are sent to the topic, empty - the task topic.
+// It is stopped by SIGINT or SIGTERM.
+func Replay(cfg *config.Config, w io.Writer, name string, window model.ReplayWindow, mode, topic string,
+	batchSize int) error {
+
+	if mode != ReplayCount && mode != ReplayReset && mode != ReplayStream {
+		return fmt.Errorf("app - unknown replay mode: %q", mode)
+	}
+
+	if topic != "" && mode != ReplayStream {
+		return fmt.Errorf("app - topic is supported by %q mode only", ReplayStream)
+	}
+
+	t, err := configTask(cfg, name, batchSize)
+	if err != nil {
+		return err
+	}
+
+	if topic != "" {
+		t.Topic = topic
+	}
+
+	// The replayed events are read with the task query
+	if t.IsPayload() && mode == ReplayStream {
+		return fmt.Errorf("app - %q mode of replay is not supported for %q task", ReplayStream, model.ModePayload)
+	}
+
+	if err := window.Validate(); err != nil {
+		return fmt.Errorf("app - replay window error: %w", err)
+	}
+
+	srv, closeSrv, err := newService(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeSrv()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	parts, err := srv.CountReplay(ctx, t.GroupId, t.Consumer, window)
+	if err != nil {
+		return fmt.Errorf("app - replay count error: %w", err)
+	}
+
+	var total int64
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "GROUP\tPART\tEVENTS")
+	for _, v := range parts {
+		_, _ = fmt.Fprintf(tw, "%s\t%d\t%d\n", model.GroupKey(v.GroupId, v.Consumer), v.PartId, v.Processed)
+		total += v.Processed
+	}
+	_, _ = fmt.Fprintf(tw, "%s\t%s\t%d\n", t.Key(), "total", total)
+	if err = tw.Flush(); err != nil {
+		return err
+	}
+
+	if mode == ReplayCount || total == 0 {
+		return nil
+	}
+
+	start := time.Now()
+
+	if mode == ReplayReset {
+		reset, err := srv.ResetReplay(ctx, t.GroupId, t.Consumer, window, t.BatchSize, func(reset int) {
+			_, _ = fmt.Fprintf(w, "%s: reset %d events\n", name, reset)
+		})
+		if err != nil {
+			return fmt.Errorf("app - replay reset error (reset %d events): %w", reset, err)
+		}
+
+		_, _ = fmt.Fprintf(w, "%s: done, reset %d events in %s\n", name, reset, time.Since(start).Round(time.Millisecond))
+		return nil
+	}
+
+	sent, err := srv.Replay(ctx, t, window, func(sent int) {
+		_, _ = fmt.Fprintf(w, "%s: sent %d records to %s\n", name, sent, t.Topic)
+	})
+	if err != nil {
+		return fmt.Errorf("app - replay error (sent %d records): %w", sent, err)
+	}
+
+	_, _ = fmt.Fprintf(w, "%s: done, sent %d records in %s\n", name, sent, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// configTask makes the task of the configured task name (the part is not set),
+// batchSize overrides the configured batch size if positive.
+func configTask(cfg *config.Config, name string, batchSize int) (*model.Task, error) {
+	v, ok := cfg.Tasks[name]
+	if !ok {
+		return nil, fmt.Errorf("app - task[%s] is not configured", name)
+	}
+
+	t := &model.Task{
+		GroupId:     v.GroupId,
+		Consumer:    v.Consumer,
+		Mode:        v.Mode,
+		BeforeImage: v.BeforeImage,
+		Ordered:     v.Ordered,
+		Compaction:  v.Compaction,
+		Orphans:     v.Orphans,
+		DLQTopic:    v.DLQTopic,
+		TxTopic:     v.TxTopic,
+		BatchSize:   v.BatchSize,
+		Topic:       v.Topic,
+	}
+	t.Query.Columns = v.Query.Columns
+	t.Query.From = v.Query.From
+	t.Query.PkColumn = v.Query.PkColumn
+
+	if batchSize > 0 {
+		t.BatchSize = batchSize
+	}
+
+	if err := t.Validate(); err != nil {
+		return nil, fmt.Errorf("app - task[%s] validation error: %w", name, err)
+	}
+
+	return t, nil
+}
+
+// newService makes the relay service of the command, the returned function closes the connections.
+func newService(cfg *config.Config) (*service.RelayService, func(), error) {
+	ora, err := newOracle(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("app - oracle init error: %w", err)
+	}
+
+	writer, err := newWriter(cfg)
+	if err != nil {
+		_ = ora.Close()
+		return nil, nil, fmt.Errorf("app - kafka writer init error: %w", err)
+	}
+
+	srv := service.New(
+		repository.NewRepository(cfg.DB.Schema, ora),
+		repository.NewTxManager(ora.Db),
+		broker.NewBroker(writer),
+	)
+
+	return srv, func() {
+		_ = writer.Close()
+		_ = ora.Close()
+	}, nil
+}
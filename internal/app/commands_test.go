@@ -0,0 +1,97 @@
+package app
+
+import (
+	"bytes"
+	"github.com/eugene-vodyanko/orgonaut/internal/config"
+	"github.com/eugene-vodyanko/orgonaut/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSetPaused_admin(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		if r.URL.Path == "/api/groups/unknown/pause" {
+			http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("{}\n"))
+	}))
+	defer srv.Close()
+
+	cfg := &config.Config{}
+	var out bytes.Buffer
+
+	require.NoError(t, SetPaused(cfg, &out, true, "task_group_1_0", "", srv.URL, false))
+	require.NoError(t, SetPaused(cfg, &out, false, "", "group_1", srv.URL+"/", false))
+	assert.Error(t, SetPaused(cfg, &out, true, "", "unknown", srv.URL, false))
+
+	assert.Equal(t, []string{
+		"POST /api/tasks/task_group_1_0/pause",
+		"POST /api/groups/group_1/resume",
+		"POST /api/groups/unknown/pause",
+	}, paths)
+
+	// Either the tag or the group is required
+	assert.Error(t, SetPaused(cfg, &out, true, "", "", srv.URL, false))
+	assert.Error(t, SetPaused(cfg, &out, true, "task_group_1_0", "group_1", srv.URL, false))
+
+	// Admin api is disabled
+	assert.Error(t, SetPaused(cfg, &out, true, "task_group_1_0", "", "", false))
+}
+
+func TestReplay_invalid(t *testing.T) {
+	v := config.Task{GroupId: "group_1", PartCount: 1, BatchSize: 100, Topic: "topic_1"}
+	v.Query.Columns = "*"
+	v.Query.From = "test_tab"
+	v.Query.PkColumn = "id"
+
+	cfg := &config.Config{Tasks: map[string]config.Task{"task_1": v}}
+
+	from := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	window := model.ReplayWindow{From: from, To: from.Add(time.Hour)}
+
+	var out bytes.Buffer
+
+	// The arguments are checked before connecting
+	assert.ErrorContains(t, Replay(cfg, &out, "task_1", window, "unknown", "", 0), "unknown replay mode")
+	assert.ErrorContains(t, Replay(cfg, &out, "task_1", window, ReplayReset, "topic_2", 0), "topic is supported")
+	assert.ErrorContains(t, Replay(cfg, &out, "task_2", window, ReplayCount, "", 0), "not configured")
+
+	reversed := model.ReplayWindow{From: window.To, To: window.From}
+	assert.ErrorContains(t, Replay(cfg, &out, "task_1", reversed, ReplayCount, "", 0), "from must be before to")
+
+	negative := model.ReplayWindow{From: window.From, To: window.To, Parts: []int{0, -1}}
+	assert.ErrorContains(t, Replay(cfg, &out, "task_1", negative, ReplayCount, "", 0), "replay window error")
+}
+
+func TestStatus_mergeConsumers(t *testing.T) {
+	tasks := map[string]config.Task{
+		"orders":    {GroupId: "orders"},
+		"orders_es": {GroupId: "orders", Consumer: "search"},
+		"audit_es":  {GroupId: "audit", Consumer: "search"},
+	}
+
+	parts := []model.PartState{
+		{GroupId: "audit", PartId: 0, New: 10, Lag: time.Hour},
+		{GroupId: "orders", PartId: 0, New: 1},
+		{GroupId: "other", PartId: 0, New: 2},
+	}
+	consumers := []model.PartState{
+		{GroupId: "audit", Consumer: "search", PartId: 0, New: 1, Processed: 9, Lag: time.Second},
+		{GroupId: "orders", Consumer: "search", PartId: 0},
+	}
+
+	var keys []string
+	for _, v := range mergeConsumers(tasks, parts, consumers) {
+		keys = append(keys, model.GroupKey(v.GroupId, v.Consumer))
+	}
+
+	// The events of the group consumed by the named consumers only are not lagging
+	assert.Equal(t, []string{"audit@search", "orders", "orders@search", "other"}, keys)
+}
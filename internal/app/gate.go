@@ -0,0 +1,33 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"github.com/eugene-vodyanko/orgonaut/internal/infrastructure/broker"
+	"github.com/eugene-vodyanko/orgonaut/pkg/health"
+	"github.com/eugene-vodyanko/orgonaut/pkg/runner"
+)
+
+// gateTasks pauses the tasks while the sink is unavailable: the handlers do not fetch
+// the records until the gate is opened, and the connectivity errors of the handlers close the gate.
+func gateTasks(gate *health.Gate, tasks []runner.Task) []runner.Task {
+	gated := make([]runner.Task, 0, len(tasks))
+	for _, v := range tasks {
+		handler := v.Handler
+		v.Handler = func(ctx context.Context) (bool, error) {
+			if !gate.Ready() {
+				return false, nil
+			}
+
+			success, err := handler(ctx)
+			if errors.Is(err, broker.ErrUnavailable) {
+				gate.Trip(err)
+			}
+
+			return success, err
+		}
+		gated = append(gated, v)
+	}
+
+	return gated
+}
@@ -0,0 +1,113 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/eugene-vodyanko/orgonaut/internal/config"
+	"github.com/eugene-vodyanko/orgonaut/internal/infrastructure/repository"
+	"github.com/eugene-vodyanko/orgonaut/internal/model"
+	"log/slog"
+	"slices"
+	"sort"
+)
+
+// taskInspector provides the database side of the pre-flight checks (see repository.Repository).
+type taskInspector interface {
+	DescribeQuery(ctx context.Context, q model.Query) (repository.QueryInfo, error)
+	GetPartRange(ctx context.Context, groupId string) (minPart, maxPart int, ok bool, err error)
+	HasRowDependencies(ctx context.Context) (bool, error)
+}
+
+// topicLister provides the Kafka side of the pre-flight checks (see kafkakit.Writer).
+type topicLister interface {
+	Topics(ctx context.Context) ([]string, error)
+}
+
+// preflight checks the tasks before they are started, so the misconfigured ones
+// do not fail at runtime retrying forever:
+//   - the query of the task is parsed and described (without execution),
+//     the pk column must exist and be numeric;
+//   - the topic (and the dlq and tx topics) must exist, unless it is created automatically;
+//   - the new events of the group must use the part ids within the part count;
+//   - the tasks with the tx topic need EVENT_LOG with rowdependencies (the events of a transaction share the SCN).
+//
+// All the problems found are reported at once.
+func preflight(ctx context.Context, tasks map[string]config.Task, createTopic bool,
+	db taskInspector, kafka topicLister) error {
+
+	names := make([]string, 0, len(tasks))
+	for k := range tasks {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var errs []error
+
+	topics, err := kafka.Topics(ctx)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("kafka: list topics error: %w", err))
+	}
+
+	// The row dependencies are checked once, if some task sends the transaction markers
+	rowDeps := true
+	if slices.ContainsFunc(names, func(k string) bool { return tasks[k].TxTopic != "" }) {
+		if rowDeps, err = db.HasRowDependencies(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("database: %w", err))
+			rowDeps = true
+		}
+	}
+
+	for _, name := range names {
+		v := tasks[name]
+
+		// The payload events are relayed as-is, the query is not used
+		if v.Mode != model.ModePayload {
+			info, err := db.DescribeQuery(ctx, model.Query{
+				Columns:  v.Query.Columns,
+				From:     v.Query.From,
+				PkColumn: v.Query.PkColumn,
+			})
+			if err != nil {
+				errs = append(errs, fmt.Errorf("task[%s]: invalid query: %w", name, err))
+			} else if !info.PkNumeric() {
+				errs = append(errs, fmt.Errorf("task[%s]: pk column %q is not numeric (type code %d)",
+					name, v.Query.PkColumn, info.PkType))
+			}
+		}
+
+		if topics != nil && !slices.Contains(topics, v.Topic) {
+			if createTopic {
+				slog.Info(fmt.Sprintf("app - task[%s] topic does not exist, it will be created", name), "topic", v.Topic)
+			} else {
+				errs = append(errs, fmt.Errorf("task[%s]: topic %q does not exist and kafka.topic_auto_create is disabled",
+					name, v.Topic))
+			}
+		}
+
+		if v.DLQTopic != "" && topics != nil && !slices.Contains(topics, v.DLQTopic) && !createTopic {
+			errs = append(errs, fmt.Errorf("task[%s]: dlq topic %q does not exist and kafka.topic_auto_create is disabled",
+				name, v.DLQTopic))
+		}
+
+		if v.TxTopic != "" && topics != nil && !slices.Contains(topics, v.TxTopic) && !createTopic {
+			errs = append(errs, fmt.Errorf("task[%s]: tx topic %q does not exist and kafka.topic_auto_create is disabled",
+				name, v.TxTopic))
+		}
+
+		if v.TxTopic != "" && !rowDeps {
+			errs = append(errs, fmt.Errorf("task[%s]: tx topic needs EVENT_LOG with rowdependencies, "+
+				"the table of the previous versions must be rebuilt", name))
+		}
+
+		lo, hi, ok, err := db.GetPartRange(ctx, v.GroupId)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("task[%s]: %w", name, err))
+		} else if ok && (lo < 0 || hi >= v.PartCount) {
+			errs = append(errs, fmt.Errorf("task[%s]: new events of group %q use parts %d..%d out of part_count %d",
+				name, v.GroupId, lo, hi, v.PartCount))
+		}
+	}
+
+	return errors.Join(errs...)
+}
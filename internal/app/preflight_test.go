@@ -0,0 +1,107 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"github.com/eugene-vodyanko/orgonaut/internal/config"
+	"github.com/eugene-vodyanko/orgonaut/internal/infrastructure/repository"
+	"github.com/eugene-vodyanko/orgonaut/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+type inspectorStub struct {
+	queries   map[string]repository.QueryInfo
+	parts     map[string][2]int
+	noRowDeps bool
+}
+
+func (s inspectorStub) DescribeQuery(_ context.Context, q model.Query) (repository.QueryInfo, error) {
+	info, ok := s.queries[q.From]
+	if !ok {
+		return info, errors.New("ORA-00942: table or view does not exist")
+	}
+	return info, nil
+}
+
+func (s inspectorStub) GetPartRange(_ context.Context, groupId string) (int, int, bool, error) {
+	v, ok := s.parts[groupId]
+	return v[0], v[1], ok, nil
+}
+
+func (s inspectorStub) HasRowDependencies(context.Context) (bool, error) {
+	return !s.noRowDeps, nil
+}
+
+type topicsStub []string
+
+func (s topicsStub) Topics(context.Context) ([]string, error) {
+	return s, nil
+}
+
+func preflightTask(group, from, topic string) config.Task {
+	var v config.Task
+	v.GroupId = group
+	v.PartCount = 4
+	v.BatchSize = 1
+	v.Topic = topic
+	v.Query.Columns = "*"
+	v.Query.From = from
+	v.Query.PkColumn = "id"
+	return v
+}
+
+func TestPreflight(t *testing.T) {
+	db := inspectorStub{
+		queries: map[string]repository.QueryInfo{
+			"tab_1": {PkType: 2, ColumnCount: 8},
+			"tab_2": {PkType: 1, ColumnCount: 8},
+		},
+		parts: map[string][2]int{
+			"group_1": {0, 3},
+			"group_2": {0, 7},
+		},
+	}
+	ctx := context.Background()
+
+	tasks := map[string]config.Task{
+		"task_1": preflightTask("group_1", "tab_1", "topic_1"),
+	}
+	require.NoError(t, preflight(ctx, tasks, false, db, topicsStub{"topic_1"}))
+
+	tasks["task_2"] = preflightTask("group_2", "tab_2", "topic_2")
+	tasks["task_3"] = preflightTask("group_3", "tab_3", "topic_1")
+
+	// The missing topic is allowed if it is created automatically
+	err := preflight(ctx, tasks, true, db, topicsStub{"topic_1"})
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "topic")
+
+	// All the problems are reported
+	err = preflight(ctx, tasks, false, db, topicsStub{"topic_1"})
+	require.Error(t, err)
+
+	problems := strings.Split(err.Error(), "\n")
+	assert.Len(t, problems, 4)
+	assert.Contains(t, problems[0], `task[task_2]: pk column "id" is not numeric`)
+	assert.Contains(t, problems[1], `task[task_2]: topic "topic_2" does not exist`)
+	assert.Contains(t, problems[2], `task[task_2]: new events of group "group_2" use parts 0..7 out of part_count 4`)
+	assert.Contains(t, problems[3], `task[task_3]: invalid query`)
+
+	// The dlq topic is checked as the topic
+	v := tasks["task_1"]
+	v.DLQTopic = "dlq_1"
+	err = preflight(ctx, map[string]config.Task{"task_1": v}, false, db, topicsStub{"topic_1"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `task[task_1]: dlq topic "dlq_1" does not exist`)
+	require.NoError(t, preflight(ctx, map[string]config.Task{"task_1": v}, false, db, topicsStub{"topic_1", "dlq_1"}))
+
+	// The tx markers need the SCN of the rows
+	v.TxTopic = "dlq_1"
+	require.NoError(t, preflight(ctx, map[string]config.Task{"task_1": v}, false, db, topicsStub{"topic_1", "dlq_1"}))
+	db.noRowDeps = true
+	err = preflight(ctx, map[string]config.Task{"task_1": v}, false, db, topicsStub{"topic_1", "dlq_1"})
+	assert.ErrorContains(t, err, "task[task_1]: tx topic needs EVENT_LOG with rowdependencies")
+}
@@ -0,0 +1,162 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/eugene-vodyanko/orgonaut/internal/config"
+	"github.com/eugene-vodyanko/orgonaut/internal/controller/task"
+	"github.com/eugene-vodyanko/orgonaut/pkg/runner"
+	"log/slog"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// reloader applies the changes of the tasks configuration to the running runner.
+//
+// The new configuration is validated as a whole before applying, so an invalid configuration
+// is rejected and the old one keeps running. Only the tasks are reloaded:
+// new tasks are started, removed ones are stopped, and changed ones are restarted,
+// the parts of the unchanged tasks are not interrupted.
+type reloader struct {
+	path      string
+	r         *runner.Runner
+	newRoutes func(tasks map[string]config.Task) (map[string][]runner.Task, error)
+	onApply   func(tasks map[string]config.Task)
+
+	mu  sync.Mutex
+	cfg *config.Config
+}
+
+func newReloader(path string, cfg *config.Config, r *runner.Runner,
+	newRoutes func(tasks map[string]config.Task) (map[string][]runner.Task, error),
+	onApply func(tasks map[string]config.Task)) *reloader {
+
+	return &reloader{
+		path:      path,
+		cfg:       cfg,
+		r:         r,
+		newRoutes: newRoutes,
+		onApply:   onApply,
+	}
+}
+
+// reload re-reads the configuration file and applies the changes of the tasks.
+func (rl *reloader) reload() error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	slog.Info("app - reload config", "path", rl.path)
+
+	cfg, err := config.NewConfig(rl.path)
+	if err != nil {
+		return fmt.Errorf("app - reload config error: %w", err)
+	}
+
+	routes, err := rl.newRoutes(cfg.Tasks)
+	if err != nil {
+		return fmt.Errorf("app - reload routes error: %w", err)
+	}
+
+	if !sameSettings(rl.cfg, cfg) {
+		slog.Warn("app - reload config: only tasks are reloaded, other changes require restart")
+	}
+
+	var stopped, started []string
+	var tags []string
+
+	for k, v := range rl.cfg.Tasks {
+		if next, ok := cfg.Tasks[k]; !ok || !reflect.DeepEqual(v, next) {
+			stopped = append(stopped, k)
+			tags = append(tags, task.Tags(v)...)
+		}
+	}
+
+	for k, v := range cfg.Tasks {
+		if prev, ok := rl.cfg.Tasks[k]; !ok || !reflect.DeepEqual(prev, v) {
+			started = append(started, k)
+		}
+	}
+
+	if len(stopped) == 0 && len(started) == 0 {
+		slog.Info("app - reload config: tasks are not changed")
+		rl.cfg = cfg
+		return nil
+	}
+
+	// The parts are stopped concurrently, each waits for its in-flight handler
+	errs := make([]error, len(tags))
+	var wg sync.WaitGroup
+	for i, tag := range tags {
+		wg.Add(1)
+		go func(i int, tag string) {
+			defer wg.Done()
+			errs[i] = rl.r.RemoveTask(tag)
+		}(i, tag)
+	}
+	wg.Wait()
+
+	for _, k := range started {
+		for _, route := range routes[k] {
+			errs = append(errs, rl.r.AddTask(route))
+		}
+	}
+
+	rl.cfg = cfg
+
+	if rl.onApply != nil {
+		rl.onApply(cfg.Tasks)
+	}
+
+	slog.Info("app - reload config done", "stopped", stopped, "started", started)
+
+	if err = errors.Join(errs...); err != nil {
+		return fmt.Errorf("app - reload tasks error: %w", err)
+	}
+
+	return nil
+}
+
+// watch reloads the configuration when the modification time of the file changes.
+func (rl *reloader) watch(ctx context.Context, interval time.Duration) {
+	modTime := func() time.Time {
+		info, err := os.Stat(rl.path)
+		if err != nil {
+			slog.Error("app - watch config error", "err", err)
+			return time.Time{}
+		}
+		return info.ModTime()
+	}
+
+	last := modTime()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t := modTime()
+			if t.IsZero() || t.Equal(last) {
+				continue
+			}
+			last = t
+
+			if err := rl.reload(); err != nil {
+				slog.Error("app - config is rejected, the previous one keeps running", "err", err)
+			}
+		}
+	}
+}
+
+// sameSettings reports whether the configurations differ only in the tasks.
+func sameSettings(a, b *config.Config) bool {
+	x, y := *a, *b
+	x.Tasks, y.Tasks = nil, nil
+
+	return reflect.DeepEqual(x, y)
+}
@@ -0,0 +1,111 @@
+package app
+
+import (
+	"context"
+	"github.com/eugene-vodyanko/orgonaut/internal/config"
+	"github.com/eugene-vodyanko/orgonaut/internal/controller/task"
+	"github.com/eugene-vodyanko/orgonaut/internal/model"
+	"github.com/eugene-vodyanko/orgonaut/pkg/runner"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+type relayerStub struct{}
+
+func (relayerStub) Relay(context.Context, *model.Task) (uint16, error) {
+	return 0, nil
+}
+
+func (relayerStub) Snapshot(context.Context, *model.Task) (uint16, bool, error) {
+	return 0, true, nil
+}
+
+func taskYaml(name, group string, batchSize int) string {
+	return "  " + name + ":\n" +
+		"    group_id: " + group + "\n" +
+		"    part_count: 2\n" +
+		"    batch_size: " + strconv.Itoa(batchSize) + "\n" +
+		"    topic: topic\n" +
+		"    query:\n" +
+		"      columns: \"*\"\n" +
+		"      from: test_tab\n" +
+		"      pk_column: id\n"
+}
+
+func writeConfig(t *testing.T, path string, tasks ...string) {
+	t.Helper()
+
+	content := "tasks:\n"
+	for _, v := range tasks {
+		content += v
+	}
+
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+}
+
+func tags(r *runner.Runner) []string {
+	var result []string
+	for _, v := range r.Tasks() {
+		result = append(result, v.Tag)
+	}
+	return result
+}
+
+func TestReloader_reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "application.yml")
+	writeConfig(t, path,
+		taskYaml("task_1", "group_1", 1),
+		taskYaml("task_2", "group_2", 1),
+		taskYaml("task_3", "group_3", 1),
+	)
+
+	cfg, err := config.NewConfig(path)
+	require.NoError(t, err)
+
+	newRoutes := func(tasks map[string]config.Task) (map[string][]runner.Task, error) {
+		return task.NewRouteSet(tasks, relayerStub{})
+	}
+
+	routes, err := task.NewRoutes(cfg.Tasks, relayerStub{})
+	require.NoError(t, err)
+
+	r := runner.NewRunner("test", 10000, 10000, 2, 1, routes...)
+
+	ctx := context.Background()
+	require.NoError(t, r.RunTasks(ctx))
+	defer func() { _ = r.Stop(ctx) }()
+
+	var applied map[string]config.Task
+	rl := newReloader(path, cfg, r, newRoutes, func(tasks map[string]config.Task) {
+		applied = tasks
+	})
+
+	// Invalid config: duplicate group
+	writeConfig(t, path,
+		taskYaml("task_1", "group_1", 1),
+		taskYaml("task_2", "group_1", 1),
+	)
+	assert.Error(t, rl.reload())
+	assert.Nil(t, applied)
+	assert.Len(t, r.Tasks(), 6)
+
+	// Changed task_2, removed task_3, added task_4
+	writeConfig(t, path,
+		taskYaml("task_1", "group_1", 1),
+		taskYaml("task_2", "group_2", 2),
+		taskYaml("task_4", "group_4", 1),
+	)
+	require.NoError(t, rl.reload())
+
+	assert.Equal(t, []string{
+		"task_group_1_0", "task_group_1_1",
+		"task_group_2_0", "task_group_2_1",
+		"task_group_4_0", "task_group_4_1",
+	}, tags(r))
+	assert.Len(t, applied, 3)
+	assert.Equal(t, 2, rl.cfg.Tasks["task_2"].BatchSize)
+}
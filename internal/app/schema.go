@@ -0,0 +1,150 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"github.com/eugene-vodyanko/orgonaut/internal/config"
+	"github.com/eugene-vodyanko/orgonaut/internal/infrastructure/repository"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// The actions of the schema command
+const (
+	SchemaInstall = "install" // install the schema objects into the empty schema
+	SchemaUpgrade = "upgrade" // apply the pending migrations
+	SchemaStatus  = "status"  // print the migrations and the api version
+)
+
+// The migrations may re-create the packages, which takes longer than the other commands
+const _schemaTimeout = 5 * time.Minute
+
+// migrator provides the schema migrations (see repository.Repository).
+type migrator interface {
+	GetAppliedMigrations(ctx context.Context) ([]repository.AppliedMigration, bool, error)
+	ApplyMigration(ctx context.Context, m repository.Migration) error
+	GetAPIVersion(ctx context.Context) (int, error)
+	HasRowDependencies(ctx context.Context) (bool, error)
+}
+
+// Schema installs or upgrades the database objects of Orgonaut embedded into the application
+// by applying the pending migrations in order, or prints the state of the migrations.
+func Schema(cfg *config.Config, w io.Writer, action string) error {
+	if action != SchemaInstall && action != SchemaUpgrade && action != SchemaStatus {
+		return fmt.Errorf("app - unknown schema action: %q", action)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), _schemaTimeout)
+	defer cancel()
+
+	ora, err := newOracle(cfg)
+	if err != nil {
+		return fmt.Errorf("app - oracle init error: %w", err)
+	}
+	defer func() { _ = ora.Close() }()
+
+	repo := repository.NewRepository(cfg.DB.Schema, ora)
+
+	if action == SchemaStatus {
+		return schemaStatus(ctx, w, repo)
+	}
+
+	return migrate(ctx, w, repo, action == SchemaInstall)
+}
+
+// migrate applies the pending migrations, the install is refused if some migrations are applied.
+func migrate(ctx context.Context, w io.Writer, db migrator, install bool) error {
+	applied, _, err := db.GetAppliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	if install && len(applied) > 0 {
+		return fmt.Errorf("app - schema is already installed (version %d), use %q",
+			applied[len(applied)-1].Version, SchemaUpgrade)
+	}
+
+	done := make(map[int]bool, len(applied))
+	for _, v := range applied {
+		done[v.Version] = true
+	}
+
+	n := 0
+	for _, m := range repository.Migrations {
+		if done[m.Version] {
+			continue
+		}
+
+		if err = db.ApplyMigration(ctx, m); err != nil {
+			return err
+		}
+
+		_, _ = fmt.Fprintf(w, "applied %d %s\n", m.Version, m.Name)
+		n++
+	}
+
+	if n == 0 {
+		_, _ = fmt.Fprintln(w, "schema is up to date")
+	}
+
+	return checkAPIVersion(ctx, db)
+}
+
+func schemaStatus(ctx context.Context, w io.Writer, db migrator) error {
+	applied, ok, err := db.GetAppliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		_, _ = fmt.Fprintln(w, "migrations are not recorded (SCHEMA_MIGRATION table does not exist)")
+	}
+
+	byVersion := make(map[int]repository.AppliedMigration, len(applied))
+	for _, v := range applied {
+		byVersion[v.Version] = v
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+	for _, m := range repository.Migrations {
+		ts := "pending"
+		if v, ok := byVersion[m.Version]; ok {
+			ts = formatTime(v.AppliedTs)
+		}
+		_, _ = fmt.Fprintf(tw, "%d\t%s\t%s\n", m.Version, m.Name, ts)
+	}
+	_ = tw.Flush()
+
+	if deps, err := db.HasRowDependencies(ctx); err != nil {
+		_, _ = fmt.Fprintf(w, "event_log row dependencies: unknown (%v)\n", err)
+	} else if !deps {
+		_, _ = fmt.Fprintln(w, "event_log row dependencies: disabled, the commit order is approximate, "+
+			"rebuild EVENT_LOG (see README, Transactions)")
+	}
+
+	version, err := db.GetAPIVersion(ctx)
+	if err != nil {
+		_, _ = fmt.Fprintf(w, "api version: unknown (%v), required %d\n", err, repository.APIVersion)
+		return nil
+	}
+
+	_, _ = fmt.Fprintf(w, "api version: %d, required %d\n", version, repository.APIVersion)
+	return nil
+}
+
+// checkAPIVersion refuses the packages of another version of the API, they are upgraded by the schema command.
+func checkAPIVersion(ctx context.Context, db migrator) error {
+	version, err := db.GetAPIVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("app - api version of the schema is unknown, run \"orgonaut schema %s\": %w", SchemaUpgrade, err)
+	}
+
+	if version != repository.APIVersion {
+		return fmt.Errorf("app - api version %d of the schema is incompatible, %d is required, run \"orgonaut schema %s\"",
+			version, repository.APIVersion, SchemaUpgrade)
+	}
+
+	return nil
+}
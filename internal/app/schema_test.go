@@ -0,0 +1,61 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"github.com/eugene-vodyanko/orgonaut/internal/infrastructure/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type fakeMigrator struct {
+	applied    []repository.AppliedMigration
+	apiVersion int
+	noRowDeps  bool
+}
+
+func (f *fakeMigrator) GetAppliedMigrations(context.Context) ([]repository.AppliedMigration, bool, error) {
+	return f.applied, true, nil
+}
+
+func (f *fakeMigrator) ApplyMigration(_ context.Context, m repository.Migration) error {
+	f.applied = append(f.applied, repository.AppliedMigration{Version: m.Version, Name: m.Name})
+	f.apiVersion = repository.APIVersion
+	return nil
+}
+
+func (f *fakeMigrator) GetAPIVersion(context.Context) (int, error) {
+	return f.apiVersion, nil
+}
+
+func (f *fakeMigrator) HasRowDependencies(context.Context) (bool, error) {
+	return !f.noRowDeps, nil
+}
+
+func TestSchema_migrate(t *testing.T) {
+	ctx := context.Background()
+	db := &fakeMigrator{}
+	var out bytes.Buffer
+
+	// The packages of the unknown version are refused
+	assert.ErrorContains(t, checkAPIVersion(ctx, db), "incompatible")
+
+	require.NoError(t, migrate(ctx, &out, db, true))
+	assert.Len(t, db.applied, len(repository.Migrations))
+	assert.NoError(t, checkAPIVersion(ctx, db))
+
+	// The installed schema is upgraded only
+	assert.ErrorContains(t, migrate(ctx, &out, db, true), "already installed")
+
+	out.Reset()
+	require.NoError(t, migrate(ctx, &out, db, false))
+	assert.Equal(t, "schema is up to date\n", out.String())
+
+	// The pending migrations are applied in order
+	db.applied = db.applied[:1]
+	out.Reset()
+	require.NoError(t, migrate(ctx, &out, db, false))
+	assert.Len(t, db.applied, len(repository.Migrations))
+	assert.Contains(t, out.String(), "applied 2 ")
+}
@@ -0,0 +1,273 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"github.com/eugene-vodyanko/orgonaut/internal/config"
+	"github.com/eugene-vodyanko/orgonaut/internal/infrastructure/repository"
+	"github.com/eugene-vodyanko/orgonaut/internal/model"
+	"hash/crc32"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// The modes of the trigger command
+const (
+	TriggerPrint     = "print"     // print the install and uninstall scripts
+	TriggerInstall   = "install"   // create the trigger
+	TriggerUninstall = "uninstall" // drop the trigger
+)
+
+// tableRe matches the unquoted name of the table, optionally qualified by the owner.
+var tableRe = regexp.MustCompile(`^(?:([A-Za-z][\w$#]*)\.)?([A-Za-z][\w$#]*)$`)
+
+// triggerPrefix is the prefix of the trigger name, the name is limited to 30 characters.
+const triggerPrefix = "ORG$"
+
+// triggerNameRe matches the names of the triggers, which are valid unquoted identifiers.
+var triggerNameRe = regexp.MustCompile(`^[A-Z][A-Z0-9_$#]*$`)
+
+// Trigger generates the trigger of the table of the task, which publishes the insert, update and delete events
+// of the rows into the outbox of the group (see org$outbox_api). The table is the query source of the task,
+// unless it is set (e.g. the source is a view or a subquery). Depending on the mode, the install and uninstall
+// scripts are printed, or the trigger is created or dropped.
+func Trigger(cfg *config.Config, w io.Writer, name, table, mode string) error {
+	if mode != TriggerPrint && mode != TriggerInstall && mode != TriggerUninstall {
+		return fmt.Errorf("app - unknown trigger mode: %q", mode)
+	}
+
+	v, ok := cfg.Tasks[name]
+	if !ok {
+		return fmt.Errorf("app - task[%s] is not configured", name)
+	}
+
+	owner, tableName, err := triggerTable(v, table)
+	if err != nil {
+		return fmt.Errorf("app - task[%s] %w", name, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), _commandTimeout)
+	defer cancel()
+
+	ora, err := newOracle(cfg)
+	if err != nil {
+		return fmt.Errorf("app - oracle init error: %w", err)
+	}
+	defer func() { _ = ora.Close() }()
+
+	repo := repository.NewRepository(cfg.DB.Schema, ora)
+
+	info, err := repo.DescribeTable(ctx, owner, tableName)
+	if err != nil {
+		return err
+	}
+
+	if len(info.Columns) == 0 {
+		return fmt.Errorf("app - table %s.%s is not found", info.Owner, info.Name)
+	}
+
+	install, uninstall := triggerDDL(name, v, cfg.DB.Schema, info)
+
+	switch mode {
+	case TriggerInstall:
+		if err = checkTriggerTable(v, info); err != nil {
+			return fmt.Errorf("app - task[%s] %w", name, err)
+		}
+		if err = repo.ExecDDL(ctx, install); err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(w, "trigger %s is created\n", triggerName(info, v.GroupId))
+	case TriggerUninstall:
+		if err = repo.ExecDDL(ctx, uninstall); err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(w, "trigger %s is dropped\n", triggerName(info, v.GroupId))
+	default:
+		if err = checkTriggerTable(v, info); err != nil {
+			return fmt.Errorf("app - task[%s] %w", name, err)
+		}
+		_, _ = fmt.Fprintf(w, "-- Install\n%s\n/\n\n-- Uninstall\n%s;\n", install, uninstall)
+	}
+
+	return nil
+}
+
+// triggerTable returns the owner (empty - the current schema) and the name of the table of the trigger.
+func triggerTable(v config.Task, table string) (owner, name string, err error) {
+	if v.Mode == model.ModePayload {
+		return "", "", fmt.Errorf("trigger is not supported in %q mode", model.ModePayload)
+	}
+
+	if table == "" {
+		table = strings.TrimSpace(v.Query.From)
+	}
+
+	m := tableRe.FindStringSubmatch(table)
+	if m == nil {
+		return "", "", fmt.Errorf("query source %q is not a table name, the table must be set", table)
+	}
+
+	return strings.ToUpper(m[1]), strings.ToUpper(m[2]), nil
+}
+
+// checkTriggerTable refuses the tables, which keys the relay cannot handle:
+// the primary key must be the single numeric column, the pk column of the task.
+func checkTriggerTable(v config.Task, info repository.TableInfo) error {
+	switch {
+	case len(info.PkColumns) == 0:
+		return fmt.Errorf("table %s.%s has no primary key", info.Owner, info.Name)
+	case len(info.PkColumns) > 1:
+		return fmt.Errorf("table %s.%s has the composite primary key, only the single column key is supported",
+			info.Owner, info.Name)
+	}
+
+	pk := info.PkColumns[0]
+	if !strings.EqualFold(pk.Name, v.Query.PkColumn) {
+		return fmt.Errorf("primary key column %s of table %s.%s does not match pk_column %q",
+			pk.Name, info.Owner, info.Name, v.Query.PkColumn)
+	}
+
+	if !slices.Contains([]string{"NUMBER", "FLOAT", "BINARY_FLOAT", "BINARY_DOUBLE"}, pk.DataType) {
+		return fmt.Errorf("primary key column %s of table %s.%s is not numeric (%s)",
+			pk.Name, info.Owner, info.Name, pk.DataType)
+	}
+
+	return nil
+}
+
+// triggerName returns the name of the trigger of the table and the group: ORG$<TABLE>$<GROUP>, so the groups
+// of a table have their own triggers. If the name is longer than 30 characters or the group code is not valid
+// in the name, the name is truncated and ends with the checksum of the table and the group instead.
+func triggerName(info repository.TableInfo, groupId string) string {
+	name := triggerPrefix + info.Name + "$" + strings.ToUpper(groupId)
+	if len(name) > 30 || !triggerNameRe.MatchString(name) {
+		sum := fmt.Sprintf("$%08X", crc32.ChecksumIEEE([]byte(info.Name+"$"+groupId)))
+		name = triggerPrefix + info.Name
+		name = name[:min(len(name), 30-len(sum))] + sum
+	}
+	return info.Owner + "." + name
+}
+
+// triggerDDL makes the trigger of the table of the task (without the terminating slash) and the drop statement.
+// The before image of the updates and the deletes is captured if the task sends it (see Task.BeforeImage),
+// the values are formatted as the fields of the rows are (see org$xml_factory).
+func triggerDDL(name string, v config.Task, schema string, info repository.TableInfo) (string, string) {
+	trigger := triggerName(info, v.GroupId)
+	api := schema + ".org$outbox_api"
+
+	pk := `"` + strings.ToUpper(v.Query.PkColumn) + `"`
+	if len(info.PkColumns) == 1 {
+		pk = `"` + info.PkColumns[0].Name + `"`
+	}
+
+	put := func(proc, row, before string) string {
+		s := fmt.Sprintf("    %s.%s(p_group_id => c_group_id, p_key_n => :%s.%s, p_bucket_count => c_bucket_count",
+			api, proc, row, pk)
+		if before != "" {
+			s += ", p_before => " + before
+		}
+		return s + ");\n"
+	}
+
+	before := ""
+	var image, skipped []string
+	if v.BeforeImage == model.ImageFull || v.BeforeImage == model.ImageDiff {
+		before = "v_before"
+		image, skipped = imageValues(v.Query.Columns, info.Columns)
+	}
+
+	var b strings.Builder
+
+	_, _ = fmt.Fprintf(&b, "create or replace trigger %s\n", trigger)
+	_, _ = fmt.Fprintf(&b, "after insert or update or delete on %s.%s\n", info.Owner, info.Name)
+	b.WriteString("for each row\n")
+	// The comments are kept in the source of the trigger
+	_, _ = fmt.Fprintf(&b, "-- Generated by orgonaut for the task %s (group_id %s, part_count %d)\n",
+		name, v.GroupId, v.PartCount)
+	if len(skipped) > 0 {
+		_, _ = fmt.Fprintf(&b, "-- Not captured in the before image: %s\n", strings.Join(skipped, ", "))
+	}
+	b.WriteString("declare\n")
+	_, _ = fmt.Fprintf(&b, "  c_group_id constant varchar2(64) := '%s';\n", strings.ReplaceAll(v.GroupId, "'", "''"))
+	_, _ = fmt.Fprintf(&b, "  c_bucket_count constant number := %d;\n", v.PartCount)
+	if before != "" {
+		// The image of the wide rows exceeds the limit of varchar2
+		b.WriteString("  v_before clob;\n")
+	}
+	b.WriteString("begin\n")
+	if before != "" {
+		b.WriteString("  if updating or deleting then\n")
+		b.WriteString("    v_before := '{';\n")
+		for i, s := range image {
+			sep := ""
+			if i > 0 {
+				sep = "',' || "
+			}
+			_, _ = fmt.Fprintf(&b, "    v_before := v_before || %s%s.imageValue(%s);\n", sep, api, s)
+		}
+		b.WriteString("    v_before := v_before || '}';\n")
+		b.WriteString("  end if;\n\n")
+	}
+	b.WriteString("  if inserting then\n")
+	b.WriteString(put("putInsertEvent", "new", ""))
+	_, _ = fmt.Fprintf(&b, "  elsif updating and :new.%s <> :old.%s then\n", pk, pk)
+	b.WriteString("    -- The key is changed: the row of the old key is deleted, the row of the new key is inserted\n")
+	b.WriteString(put("putDeleteEvent", "old", before))
+	b.WriteString(put("putInsertEvent", "new", ""))
+	b.WriteString("  elsif updating then\n")
+	b.WriteString(put("putUpdateEvent", "new", before))
+	b.WriteString("  else\n")
+	b.WriteString(put("putDeleteEvent", "old", before))
+	b.WriteString("  end if;\n")
+	b.WriteString("end;")
+
+	return b.String(), "drop trigger " + trigger
+}
+
+// imageValues returns the arguments of imageValue for the columns of the query (all - "*"),
+// and the columns, which values cannot be captured (e.g. LOBs).
+func imageValues(queryColumns string, columns []repository.Column) (values []string, skipped []string) {
+	var selected []string
+	if strings.TrimSpace(queryColumns) != "*" {
+		for _, v := range strings.Split(queryColumns, ",") {
+			selected = append(selected, strings.ToUpper(strings.TrimSpace(v)))
+		}
+	}
+
+	for _, c := range columns {
+		if selected != nil && !slices.Contains(selected, c.Name) {
+			continue
+		}
+
+		expr := imageExpr(`:old."`+c.Name+`"`, c.DataType)
+		if expr == "" {
+			skipped = append(skipped, fmt.Sprintf("%s (%s)", c.Name, c.DataType))
+			continue
+		}
+
+		values = append(values, fmt.Sprintf("'%s', %s", strings.ToLower(c.Name), expr))
+	}
+
+	return values, skipped
+}
+
+// imageExpr returns the expression of the value of the column in the format of the fields, empty - not supported.
+func imageExpr(col, dataType string) string {
+	switch {
+	case dataType == "NUMBER" || dataType == "FLOAT" || dataType == "BINARY_FLOAT" || dataType == "BINARY_DOUBLE":
+		return "to_char(" + col + ", 'TM9', 'NLS_NUMERIC_CHARACTERS=''.,''')"
+	case dataType == "DATE":
+		return "to_char(" + col + ", 'YYYY-MM-DD HH24:MI:SS')"
+	case strings.HasPrefix(dataType, "TIMESTAMP") && strings.HasSuffix(dataType, " WITH TIME ZONE"):
+		return "to_char(" + col + `, 'YYYY-MM-DD"T"HH24:MI:SS.FF6 TZR')`
+	case strings.HasPrefix(dataType, "TIMESTAMP"):
+		return "to_char(" + col + ", 'YYYY-MM-DD HH24:MI:SS.FF6')"
+	case dataType == "VARCHAR2" || dataType == "CHAR" || dataType == "NVARCHAR2" || dataType == "NCHAR":
+		return col
+	case dataType == "RAW":
+		return "rawtohex(" + col + ")"
+	}
+	return ""
+}
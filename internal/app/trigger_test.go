@@ -0,0 +1,112 @@
+package app
+
+import (
+	"github.com/eugene-vodyanko/orgonaut/internal/config"
+	"github.com/eugene-vodyanko/orgonaut/internal/infrastructure/repository"
+	"github.com/eugene-vodyanko/orgonaut/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestTrigger_table(t *testing.T) {
+	v := config.Task{GroupId: "group_1", PartCount: 42}
+	v.Query.From = "test_tab"
+	v.Query.PkColumn = "id"
+
+	owner, name, err := triggerTable(v, "")
+	require.NoError(t, err)
+	assert.Equal(t, "", owner)
+	assert.Equal(t, "TEST_TAB", name)
+
+	owner, name, err = triggerTable(v, "app.orders")
+	require.NoError(t, err)
+	assert.Equal(t, "APP", owner)
+	assert.Equal(t, "ORDERS", name)
+
+	// The subquery is not a table
+	v.Query.From = "(select * from test_tab where id > 0)"
+	_, _, err = triggerTable(v, "")
+	assert.ErrorContains(t, err, "not a table name")
+
+	v.Mode = model.ModePayload
+	_, _, err = triggerTable(v, "test_tab")
+	assert.ErrorContains(t, err, "not supported")
+}
+
+func TestTrigger_check(t *testing.T) {
+	v := config.Task{GroupId: "group_1", PartCount: 42}
+	v.Query.PkColumn = "id"
+
+	info := repository.TableInfo{Owner: "ORGON", Name: "TEST_TAB"}
+	assert.ErrorContains(t, checkTriggerTable(v, info), "no primary key")
+
+	info.PkColumns = []repository.Column{{Name: "ID", DataType: "NUMBER"}, {Name: "VERSION", DataType: "NUMBER"}}
+	assert.ErrorContains(t, checkTriggerTable(v, info), "composite")
+
+	info.PkColumns = []repository.Column{{Name: "CODE", DataType: "VARCHAR2"}}
+	assert.ErrorContains(t, checkTriggerTable(v, info), "does not match")
+
+	v.Query.PkColumn = "code"
+	assert.ErrorContains(t, checkTriggerTable(v, info), "not numeric")
+
+	v.Query.PkColumn = "id"
+	info.PkColumns = []repository.Column{{Name: "ID", DataType: "NUMBER"}}
+	assert.NoError(t, checkTriggerTable(v, info))
+}
+
+func TestTrigger_DDL(t *testing.T) {
+	v := config.Task{GroupId: "group_1", PartCount: 42}
+	v.Query.Columns = "*"
+	v.Query.PkColumn = "id"
+
+	info := repository.TableInfo{
+		Owner: "APP",
+		Name:  "TEST_TAB",
+		Columns: []repository.Column{
+			{Name: "ID", DataType: "NUMBER"},
+			{Name: "COL_DATE", DataType: "DATE"},
+			{Name: "COL_TIMESTAMP", DataType: "TIMESTAMP(6)"},
+			{Name: "COL_VARCHAR", DataType: "VARCHAR2"},
+			{Name: "COL_CLOB", DataType: "CLOB"},
+		},
+		PkColumns: []repository.Column{{Name: "ID", DataType: "NUMBER"}},
+	}
+
+	install, uninstall := triggerDDL("task_1", v, "orgon", info)
+	assert.Equal(t, "drop trigger APP.ORG$TEST_TAB$GROUP_1", uninstall)
+	assert.Contains(t, install, "create or replace trigger APP.ORG$TEST_TAB$GROUP_1\nafter insert or update or delete on APP.TEST_TAB\n")
+	assert.Contains(t, install, "c_bucket_count constant number := 42;")
+	assert.Contains(t, install, "orgon.org$outbox_api.putInsertEvent(p_group_id => c_group_id, p_key_n => :new.\"ID\", "+
+		"p_bucket_count => c_bucket_count);")
+	assert.Contains(t, install, "orgon.org$outbox_api.putDeleteEvent(p_group_id => c_group_id, p_key_n => :old.\"ID\", "+
+		"p_bucket_count => c_bucket_count);")
+	assert.NotContains(t, install, "v_before")
+
+	v.BeforeImage = model.ImageDiff
+	v.Query.Columns = "id, col_date, col_clob"
+
+	install, _ = triggerDDL("task_1", v, "orgon", info)
+	assert.Contains(t, install, "-- Not captured in the before image: COL_CLOB (CLOB)\n")
+	assert.Contains(t, install, "  v_before clob;\n")
+	assert.Contains(t, install, "v_before := v_before || ',' || orgon.org$outbox_api.imageValue('col_date', "+
+		"to_char(:old.\"COL_DATE\", 'YYYY-MM-DD HH24:MI:SS'));")
+	assert.Contains(t, install, "p_bucket_count => c_bucket_count, p_before => v_before);")
+	assert.NotContains(t, install, "col_varchar")
+}
+
+func TestTrigger_name(t *testing.T) {
+	info := repository.TableInfo{Owner: "APP", Name: "TEST_TAB"}
+	assert.Equal(t, "APP.ORG$TEST_TAB$GROUP_1", triggerName(info, "group_1"))
+
+	// The groups of a table do not collide, the long and the invalid names are checksummed
+	names := map[string]bool{}
+	for _, group := range []string{"group_1", "group_2", "orders-v2", "orders_v2", "group_with_a_very_long_name_1",
+		"group_with_a_very_long_name_2"} {
+		name := triggerName(info, group)
+		assert.LessOrEqual(t, len(name)-len("APP."), 30)
+		assert.Regexp(t, `^APP\.ORG\$TEST_TAB\$[A-Z0-9_]+$`, name)
+		names[name] = true
+	}
+	assert.Len(t, names, 6)
+}
@@ -0,0 +1,107 @@
+package app
+
+import (
+	"context"
+	"github.com/eugene-vodyanko/orgonaut/internal/config"
+	"github.com/eugene-vodyanko/orgonaut/internal/controller/task"
+	"github.com/eugene-vodyanko/orgonaut/internal/infrastructure/repository"
+	"github.com/eugene-vodyanko/orgonaut/internal/model"
+	"github.com/eugene-vodyanko/orgonaut/pkg/runner"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const _listenRetryInterval = 5 * time.Second
+
+// checkSignal warns if the tasks are to be woken up on the alerts, but the signaling is disabled in the database,
+// so the tasks are polled only.
+func checkSignal(ctx context.Context, repo *repository.Repository) {
+	enabled, err := repo.IsSignalEnabled(ctx)
+	if err != nil {
+		slog.Warn("app - outbox signaling is unknown", "err", err)
+		return
+	}
+
+	if !enabled {
+		slog.Warn("app - wake_up is enabled, but the outbox signaling is disabled, the tasks are polled only " +
+			"(see org$outbox_api.setSignalEnabled)")
+	}
+}
+
+// alertListener wakes up the runner tasks on the alerts about new events in the outbox.
+// The polling of the runner remains as a fallback, so the listener is restarted on errors.
+type alertListener struct {
+	repo    *repository.Repository
+	r       *runner.Runner
+	timeout time.Duration
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newAlertListener(repo *repository.Repository, r *runner.Runner, timeout time.Duration) *alertListener {
+	return &alertListener{
+		repo:    repo,
+		r:       r,
+		timeout: timeout,
+	}
+}
+
+// restart (re)starts listening to the alerts of all the parts of the tasks.
+func (l *alertListener) restart(ctx context.Context, tasks map[string]config.Task) {
+	l.stop()
+
+	// The group may be consumed by several tasks (named consumers), all of them are woken up
+	parts := make(map[string]int, len(tasks))
+	keys := make(map[string][]string, len(tasks))
+	for _, v := range tasks {
+		parts[v.GroupId] = max(parts[v.GroupId], v.PartCount)
+		keys[v.GroupId] = append(keys[v.GroupId], model.GroupKey(v.GroupId, v.Consumer))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ctx, l.cancel = context.WithCancel(ctx)
+	l.done = make(chan struct{})
+
+	go func(done chan struct{}) {
+		defer close(done)
+		l.listen(ctx, parts, keys)
+	}(l.done)
+}
+
+// stop stops listening and waits for the listener session to be released.
+func (l *alertListener) stop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.cancel == nil {
+		return
+	}
+
+	l.cancel()
+	<-l.done
+	l.cancel = nil
+}
+
+func (l *alertListener) listen(ctx context.Context, parts map[string]int, keys map[string][]string) {
+	for {
+		err := l.repo.ListenAlerts(ctx, parts, l.timeout, func(groupId string, partId int) {
+			for _, key := range keys[groupId] {
+				l.r.Wake(task.Tag(key, partId))
+			}
+		})
+		if err != nil {
+			slog.Error("app - listen alerts error", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(_listenRetryInterval):
+		}
+	}
+}
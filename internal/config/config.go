@@ -13,6 +13,8 @@ type (
 		DB     Datasource      `yaml:"datasource"`
 		Kafka  Kafka           `yaml:"kafka"`
 		Runner Runner          `yaml:"runner"`
+		Reload Reload          `yaml:"reload"`
+		Admin  Admin           `yaml:"admin"`
 		Tasks  map[string]Task `yaml:"tasks"`
 	}
 
@@ -48,23 +50,73 @@ type (
 		Compress     bool     `yaml:"compress"`
 		CreateTopic  bool     `yaml:"topic_auto_create"`
 		MaxReqSize   int64    `yaml:"max_request_size"`
+
+		ProbeInterval int `yaml:"probe_interval"`
 	}
 
 	Runner struct {
-		MaxWorkers int `yaml:"max_workers"`
+		MaxWorkers     int `yaml:"max_workers"`
+		HandlerTimeout int `yaml:"handler_timeout"`
 
 		RepeatPolicy struct {
 			MaxInterval        int `yaml:"max_interval"`
 			InitialInterval    int `yaml:"initial_interval"`
 			BackoffCoefficient int `yaml:"backoff_coefficient"`
 		} `yaml:"repeat_policy"`
+
+		ErrorPolicy struct {
+			MaxInterval        int     `yaml:"max_interval"`
+			InitialInterval    int     `yaml:"initial_interval"`
+			BackoffCoefficient int     `yaml:"backoff_coefficient"`
+			Jitter             float64 `yaml:"jitter"`
+			FailureThreshold   int     `yaml:"failure_threshold"`
+			OpenInterval       int     `yaml:"open_interval"`
+		} `yaml:"error_policy"`
+
+		WakeUp struct {
+			Enabled     bool `yaml:"enabled"`
+			WaitTimeout int  `yaml:"wait_timeout"`
+		} `yaml:"wake_up"`
+
+		Shutdown struct {
+			GracePeriod int `yaml:"grace_period"`
+			Timeout     int `yaml:"timeout"`
+		} `yaml:"shutdown"`
+	}
+
+	Reload struct {
+		WatchInterval int `yaml:"watch_interval"`
+	}
+
+	Admin struct {
+		Address       string `yaml:"address"`
+		Token         string `yaml:"token"`
+		PersistPauses bool   `yaml:"persist_pauses"`
 	}
 
 	Task struct {
-		GroupId   string `yaml:"group_id"`
-		PartCount int    `yaml:"part_count"`
-		BatchSize int    `yaml:"batch_size"`
-		Topic     string `yaml:"topic"`
+		GroupId     string `yaml:"group_id"`
+		Consumer    string `yaml:"consumer"`
+		Mode        string `yaml:"mode"`
+		BeforeImage string `yaml:"before_image"`
+		Ordered     bool   `yaml:"ordered"`
+		Compaction  string `yaml:"compaction"`
+		Orphans     string `yaml:"orphans"`
+		DLQTopic    string `yaml:"dlq_topic"`
+		OnPoison    string `yaml:"on_poison"`
+		TxTopic     string `yaml:"tx_topic"`
+		PartCount   int    `yaml:"part_count"`
+		BatchSize   int    `yaml:"batch_size"`
+		Topic       string `yaml:"topic"`
+
+		HandlerTimeout int `yaml:"handler_timeout"`
+		Priority       int `yaml:"priority"`
+		Weight         int `yaml:"weight"`
+
+		Snapshot struct {
+			Enabled   bool `yaml:"enabled"`
+			BatchSize int  `yaml:"batch_size"`
+		} `yaml:"snapshot"`
 
 		Query struct {
 			Columns  string `yaml:"columns"`
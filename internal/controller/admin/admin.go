@@ -0,0 +1,399 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/eugene-vodyanko/orgonaut/internal/controller/task"
+	"github.com/eugene-vodyanko/orgonaut/internal/model"
+	"github.com/eugene-vodyanko/orgonaut/pkg/runner"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type (
+	// Runner controls the runner tasks (see runner.Runner).
+	Runner interface {
+		Tasks() []runner.TaskStatus
+		Pause(tag string) error
+		Resume(tag string) error
+		Wake(tag string) bool
+	}
+
+	// Router provides the runtime state of the routes (see task.Router).
+	Router interface {
+		Route(tag string) (task.RouteInfo, bool)
+		GroupTask(groupId string) (model.Task, bool)
+		SetBatchSize(tag string, batchSize int) error
+	}
+
+	// StateStore persists the pause state of the tasks.
+	StateStore interface {
+		SavePaused(ctx context.Context, tag string, paused bool) error
+		GetPaused(ctx context.Context) ([]string, error)
+	}
+)
+
+var (
+	errNotFound     = errors.New("not found")
+	errPaused       = errors.New("task is paused")
+	errUnauthorized = errors.New("unauthorized")
+	errNoToken      = errors.New("admin token is not configured (see admin.token), the changes are disabled")
+)
+
+// Controller implements the admin REST API to operate the replication tasks:
+// listing of the tasks and pause, resume, trigger-now and batch-size override
+// per task or per whole group, and the backfill jobs per group.
+type Controller struct {
+	runner Runner
+	router Router
+	store  StateStore
+	token  string
+
+	backfiller  Backfiller
+	backfillCtx context.Context
+	jobs        backfills
+}
+
+// New creates the admin controller. If the store is nil, the pause state is not persisted.
+func New(r Runner, router Router, store StateStore) *Controller {
+	return &Controller{
+		runner: r,
+		router: router,
+		store:  store,
+		jobs:   backfills{jobs: make(map[int]*backfillJob)},
+	}
+}
+
+// SetToken sets the bearer token required by the changing (non-GET) requests,
+// if it is empty, such requests are rejected.
+func (c *Controller) SetToken(token string) {
+	c.token = token
+}
+
+// Handler returns the HTTP handler of the admin API.
+func (c *Controller) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /api/health", c.health)
+	mux.HandleFunc("GET /api/tasks", c.listTasks)
+	mux.HandleFunc("GET /api/tasks/{tag}", c.getTask)
+	mux.HandleFunc("POST /api/tasks/{tag}/pause", c.byTask(c.pause))
+	mux.HandleFunc("POST /api/tasks/{tag}/resume", c.byTask(c.resume))
+	mux.HandleFunc("POST /api/tasks/{tag}/trigger", c.byTask(c.trigger))
+	mux.HandleFunc("PUT /api/tasks/{tag}/batch-size", c.byTask(c.setBatchSize))
+	mux.HandleFunc("POST /api/groups/{group}/pause", c.byGroup(c.pause))
+	mux.HandleFunc("POST /api/groups/{group}/resume", c.byGroup(c.resume))
+	mux.HandleFunc("POST /api/groups/{group}/trigger", c.byGroup(c.trigger))
+	mux.HandleFunc("PUT /api/groups/{group}/batch-size", c.byGroup(c.setBatchSize))
+	mux.HandleFunc("POST /api/groups/{group}/backfill", c.startBackfill)
+	mux.HandleFunc("GET /api/backfills", c.listBackfills)
+	mux.HandleFunc("GET /api/backfills/{id}", c.getBackfill)
+	mux.HandleFunc("DELETE /api/backfills/{id}", c.cancelBackfill)
+
+	return c.authorize(mux)
+}
+
+// authorize checks the bearer token of the changing requests, the reading ones are not authorized.
+func (c *Controller) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			if c.token == "" {
+				writeError(w, errNoToken)
+				return
+			}
+
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(c.token)) != 1 {
+				writeError(w, errUnauthorized)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RestorePaused pauses the tasks according to the persisted state.
+func (c *Controller) RestorePaused(ctx context.Context) error {
+	if c.store == nil {
+		return nil
+	}
+
+	tags, err := c.store.GetPaused(ctx)
+	if err != nil {
+		return fmt.Errorf("admin - restore paused error: %w", err)
+	}
+
+	for _, tag := range tags {
+		if err = c.runner.Pause(tag); err != nil && !errors.Is(err, runner.ErrTaskNotFound) {
+			return fmt.Errorf("admin - restore paused error: %w", err)
+		}
+	}
+
+	return nil
+}
+
+type taskView struct {
+	Tag                 string     `json:"tag"`
+	GroupId             string     `json:"group_id"`
+	Consumer            string     `json:"consumer,omitempty"`
+	PartId              int        `json:"part_id"`
+	Topic               string     `json:"topic,omitempty"`
+	Paused              bool       `json:"paused"`
+	Parked              bool       `json:"parked"`
+	Busy                bool       `json:"busy"`
+	State               string     `json:"state"`
+	Failures            int        `json:"failures"`
+	Backoff             int        `json:"backoff_ms"`
+	BatchSize           int        `json:"batch_size"`
+	BatchSizeOverridden bool       `json:"batch_size_overridden"`
+	Relayed             uint64     `json:"records_relayed"`
+	Snapshot            bool       `json:"snapshot,omitempty"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastErrorAt         *time.Time `json:"last_error_at,omitempty"`
+}
+
+type healthView struct {
+	Status string   `json:"status"`
+	Parked []string `json:"parked,omitempty"`
+}
+
+type batchSizeRequest struct {
+	BatchSize int `json:"batch_size"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// action is applied to a single task, the request body is already decoded into req.
+type action func(ctx context.Context, tag string, req *batchSizeRequest) error
+
+func (c *Controller) listTasks(w http.ResponseWriter, r *http.Request) {
+	tasks := c.runner.Tasks()
+
+	views := make([]taskView, 0, len(tasks))
+	for _, v := range tasks {
+		views = append(views, c.view(v))
+	}
+
+	writeJSON(w, http.StatusOK, views)
+}
+
+// health reports the tasks parked by the fatal errors, they need the intervention.
+func (c *Controller) health(w http.ResponseWriter, r *http.Request) {
+	view := healthView{Status: "ok"}
+	for _, v := range c.runner.Tasks() {
+		if v.Parked {
+			view.Parked = append(view.Parked, v.Tag)
+		}
+	}
+
+	if len(view.Parked) > 0 {
+		view.Status = "degraded"
+		writeJSON(w, http.StatusServiceUnavailable, view)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, view)
+}
+
+func (c *Controller) getTask(w http.ResponseWriter, r *http.Request) {
+	tag := r.PathValue("tag")
+
+	for _, v := range c.runner.Tasks() {
+		if v.Tag == tag {
+			writeJSON(w, http.StatusOK, c.view(v))
+			return
+		}
+	}
+
+	writeError(w, fmt.Errorf("task[%s]: %w", tag, errNotFound))
+}
+
+func (c *Controller) byTask(f action) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tag := r.PathValue("tag")
+
+		req, err := decodeRequest(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if err = f(r.Context(), tag, req); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		c.getTask(w, r)
+	}
+}
+
+func (c *Controller) byGroup(f action) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		group := r.PathValue("group")
+
+		req, err := decodeRequest(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		found := false
+		for _, v := range c.runner.Tasks() {
+			if v.Group != group {
+				continue
+			}
+			found = true
+
+			if err = f(r.Context(), v.Tag, req); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+
+		if !found {
+			writeError(w, fmt.Errorf("group[%s]: %w", group, errNotFound))
+			return
+		}
+
+		var views []taskView
+		for _, v := range c.runner.Tasks() {
+			if v.Group == group {
+				views = append(views, c.view(v))
+			}
+		}
+
+		writeJSON(w, http.StatusOK, views)
+	}
+}
+
+func (c *Controller) pause(ctx context.Context, tag string, _ *batchSizeRequest) error {
+	if err := c.runner.Pause(tag); err != nil {
+		return err
+	}
+
+	return c.savePaused(ctx, tag, true)
+}
+
+func (c *Controller) resume(ctx context.Context, tag string, _ *batchSizeRequest) error {
+	if err := c.runner.Resume(tag); err != nil {
+		return err
+	}
+
+	return c.savePaused(ctx, tag, false)
+}
+
+func (c *Controller) trigger(_ context.Context, tag string, _ *batchSizeRequest) error {
+	for _, v := range c.runner.Tasks() {
+		if v.Tag == tag && v.Paused {
+			return fmt.Errorf("task[%s]: %w", tag, errPaused)
+		}
+	}
+
+	if !c.runner.Wake(tag) {
+		return fmt.Errorf("task[%s]: %w", tag, errNotFound)
+	}
+
+	return nil
+}
+
+func (c *Controller) setBatchSize(_ context.Context, tag string, req *batchSizeRequest) error {
+	if req == nil {
+		return fmt.Errorf("batch_size is required")
+	}
+
+	if _, ok := c.router.Route(tag); !ok {
+		return fmt.Errorf("route[%s]: %w", tag, errNotFound)
+	}
+
+	return c.router.SetBatchSize(tag, req.BatchSize)
+}
+
+func (c *Controller) savePaused(ctx context.Context, tag string, paused bool) error {
+	if c.store == nil {
+		return nil
+	}
+
+	return c.store.SavePaused(ctx, tag, paused)
+}
+
+func (c *Controller) view(v runner.TaskStatus) taskView {
+	view := taskView{
+		Tag:      v.Tag,
+		GroupId:  v.Group,
+		Paused:   v.Paused,
+		Parked:   v.Parked,
+		Busy:     v.Busy,
+		State:    v.State.String(),
+		Failures: v.Failures,
+		Backoff:  v.Interval,
+	}
+
+	if info, ok := c.router.Route(v.Tag); ok {
+		view.GroupId = info.GroupId
+		view.Consumer = info.Consumer
+		view.PartId = info.PartId
+		view.Topic = info.Topic
+		view.BatchSize = info.BatchSize
+		view.BatchSizeOverridden = info.Overridden
+		view.Relayed = info.Relayed
+		view.Snapshot = info.Snapshot
+	}
+
+	if !v.LastSuccess.IsZero() {
+		view.LastSuccess = &v.LastSuccess
+	}
+
+	if v.LastError != nil {
+		view.LastError = v.LastError.Error()
+		view.LastErrorAt = &v.LastErrorAt
+	}
+
+	return view
+}
+
+func decodeRequest(r *http.Request) (*batchSizeRequest, error) {
+	if r.Method != http.MethodPut {
+		return nil, nil
+	}
+
+	var req batchSizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("invalid request body: %w", err)
+	}
+
+	return &req, nil
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, errNotFound), errors.Is(err, runner.ErrTaskNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, errPaused):
+		status = http.StatusConflict
+	case errors.Is(err, errUnauthorized):
+		status = http.StatusUnauthorized
+	case errors.Is(err, errNoToken):
+		status = http.StatusForbidden
+	}
+
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("admin - write response error", "err", err)
+	}
+}
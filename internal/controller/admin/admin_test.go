@@ -0,0 +1,316 @@
+package admin_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/eugene-vodyanko/orgonaut/internal/config"
+	"github.com/eugene-vodyanko/orgonaut/internal/controller/admin"
+	"github.com/eugene-vodyanko/orgonaut/internal/controller/task"
+	"github.com/eugene-vodyanko/orgonaut/internal/model"
+	"github.com/eugene-vodyanko/orgonaut/pkg/runner"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testToken = "secret"
+
+type relayerStub struct{}
+
+func (relayerStub) Relay(context.Context, *model.Task) (uint16, error) {
+	return 0, nil
+}
+
+func (relayerStub) Snapshot(context.Context, *model.Task) (uint16, bool, error) {
+	return 0, true, nil
+}
+
+type storeStub map[string]bool
+
+func (s storeStub) SavePaused(_ context.Context, tag string, paused bool) error {
+	s[tag] = paused
+	return nil
+}
+
+func (s storeStub) GetPaused(context.Context) ([]string, error) {
+	var tags []string
+	for k, v := range s {
+		if v {
+			tags = append(tags, k)
+		}
+	}
+	return tags, nil
+}
+
+type taskView struct {
+	Tag                 string `json:"tag"`
+	GroupId             string `json:"group_id"`
+	PartId              int    `json:"part_id"`
+	Paused              bool   `json:"paused"`
+	BatchSize           int    `json:"batch_size"`
+	BatchSizeOverridden bool   `json:"batch_size_overridden"`
+}
+
+func newServer(t *testing.T, store admin.StateStore) (*httptest.Server, *runner.Runner) {
+	t.Helper()
+
+	var v config.Task
+	v.GroupId = "group_1"
+	v.PartCount = 2
+	v.BatchSize = 100
+	v.Topic = "topic"
+	v.Query.Columns = "*"
+	v.Query.From = "test_tab"
+	v.Query.PkColumn = "id"
+
+	router := task.NewRouter(relayerStub{})
+	routes, err := router.NewRoutes(map[string]config.Task{"task_1": v})
+	require.NoError(t, err)
+
+	r := runner.NewRunner("test", 10000, 10000, 2, 1, routes...)
+
+	ctl := admin.New(r, router, store)
+	ctl.SetToken(testToken)
+	require.NoError(t, ctl.RestorePaused(context.Background()))
+
+	srv := httptest.NewServer(ctl.Handler())
+	t.Cleanup(srv.Close)
+
+	return srv, r
+}
+
+func do(t *testing.T, method, url, body string, v any) int {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+testToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	if v != nil && resp.StatusCode == http.StatusOK {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+	}
+
+	return resp.StatusCode
+}
+
+func TestController_authorize(t *testing.T) {
+	srv, _ := newServer(t, nil)
+
+	post := func(token string) int {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/api/tasks/task_group_1_0/trigger", nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, post(""))
+	assert.Equal(t, http.StatusUnauthorized, post("wrong"))
+	assert.Equal(t, http.StatusOK, post(testToken))
+
+	// The reading requests are not authorized
+	resp, err := http.Get(srv.URL + "/api/tasks")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Without the token the changes are disabled
+	ctl := admin.New(runner.NewRunner("test", 10000, 10000, 2, 1), task.NewRouter(relayerStub{}), nil)
+	readOnly := httptest.NewServer(ctl.Handler())
+	defer readOnly.Close()
+
+	resp, err = http.Post(readOnly.URL+"/api/groups/group_1/pause", "application/json", nil)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestController_Tasks(t *testing.T) {
+	srv, _ := newServer(t, nil)
+
+	var views []taskView
+	assert.Equal(t, http.StatusOK, do(t, http.MethodGet, srv.URL+"/api/tasks", "", &views))
+	require.Len(t, views, 2)
+	assert.Equal(t, "task_group_1_1", views[1].Tag)
+	assert.Equal(t, "group_1", views[1].GroupId)
+	assert.Equal(t, 1, views[1].PartId)
+	assert.Equal(t, 100, views[1].BatchSize)
+
+	assert.Equal(t, http.StatusNotFound, do(t, http.MethodGet, srv.URL+"/api/tasks/task_unknown_0", "", nil))
+	assert.Equal(t, http.StatusNotFound, do(t, http.MethodPost, srv.URL+"/api/groups/unknown/pause", "", nil))
+}
+
+func TestController_PauseResume(t *testing.T) {
+	store := storeStub{"task_group_1_0": true}
+	srv, r := newServer(t, store)
+
+	// Restored from the store
+	var view taskView
+	assert.Equal(t, http.StatusOK, do(t, http.MethodGet, srv.URL+"/api/tasks/task_group_1_0", "", &view))
+	assert.True(t, view.Paused)
+
+	// Trigger is rejected for the paused task
+	assert.Equal(t, http.StatusConflict, do(t, http.MethodPost, srv.URL+"/api/tasks/task_group_1_0/trigger", "", nil))
+
+	var views []taskView
+	assert.Equal(t, http.StatusOK, do(t, http.MethodPost, srv.URL+"/api/groups/group_1/pause", "", &views))
+	for _, v := range r.Tasks() {
+		assert.True(t, v.Paused, v.Tag)
+	}
+	assert.Equal(t, storeStub{"task_group_1_0": true, "task_group_1_1": true}, store)
+
+	assert.Equal(t, http.StatusOK, do(t, http.MethodPost, srv.URL+"/api/tasks/task_group_1_1/resume", "", &view))
+	assert.False(t, view.Paused)
+	assert.False(t, store["task_group_1_1"])
+
+	assert.Equal(t, http.StatusOK, do(t, http.MethodPost, srv.URL+"/api/tasks/task_group_1_1/trigger", "", nil))
+}
+
+func TestController_BatchSize(t *testing.T) {
+	srv, _ := newServer(t, nil)
+
+	var views []taskView
+	assert.Equal(t, http.StatusOK,
+		do(t, http.MethodPut, srv.URL+"/api/groups/group_1/batch-size", `{"batch_size": 500}`, &views))
+	require.Len(t, views, 2)
+	for _, v := range views {
+		assert.Equal(t, 500, v.BatchSize)
+		assert.True(t, v.BatchSizeOverridden)
+	}
+
+	var view taskView
+	assert.Equal(t, http.StatusOK,
+		do(t, http.MethodPut, srv.URL+"/api/tasks/task_group_1_0/batch-size", `{"batch_size": 0}`, &view))
+	assert.Equal(t, 100, view.BatchSize)
+	assert.False(t, view.BatchSizeOverridden)
+
+	assert.Equal(t, http.StatusBadRequest,
+		do(t, http.MethodPut, srv.URL+"/api/tasks/task_group_1_0/batch-size", `{"batch_size": -1}`, nil))
+	assert.Equal(t, http.StatusBadRequest,
+		do(t, http.MethodPut, srv.URL+"/api/tasks/task_group_1_0/batch-size", `oops`, nil))
+}
+
+func TestController_Health(t *testing.T) {
+	srv, _ := newServer(t, nil)
+
+	var view struct {
+		Status string   `json:"status"`
+		Parked []string `json:"parked"`
+	}
+	assert.Equal(t, http.StatusOK, do(t, http.MethodGet, srv.URL+"/api/health", "", &view))
+	assert.Equal(t, "ok", view.Status)
+	assert.Empty(t, view.Parked)
+}
+
+type backfillerStub struct {
+	task   chan model.Task
+	filter chan model.Filter
+}
+
+func (s backfillerStub) Backfill(ctx context.Context, task *model.Task, filter model.Filter,
+	progress func(sent int)) (int, error) {
+
+	s.task <- *task
+	s.filter <- filter
+	progress(10)
+	return 10, nil
+}
+
+func TestController_BackfillDisabled(t *testing.T) {
+	srv, _ := newServer(t, nil)
+	assert.Equal(t, http.StatusBadRequest,
+		do(t, http.MethodPost, srv.URL+"/api/groups/group_1/backfill", `{"from_pk":"1"}`, nil))
+}
+
+func TestController_BackfillJob(t *testing.T) {
+	var v config.Task
+	v.GroupId = "group_1"
+	v.PartCount = 1
+	v.BatchSize = 100
+	v.Topic = "topic"
+	v.Query.Columns = "*"
+	v.Query.From = "test_tab"
+	v.Query.PkColumn = "id"
+
+	router := task.NewRouter(relayerStub{})
+	routes, err := router.NewRoutes(map[string]config.Task{"task_1": v})
+	require.NoError(t, err)
+
+	r := runner.NewRunner("test", 10000, 10000, 2, 1, routes...)
+
+	stub := backfillerStub{task: make(chan model.Task, 1), filter: make(chan model.Filter, 1)}
+
+	ctl := admin.New(r, router, nil)
+	ctl.SetToken(testToken)
+	ctl.SetBackfiller(context.Background(), stub)
+
+	srv := httptest.NewServer(ctl.Handler())
+	defer srv.Close()
+
+	type job struct {
+		Id    int    `json:"id"`
+		State string `json:"state"`
+		Sent  int    `json:"records_sent"`
+	}
+
+	// Invalid filter and unknown group
+	assert.Equal(t, http.StatusBadRequest,
+		do(t, http.MethodPost, srv.URL+"/api/groups/group_1/backfill", `{"keys":["x"]}`, nil))
+	assert.Equal(t, http.StatusBadRequest,
+		do(t, http.MethodPost, srv.URL+"/api/groups/group_1/backfill",
+			`{"conditions":[{"column":"1=1 or region","op":"=","value":"EU"}]}`, nil))
+	assert.Equal(t, http.StatusBadRequest,
+		do(t, http.MethodPost, srv.URL+"/api/groups/group_1/backfill",
+			`{"conditions":[{"column":"region","op":"in (select","value":"EU"}]}`, nil))
+	assert.Equal(t, http.StatusBadRequest,
+		do(t, http.MethodPost, srv.URL+"/api/groups/group_1/backfill", `{"where":"1=1"}`, nil))
+	assert.Equal(t, http.StatusBadRequest,
+		do(t, http.MethodPost, srv.URL+"/api/groups/group_1/backfill",
+			`{"keys":[`+strings.Repeat(`"1",`, model.MaxFilterKeys)+`"1"]}`, nil))
+	assert.Equal(t, http.StatusNotFound,
+		do(t, http.MethodPost, srv.URL+"/api/groups/unknown/backfill", `{"keys":["1"]}`, nil))
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/api/groups/group_1/backfill",
+		strings.NewReader(`{"from_pk":"1","to_pk":"100","conditions":[{"column":"region","op":"=","value":"EU"}],"batch_size":500}`))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	var started job
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&started))
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, 1, started.Id)
+
+	got := <-stub.task
+	assert.Equal(t, 500, got.BatchSize)
+	assert.Equal(t, "test_tab", got.Query.From)
+	assert.Equal(t, model.Filter{FromPk: "1", ToPk: "100",
+		Conditions: []model.Condition{{Column: "region", Op: "=", Value: "EU"}}}, <-stub.filter)
+
+	var finished job
+	deadline := time.Now().Add(time.Second)
+	for finished.State != "done" && time.Now().Before(deadline) {
+		do(t, http.MethodGet, srv.URL+"/api/backfills/1", "", &finished)
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, job{Id: 1, State: "done", Sent: 10}, finished)
+
+	var jobs []job
+	assert.Equal(t, http.StatusOK, do(t, http.MethodGet, srv.URL+"/api/backfills", "", &jobs))
+	assert.Len(t, jobs, 1)
+	assert.Equal(t, http.StatusNotFound, do(t, http.MethodGet, srv.URL+"/api/backfills/2", "", nil))
+}
@@ -0,0 +1,196 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/eugene-vodyanko/orgonaut/internal/model"
+	"github.com/eugene-vodyanko/orgonaut/pkg/runner"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Backfiller re-emits the current state of the rows (see service.RelayService).
+type Backfiller interface {
+	Backfill(ctx context.Context, task *model.Task, filter model.Filter, progress func(sent int)) (int, error)
+}
+
+// Job states
+const (
+	jobRunning   = "running"
+	jobDone      = "done"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+type backfillRequest struct {
+	model.Filter
+	BatchSize int `json:"batch_size,omitempty"`
+}
+
+type backfillJob struct {
+	Id        int          `json:"id"`
+	GroupId   string       `json:"group_id"`
+	Filter    model.Filter `json:"filter"`
+	BatchSize int          `json:"batch_size"`
+	State     string       `json:"state"`
+	Sent      int          `json:"records_sent"`
+	Error     string       `json:"error,omitempty"`
+	Started   time.Time    `json:"started"`
+	Finished  *time.Time   `json:"finished,omitempty"`
+
+	cancel context.CancelFunc
+}
+
+// backfills keeps the backfill jobs started by the admin API (until the restart).
+type backfills struct {
+	mu     sync.Mutex
+	nextId int
+	jobs   map[int]*backfillJob
+}
+
+// SetBackfiller enables the backfill jobs, the jobs are cancelled by ctx.
+func (c *Controller) SetBackfiller(ctx context.Context, b Backfiller) {
+	c.backfiller = b
+	c.backfillCtx = ctx
+}
+
+func (c *Controller) startBackfill(w http.ResponseWriter, r *http.Request) {
+	group := r.PathValue("group")
+
+	if c.backfiller == nil {
+		writeError(w, fmt.Errorf("backfill is not supported"))
+		return
+	}
+
+	found := slices.ContainsFunc(c.runner.Tasks(), func(v runner.TaskStatus) bool { return v.Group == group })
+	task, ok := c.router.GroupTask(group)
+	if !found || !ok {
+		writeError(w, fmt.Errorf("group[%s]: %w", group, errNotFound))
+		return
+	}
+
+	var req backfillRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+
+	if err := req.Filter.Validate(); err != nil {
+		writeError(w, fmt.Errorf("invalid filter: %w", err))
+		return
+	}
+
+	if req.BatchSize > 0 {
+		task.BatchSize = req.BatchSize
+	}
+
+	ctx, cancel := context.WithCancel(c.backfillCtx)
+
+	c.jobs.mu.Lock()
+	c.jobs.nextId++
+	job := &backfillJob{
+		Id:        c.jobs.nextId,
+		GroupId:   group,
+		Filter:    req.Filter,
+		BatchSize: task.BatchSize,
+		State:     jobRunning,
+		Started:   time.Now(),
+		cancel:    cancel,
+	}
+	c.jobs.jobs[job.Id] = job
+	view := *job
+	c.jobs.mu.Unlock()
+
+	go c.runBackfill(ctx, job, &task)
+
+	writeJSON(w, http.StatusAccepted, view)
+}
+
+func (c *Controller) runBackfill(ctx context.Context, job *backfillJob, task *model.Task) {
+	slog.Info(fmt.Sprintf("admin - backfill[%d] started", job.Id), "group_id", job.GroupId, "filter", job.Filter)
+
+	sent, err := c.backfiller.Backfill(ctx, task, job.Filter, func(sent int) {
+		c.jobs.mu.Lock()
+		job.Sent = sent
+		c.jobs.mu.Unlock()
+	})
+
+	c.jobs.mu.Lock()
+	defer c.jobs.mu.Unlock()
+
+	now := time.Now()
+	job.Sent = sent
+	job.Finished = &now
+	job.cancel()
+
+	switch {
+	case err == nil:
+		job.State = jobDone
+		slog.Info(fmt.Sprintf("admin - backfill[%d] done", job.Id), "sent", sent)
+	case ctx.Err() != nil:
+		job.State = jobCancelled
+		slog.Info(fmt.Sprintf("admin - backfill[%d] cancelled", job.Id), "sent", sent)
+	default:
+		job.State = jobFailed
+		job.Error = err.Error()
+		slog.Error(fmt.Sprintf("admin - backfill[%d] failed", job.Id), "sent", sent, "err", err)
+	}
+}
+
+func (c *Controller) listBackfills(w http.ResponseWriter, r *http.Request) {
+	c.jobs.mu.Lock()
+	views := make([]backfillJob, 0, len(c.jobs.jobs))
+	for _, v := range c.jobs.jobs {
+		views = append(views, *v)
+	}
+	c.jobs.mu.Unlock()
+
+	slices.SortFunc(views, func(a, b backfillJob) int { return a.Id - b.Id })
+
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (c *Controller) getBackfill(w http.ResponseWriter, r *http.Request) {
+	job, err := c.backfillJob(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (c *Controller) cancelBackfill(w http.ResponseWriter, r *http.Request) {
+	job, err := c.backfillJob(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	job.cancel()
+
+	writeJSON(w, http.StatusOK, job)
+}
+
+// backfillJob returns a copy of the job by the id from the path.
+func (c *Controller) backfillJob(r *http.Request) (backfillJob, error) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return backfillJob{}, fmt.Errorf("invalid backfill id: %w", err)
+	}
+
+	c.jobs.mu.Lock()
+	defer c.jobs.mu.Unlock()
+
+	job, ok := c.jobs.jobs[id]
+	if !ok {
+		return backfillJob{}, fmt.Errorf("backfill[%d]: %w", id, errNotFound)
+	}
+
+	return *job, nil
+}
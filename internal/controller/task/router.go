@@ -7,12 +7,57 @@ import (
 	"github.com/eugene-vodyanko/orgonaut/internal/model"
 	"github.com/eugene-vodyanko/orgonaut/pkg/runner"
 	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/eugene-vodyanko/orgonaut/internal/service"
 )
 
-type router struct {
+// Router sets up the runner tasks (routes) for the configured tasks
+// and keeps the runtime state of the routes: the relay statistics and the batch size overrides.
+// It is safe for concurrent use.
+type Router struct {
 	srv service.Relayer
+
+	mu        sync.RWMutex
+	routes    map[string]*route
+	snapshots map[string]*atomic.Bool
+}
+
+// route is the runtime state of the runner task for the part of the group.
+// The state is kept by the tag, so it is shared by the handlers set up for the same part again (e.g. on config reload).
+type route struct {
+	// task is the last task used by the handlers of the route
+	task      atomic.Pointer[model.Task]
+	batchSize atomic.Int64
+	relayed   atomic.Uint64
+}
+
+// RouteInfo describes the runtime state of the route.
+type RouteInfo struct {
+	Tag      string
+	GroupId  string
+	Consumer string
+	PartId   int
+	Topic    string
+	// BatchSize is the effective batch size (configured or overridden).
+	BatchSize int
+	// Overridden is true if the configured batch size is overridden.
+	Overridden bool
+	// Relayed is the number of records relayed since the start.
+	Relayed uint64
+	// Snapshot is true for the route of the snapshot (initial load) of the group.
+	Snapshot bool
+}
+
+func NewRouter(s service.Relayer) *Router {
+	return &Router{
+		srv:       s,
+		routes:    make(map[string]*route),
+		snapshots: make(map[string]*atomic.Bool),
+	}
 }
 
 // NewRoutes sets up handlers for the provided configuration.
@@ -20,52 +65,357 @@ type router struct {
 // each certain part_id: from 0 to part_count - 1).
 // In other words, the total number of jobs is equal to the sum of all the part_count jobs.
 func NewRoutes(tasks map[string]config.Task, s service.Relayer) ([]runner.Task, error) {
-	r := &router{srv: s}
+	return NewRouter(s).NewRoutes(tasks)
+}
+
+// NewRouteSet sets up handlers for the provided configuration grouped by the task name.
+func NewRouteSet(tasks map[string]config.Task, s service.Relayer) (map[string][]runner.Task, error) {
+	return NewRouter(s).NewRouteSet(tasks)
+}
+
+// NewRoutes sets up handlers for the provided configuration (see package-level NewRoutes).
+func (r *Router) NewRoutes(tasks map[string]config.Task) ([]runner.Task, error) {
+	set, err := r.NewRouteSet(tasks)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(set))
+	for k := range set {
+		names = append(names, k)
+	}
+	slices.Sort(names)
 
 	var task []runner.Task
+	for _, k := range names {
+		task = append(task, set[k]...)
+	}
+	return task, nil
+}
+
+// NewRouteSet sets up handlers for the provided configuration grouped by the task name.
+// The group codes of the tasks must be unique, since a group can be consumed by a single task only,
+// unless the tasks are named consumers of the group (each consumer tracks its own position in the outbox).
+func (r *Router) NewRouteSet(tasks map[string]config.Task) (map[string][]runner.Task, error) {
+	groups := make(map[string]string, len(tasks))
+	set := make(map[string][]runner.Task, len(tasks))
 
 	for k, v := range tasks {
-		for i := 0; i < v.PartCount; i++ {
-			t := &model.Task{
-				BatchSize: v.BatchSize,
-				GroupId:   v.GroupId,
-				PartId:    i,
-			}
-
-			t.Query.From = v.Query.From
-			t.Query.Columns = v.Query.Columns
-			t.Query.PkColumn = v.Query.PkColumn
-			t.Topic = v.Topic
-
-			err := t.Validate()
-			if err != nil {
-				return nil, fmt.Errorf("router - task[%s] validation error: %w", k, err)
-			}
-
-			task = append(task, r.newRoute(t))
+		key := model.GroupKey(v.GroupId, v.Consumer)
+		if other, ok := groups[key]; ok {
+			return nil, fmt.Errorf("router - task[%s] validation error: group_id %q (consumer %q) is already used by task[%s]",
+				k, v.GroupId, v.Consumer, other)
+		}
+		groups[key] = k
+
+		routes, err := r.NewTaskRoutes(k, v)
+		if err != nil {
+			return nil, err
 		}
+		set[k] = routes
 	}
+	return set, nil
+}
+
+// NewTaskRoutes sets up handlers for each part of the single task.
+//
+// If the snapshot is enabled, the route of the snapshot (initial load) is set up as well,
+// and the parts do not relay the outbox events until the snapshot is complete: the changes made
+// during the snapshot are kept in the outbox and relayed after it, so none of them is lost.
+func (r *Router) NewTaskRoutes(name string, v config.Task) ([]runner.Task, error) {
+	var task []runner.Task
+
+	if err := model.ValidateGroup(v.GroupId, v.PartCount); err != nil {
+		return nil, fmt.Errorf("router - task[%s] validation error: %w", name, err)
+	}
+
+	// The snapshot reads the rows of the query
+	if v.Mode == model.ModePayload && v.Snapshot.Enabled {
+		return nil, fmt.Errorf("router - task[%s] validation error: snapshot is not supported in %q mode",
+			name, model.ModePayload)
+	}
+
+	key := model.GroupKey(v.GroupId, v.Consumer)
+
+	var snapshot *atomic.Bool
+	if v.Snapshot.Enabled {
+		snapshot = r.snapshotState(key)
+	}
+
+	for i := 0; i < v.PartCount; i++ {
+		t := &model.Task{
+			BatchSize:   v.BatchSize,
+			GroupId:     v.GroupId,
+			Consumer:    v.Consumer,
+			Mode:        v.Mode,
+			BeforeImage: v.BeforeImage,
+			Ordered:     v.Ordered,
+			Compaction:  v.Compaction,
+			Orphans:     v.Orphans,
+			DLQTopic:    v.DLQTopic,
+			OnPoison:    v.OnPoison,
+			TxTopic:     v.TxTopic,
+			PartId:      i,
+		}
+
+		t.Query.From = v.Query.From
+		t.Query.Columns = v.Query.Columns
+		t.Query.PkColumn = v.Query.PkColumn
+		t.Topic = v.Topic
+
+		err := t.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("router - task[%s] validation error: %w", name, err)
+		}
+
+		rt := r.newRoute(t, snapshot)
+		rt.Timeout = time.Duration(v.HandlerTimeout) * time.Millisecond
+		rt.Group = key
+		rt.Priority = v.Priority
+		rt.Weight = v.Weight
+
+		task = append(task, rt)
+	}
+
+	if snapshot != nil {
+		t := &model.Task{
+			BatchSize: v.Snapshot.BatchSize,
+			GroupId:   v.GroupId,
+			Consumer:  v.Consumer,
+			DLQTopic:  v.DLQTopic,
+			OnPoison:  v.OnPoison,
+			Topic:     v.Topic,
+		}
+		if t.BatchSize == 0 {
+			t.BatchSize = v.BatchSize
+		}
+
+		t.Query.From = v.Query.From
+		t.Query.Columns = v.Query.Columns
+		t.Query.PkColumn = v.Query.PkColumn
+
+		rt := r.newSnapshotRoute(t, snapshot)
+		rt.Timeout = time.Duration(v.HandlerTimeout) * time.Millisecond
+		rt.Group = key
+		rt.Priority = v.Priority
+		rt.Weight = v.Weight
+
+		task = append(task, rt)
+	}
+
 	return task, nil
 }
-func (r *router) newRoute(task *model.Task) runner.Task {
-	tag := fmt.Sprintf("task_%s_%d", task.GroupId, task.PartId)
+
+// GroupTask returns the task of the group (the query and the topic), as it is used by the last handler call.
+// The group of the named consumer is referred by the key "<group_id>@<consumer>" (see model.GroupKey).
+func (r *Router) GroupTask(key string) (model.Task, bool) {
+	r.mu.RLock()
+	rt, ok := r.routes[Tag(key, 0)]
+	r.mu.RUnlock()
+
+	if !ok {
+		return model.Task{}, false
+	}
+
+	return *rt.task.Load(), true
+}
+
+// Route returns the runtime state of the route with the tag.
+func (r *Router) Route(tag string) (RouteInfo, bool) {
+	r.mu.RLock()
+	rt, ok := r.routes[tag]
+	r.mu.RUnlock()
+
+	if !ok {
+		return RouteInfo{}, false
+	}
+
+	task := rt.task.Load()
+
+	batchSize := task.BatchSize
+	overridden := false
+	if v := rt.batchSize.Load(); v > 0 {
+		batchSize = int(v)
+		overridden = true
+	}
+
+	return RouteInfo{
+		Tag:        tag,
+		GroupId:    task.GroupId,
+		Consumer:   task.Consumer,
+		PartId:     task.PartId,
+		Topic:      task.Topic,
+		BatchSize:  batchSize,
+		Overridden: overridden,
+		Relayed:    rt.relayed.Load(),
+		Snapshot:   tag == SnapshotTag(task.Key()),
+	}, true
+}
+
+// SetBatchSize overrides the configured batch size of the route, zero resets the override.
+// The override is kept when the route is set up again (e.g. on config reload).
+func (r *Router) SetBatchSize(tag string, batchSize int) error {
+	if batchSize < 0 {
+		return fmt.Errorf("router - invalid batch size: %d", batchSize)
+	}
+
+	r.mu.RLock()
+	rt, ok := r.routes[tag]
+	r.mu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("router - route[%s] not found", tag)
+	}
+
+	rt.batchSize.Store(int64(batchSize))
+	slog.Info(fmt.Sprintf("router - route[%s] batch size is set", tag), "batch_size", batchSize)
+
+	return nil
+}
+
+// Tags returns the runner task tags of all the parts of the task (and of the snapshot, if enabled).
+func Tags(v config.Task) []string {
+	key := model.GroupKey(v.GroupId, v.Consumer)
+
+	tags := make([]string, 0, v.PartCount+1)
+	for i := 0; i < v.PartCount; i++ {
+		tags = append(tags, Tag(key, i))
+	}
+	if v.Snapshot.Enabled {
+		tags = append(tags, SnapshotTag(key))
+	}
+	return tags
+}
+
+// Tag returns the runner task tag of the part of the group (the key of the group and the consumer, see model.GroupKey).
+func Tag(key string, partId int) string {
+	return fmt.Sprintf("task_%s_%d", key, partId)
+}
+
+// SnapshotTag returns the runner task tag of the snapshot of the group (the key of the group and the consumer).
+func SnapshotTag(key string) string {
+	return fmt.Sprintf("snapshot_%s", key)
+}
+
+func (r *Router) newRoute(task *model.Task, snapshot *atomic.Bool) runner.Task {
+	tag := Tag(task.Key(), task.PartId)
+	rt := r.routeState(tag, task)
 
 	return runner.Task{
 		Tag:     tag,
-		Handler: r.newTaskHandler(task, tag),
+		Handler: r.newTaskHandler(task, rt, tag, snapshot),
 	}
 }
-func (r *router) newTaskHandler(task *model.Task, tag string) runner.TaskHandler {
+
+func (r *Router) newSnapshotRoute(task *model.Task, snapshot *atomic.Bool) runner.Task {
+	tag := SnapshotTag(task.Key())
+	rt := r.routeState(tag, task)
+
+	return runner.Task{
+		Tag:     tag,
+		Handler: r.newSnapshotHandler(task, rt, tag, snapshot),
+	}
+}
+
+// routeState returns the runtime state of the route with the tag, it is created on the first call.
+func (r *Router) routeState(tag string, task *model.Task) *route {
+	r.mu.Lock()
+	rt, ok := r.routes[tag]
+	if !ok {
+		rt = &route{}
+		r.routes[tag] = rt
+	}
+	r.mu.Unlock()
+
+	// The routes may be set up without running (e.g. rejected config reload),
+	// so the task of the running route is replaced by the handler only
+	rt.task.CompareAndSwap(nil, task)
+
+	return rt
+}
+
+// snapshotState returns the completion flag of the snapshot of the group (by the key), it is created on the first call.
+// The flag is raised by the snapshot handler, when the database reports the snapshot as complete.
+func (r *Router) snapshotState(key string) *atomic.Bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	done, ok := r.snapshots[key]
+	if !ok {
+		done = &atomic.Bool{}
+		r.snapshots[key] = done
+	}
+
+	return done
+}
+
+func (r *Router) newTaskHandler(task *model.Task, rt *route, tag string, snapshot *atomic.Bool) runner.TaskHandler {
 	return func(ctx context.Context) (bool, error) {
+		// The outbox events wait for the snapshot
+		if snapshot != nil && !snapshot.Load() {
+			return false, nil
+		}
+
 		slog.Debug(fmt.Sprintf("handler[%s] - handle next records", tag))
 
-		amount, err := r.srv.Relay(ctx, task)
+		rt.task.Store(task)
+
+		amount, err := r.srv.Relay(ctx, rt.effective(task))
 		if err != nil {
-			return false, fmt.Errorf("handler - processing error: %w", err)
+			return false, handlerError(err)
 		}
 
+		rt.relayed.Add(uint64(amount))
+
 		slog.Debug(fmt.Sprintf("handler[%s] - relay done", tag), "sent_amount", amount)
 
 		return amount > 0, nil
 	}
 }
+
+func (r *Router) newSnapshotHandler(task *model.Task, rt *route, tag string, snapshot *atomic.Bool) runner.TaskHandler {
+	return func(ctx context.Context) (bool, error) {
+		if snapshot.Load() {
+			return false, nil
+		}
+
+		slog.Debug(fmt.Sprintf("handler[%s] - handle next snapshot chunk", tag))
+
+		rt.task.Store(task)
+
+		amount, done, err := r.srv.Snapshot(ctx, rt.effective(task))
+		if err != nil {
+			return false, handlerError(err)
+		}
+
+		rt.relayed.Add(uint64(amount))
+
+		if done {
+			snapshot.Store(true)
+			slog.Info(fmt.Sprintf("handler[%s] - snapshot is complete, the outbox events are relayed", tag),
+				"relayed", rt.relayed.Load())
+		}
+
+		return amount > 0, nil
+	}
+}
+
+// effective returns the task with the batch size override applied.
+func (rt *route) effective(task *model.Task) *model.Task {
+	if v := rt.batchSize.Load(); v > 0 {
+		t := *task
+		t.BatchSize = int(v)
+		return &t
+	}
+	return task
+}
+
+// handlerError wraps the relay error, retrying does not fix the configuration errors, so the task is parked.
+// The poison records are sent to the dead letter topic or skipped by the service, otherwise they fail the batch
+// as fatal (see model.Task.OnPoison), the poison batches (e.g. undecodable as a whole) are retried as the retriable errors.
+func handlerError(err error) error {
+	if class := model.ClassOf(err); class == model.Fatal {
+		return fmt.Errorf("handler - processing error: %w (%s): %w", runner.ErrFatal, class, err)
+	}
+	return fmt.Errorf("handler - processing error: %w", err)
+}
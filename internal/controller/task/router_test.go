@@ -0,0 +1,179 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"github.com/eugene-vodyanko/orgonaut/internal/config"
+	"github.com/eugene-vodyanko/orgonaut/internal/model"
+	"github.com/eugene-vodyanko/orgonaut/pkg/runner"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// relayerMock serves the snapshot in chunks and counts the calls of the outbox relay.
+type relayerMock struct {
+	chunks  []uint16
+	relayed int
+	err     error
+}
+
+func (m *relayerMock) Relay(context.Context, *model.Task) (uint16, error) {
+	m.relayed++
+	return 1, m.err
+}
+
+func (m *relayerMock) Snapshot(context.Context, *model.Task) (uint16, bool, error) {
+	if m.err != nil {
+		return 0, false, m.err
+	}
+	amount := m.chunks[0]
+	m.chunks = m.chunks[1:]
+	return amount, len(m.chunks) == 0, nil
+}
+
+func snapshotTask() config.Task {
+	var v config.Task
+	v.GroupId = "group_1"
+	v.PartCount = 1
+	v.BatchSize = 100
+	v.Topic = "topic"
+	v.Query.Columns = "*"
+	v.Query.From = "test_tab"
+	v.Query.PkColumn = "id"
+	v.Snapshot.Enabled = true
+	v.Snapshot.BatchSize = 1000
+	return v
+}
+
+func TestRouter_Snapshot(t *testing.T) {
+	srv := &relayerMock{chunks: []uint16{1000, 10}}
+	r := NewRouter(srv)
+
+	v := snapshotTask()
+	routes, err := r.NewTaskRoutes("task_1", v)
+	require.NoError(t, err)
+	require.Len(t, routes, 2)
+	assert.Equal(t, []string{"task_group_1_0", "snapshot_group_1"}, Tags(v))
+
+	part, snapshot := routes[0], routes[1]
+	assert.Equal(t, "snapshot_group_1", snapshot.Tag)
+	assert.Equal(t, "group_1", snapshot.Group)
+
+	ctx := context.Background()
+
+	// The outbox events wait for the snapshot
+	ok, err := part.Handler(ctx)
+	assert.False(t, ok)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, srv.relayed)
+
+	ok, err = snapshot.Handler(ctx)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	ok, err = snapshot.Handler(ctx)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	info, found := r.Route("snapshot_group_1")
+	require.True(t, found)
+	assert.True(t, info.Snapshot)
+	assert.Equal(t, uint64(1010), info.Relayed)
+	assert.Equal(t, 1000, info.BatchSize)
+
+	// Handover: the snapshot is complete, the outbox events are relayed
+	ok, err = snapshot.Handler(ctx)
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	ok, err = part.Handler(ctx)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, srv.relayed)
+}
+
+func TestRouter_FatalError(t *testing.T) {
+	srv := &relayerMock{err: model.NewClassError(model.Fatal, errors.New("ORA-00942"))}
+	r := NewRouter(srv)
+
+	routes, err := r.NewTaskRoutes("task_1", snapshotTask())
+	require.NoError(t, err)
+
+	_, err = routes[1].Handler(context.Background())
+	assert.ErrorIs(t, err, runner.ErrFatal)
+
+	srv.err = errors.New("ORA-03113")
+	_, err = routes[1].Handler(context.Background())
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, runner.ErrFatal)
+
+	// The poison batch is retried, the task is not parked
+	srv.err = model.NewClassError(model.Poison, errors.New("XML parsing failed"))
+	_, err = routes[1].Handler(context.Background())
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, runner.ErrFatal)
+}
+
+func TestRouter_Consumers(t *testing.T) {
+	srv := &relayerMock{}
+	r := NewRouter(srv)
+
+	v := snapshotTask()
+	v.Snapshot.Enabled = false
+
+	search := v
+	search.Consumer = "search"
+
+	routes, err := r.NewRoutes(map[string]config.Task{"task_1": v, "task_2": search})
+	require.NoError(t, err)
+	require.Len(t, routes, 2)
+	assert.Equal(t, "task_group_1_0", routes[0].Tag)
+	assert.Equal(t, "group_1", routes[0].Group)
+	assert.Equal(t, "task_group_1@search_0", routes[1].Tag)
+	assert.Equal(t, "group_1@search", routes[1].Group)
+	assert.Equal(t, []string{"task_group_1@search_0"}, Tags(search))
+
+	info, found := r.Route("task_group_1@search_0")
+	require.True(t, found)
+	assert.Equal(t, "group_1", info.GroupId)
+	assert.Equal(t, "search", info.Consumer)
+
+	task, found := r.GroupTask("group_1@search")
+	require.True(t, found)
+	assert.Equal(t, "search", task.Consumer)
+
+	// The consumer of the group is unique
+	_, err = NewRoutes(map[string]config.Task{"task_2": search, "task_3": search}, srv)
+	assert.ErrorContains(t, err, "is already used")
+
+	invalid := v
+	invalid.Consumer = "search-index"
+	_, err = NewRoutes(map[string]config.Task{"task_4": invalid}, srv)
+	assert.Error(t, err)
+}
+
+func TestRouter_Payload(t *testing.T) {
+	srv := &relayerMock{}
+
+	var v config.Task
+	v.GroupId = "orders"
+	v.Mode = model.ModePayload
+	v.PartCount = 2
+	v.BatchSize = 100
+	v.Topic = "orders"
+
+	// The query is not required
+	routes, err := NewRoutes(map[string]config.Task{"task_1": v}, srv)
+	require.NoError(t, err)
+	assert.Len(t, routes, 2)
+
+	v.Snapshot.Enabled = true
+	_, err = NewRoutes(map[string]config.Task{"task_1": v}, srv)
+	assert.ErrorContains(t, err, "snapshot is not supported")
+
+	v.Snapshot.Enabled = false
+	v.Mode = "events"
+	_, err = NewRoutes(map[string]config.Task{"task_1": v}, srv)
+	assert.Error(t, err)
+}
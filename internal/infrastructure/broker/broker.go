@@ -2,14 +2,21 @@ package broker
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/eugene-vodyanko/orgonaut/internal/model"
 	"github.com/eugene-vodyanko/orgonaut/pkg/kafka/kafkakit"
 	"github.com/segmentio/kafka-go"
+	"io"
 	"log/slog"
+	"net"
+	"slices"
 	"time"
 )
 
+// ErrUnavailable marks the errors caused by the connectivity problems with the Kafka cluster.
+var ErrUnavailable = errors.New("broker unavailable")
+
 type Broker struct {
 	writer *kafkakit.Writer
 }
@@ -22,7 +29,8 @@ func NewBroker(writer *kafkakit.Writer) *Broker {
 
 // SendRecords sends messages to Kafka in the specified topic.
 // A text representation is used as the key of the Kafka message (e.g., "id=32").
-// A flat JSON representation is used as the value of the Kafka message (e.g., {"col_name":"col_value", ...})
+// A flat JSON representation is used as the value of the Kafka message (e.g., {"col_name":"col_value", ...}),
+// the payload of the payload events is used as-is, their meta and headers are sent as the message headers.
 func (b *Broker) SendRecords(ctx context.Context, topic string, records []*model.Record) error {
 	start := time.Now()
 
@@ -31,26 +39,38 @@ func (b *Broker) SendRecords(ctx context.Context, topic string, records []*model
 
 		key, err := record.GetKey()
 		if err != nil {
-			return fmt.Errorf("broker - get key failed: %w", err)
+			return fmt.Errorf("broker - get key failed: %w", model.NewClassError(model.Poison, err))
 		}
 
 		value, err := record.GetValue()
 		if err != nil {
-			return fmt.Errorf("broker - get value failed: %w", err)
+			return fmt.Errorf("broker - get value failed: %w", model.NewClassError(model.Poison, err))
+		}
+
+		h := headers(record)
+		if record.Error != "" {
+			// The reason of the dead letter
+			h = append(h, kafka.Header{Key: "__error", Value: []byte(record.Error)})
 		}
 
 		kafkaMessages = append(kafkaMessages,
 			kafka.Message{
-				Key:   key,
-				Value: value,
-				Topic: topic,
+				Key:     key,
+				Value:   value,
+				Topic:   topic,
+				Headers: h,
+				// The time of the event, otherwise the time of the sending
+				Time: record.EventTime(),
 			},
 		)
 	}
 
 	err := b.writer.WriteMessages(ctx, kafkaMessages...)
 	if err != nil {
-		return fmt.Errorf("broker - write messages failed: %w", err)
+		if isUnavailable(err) {
+			return fmt.Errorf("broker - write messages failed: %w: %w", ErrUnavailable, err)
+		}
+		return fmt.Errorf("broker - write messages failed: %w", model.NewClassError(classOf(err), err))
 	}
 
 	elapsed := time.Now()
@@ -62,3 +82,163 @@ func (b *Broker) SendRecords(ctx context.Context, topic string, records []*model
 
 	return nil
 }
+
+// SendMarkers sends the transaction markers to the topic, the key of the message is the id of the transaction.
+func (b *Broker) SendMarkers(ctx context.Context, topic string, markers []model.TxMarker) error {
+	messages := make([]kafka.Message, 0, len(markers))
+	for _, v := range markers {
+		value, err := v.GetValue()
+		if err != nil {
+			return fmt.Errorf("broker - get marker value failed: %w", model.NewClassError(model.Poison, err))
+		}
+
+		messages = append(messages, kafka.Message{
+			Key:   v.GetKey(),
+			Value: value,
+			Topic: topic,
+		})
+	}
+
+	err := b.writer.WriteMessages(ctx, messages...)
+	if err != nil {
+		if isUnavailable(err) {
+			return fmt.Errorf("broker - write markers failed: %w: %w", ErrUnavailable, err)
+		}
+		return fmt.Errorf("broker - write markers failed: %w", model.NewClassError(classOf(err), err))
+	}
+
+	return nil
+}
+
+// headers returns the headers of the message: the application-provided ones of the payload event
+// (in order of the names) and its meta, the row events carry the meta in the value, the sequence number
+// and the time of the event are also sent as the headers.
+func headers(record *model.Record) []kafka.Header {
+	if record.Payload == nil {
+		if record.Seq == "" {
+			return nil
+		}
+		result := []kafka.Header{
+			{Key: "__seq", Value: []byte(record.Seq)},
+			{Key: "__event_ts", Value: []byte(record.EventTs)},
+		}
+		if record.TxId != "" {
+			result = append(result, kafka.Header{Key: "__tx_id", Value: []byte(record.TxId)})
+		}
+		return result
+	}
+
+	names := make([]string, 0, len(record.Headers))
+	for k := range record.Headers {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+
+	result := make([]kafka.Header, 0, len(names)+4)
+	for _, k := range names {
+		result = append(result, kafka.Header{Key: k, Value: []byte(record.Headers[k])})
+	}
+
+	result = append(result,
+		kafka.Header{Key: "__op", Value: []byte(record.Op)},
+		kafka.Header{Key: "__ts", Value: []byte(record.Ts)},
+		kafka.Header{Key: "__ux_ts", Value: []byte(record.UxTs)},
+	)
+	if record.Seq != "" {
+		result = append(result, kafka.Header{Key: "__seq", Value: []byte(record.Seq)})
+	}
+	if record.TxId != "" {
+		result = append(result, kafka.Header{Key: "__tx_id", Value: []byte(record.TxId)})
+	}
+
+	return result
+}
+
+// Probe checks the connectivity with the Kafka cluster.
+func (b *Broker) Probe(ctx context.Context) error {
+	err := b.writer.Ping(ctx)
+	if err != nil {
+		return fmt.Errorf("broker - ping failed: %w", err)
+	}
+
+	return nil
+}
+
+// isUnavailable reports whether the write error is caused by the connectivity problems.
+// The cancelled or timed out writes (e.g. by the handler timeout) are not: the context errors satisfy net.Error,
+// but they are caused by the caller, and must not pause the other tasks.
+func isUnavailable(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var writeErrs kafka.WriteErrors
+	if errors.As(err, &writeErrs) {
+		for _, e := range writeErrs {
+			if e != nil && isUnavailable(e) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var kafkaErr kafka.Error
+	if errors.As(err, &kafkaErr) {
+		switch kafkaErr {
+		case kafka.LeaderNotAvailable,
+			kafka.NotLeaderForPartition,
+			kafka.RequestTimedOut,
+			kafka.BrokerNotAvailable,
+			kafka.NetworkException,
+			kafka.NotEnoughReplicas,
+			kafka.NotEnoughReplicasAfterAppend:
+			return true
+		}
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// classOf classifies the write error, the most severe class of the partial errors wins.
+func classOf(err error) model.ErrorClass {
+	var writeErrs kafka.WriteErrors
+	if errors.As(err, &writeErrs) {
+		class := model.Retriable
+		for _, e := range writeErrs {
+			if e != nil {
+				class = max(class, classOf(e))
+			}
+		}
+		return class
+	}
+
+	var tooLarge kafka.MessageTooLargeError
+	if errors.As(err, &tooLarge) {
+		return model.Poison
+	}
+
+	var kafkaErr kafka.Error
+	if errors.As(err, &kafkaErr) {
+		switch kafkaErr {
+		case kafka.MessageSizeTooLarge,
+			kafka.RecordListTooLarge,
+			kafka.InvalidRecord:
+			return model.Poison
+		case kafka.InvalidTopic,
+			kafka.InvalidRequiredAcks,
+			kafka.TopicAuthorizationFailed,
+			kafka.ClusterAuthorizationFailed,
+			kafka.SASLAuthenticationFailed,
+			kafka.UnsupportedCompressionType:
+			return model.Fatal
+		}
+	}
+
+	return model.Retriable
+}
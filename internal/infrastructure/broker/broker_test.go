@@ -2,9 +2,14 @@ package broker
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/eugene-vodyanko/orgonaut/internal/model"
 	"github.com/eugene-vodyanko/orgonaut/pkg/kafka/kafkakit"
+	"github.com/segmentio/kafka-go"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
 
 	"testing"
 	"time"
@@ -37,3 +42,157 @@ func TestBroker_SendRecords(t *testing.T) {
 
 	t.Logf("elapsed: %v", elapsed.Sub(start))
 }
+
+func TestBroker_markers(t *testing.T) {
+	tx := func(key, seq, id, scn string) *model.Record {
+		r := &model.Record{Meta: model.Meta{Pk: model.Pk{Name: "id", Value: key}, Op: model.UPDATE}}
+		r.SetEvent(model.Meta{EventTs: "ts" + seq, Seq: seq, TxId: id, CommitScn: scn})
+		return r
+	}
+
+	records := []*model.Record{tx("1", "1", "7.1.1", "100"), tx("2", "2", "7.2.1", "101"),
+		tx("3", "3", "7.1.1", "100"), tx("4", "4", "", "")}
+	assert.Equal(t, []model.TxRef{{Id: "7.1.1", CommitScn: "100"}, {Id: "7.2.1", CommitScn: "101"}},
+		model.TxRefs(records))
+
+	counts := map[model.TxRef][]model.TxGroupCount{
+		{Id: "7.1.1", CommitScn: "100"}: {{GroupId: "orders", EventCount: 2}, {GroupId: "items", EventCount: 5}},
+	}
+	markers := model.TxMarkers(records, counts)
+	require.Len(t, markers, 4)
+
+	value, err := markers[0].GetValue()
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"status":"BEGIN","id":"7.1.1","commit_scn":"100","event_ts":"ts1"}`, string(value))
+
+	value, err = markers[1].GetValue()
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"status":"END","id":"7.1.1","commit_scn":"100","event_ts":"ts3","event_count":7,
+		"groups":[{"group_id":"orders","event_count":2},{"group_id":"items","event_count":5}]}`, string(value))
+	assert.Equal(t, []byte("7.1.1"), markers[1].GetKey())
+
+	// The counts are unknown (e.g. the events are removed)
+	assert.Equal(t, model.TxEnd, markers[3].Status)
+	assert.Zero(t, markers[3].EventCount)
+}
+
+func TestBroker_markersReusedTxId(t *testing.T) {
+	tx := func(key, seq, scn string) *model.Record {
+		r := &model.Record{Meta: model.Meta{Pk: model.Pk{Name: "id", Value: key}, Op: model.UPDATE}}
+		r.SetEvent(model.Meta{EventTs: "ts" + seq, Seq: seq, TxId: "7.1.1", CommitScn: scn})
+		return r
+	}
+
+	// The id is reused by the later transaction
+	records := []*model.Record{tx("1", "1", "100"), tx("2", "2", "100"), tx("3", "3", "200")}
+	assert.Len(t, model.TxRefs(records), 2)
+
+	counts := map[model.TxRef][]model.TxGroupCount{
+		{Id: "7.1.1", CommitScn: "100"}: {{GroupId: "orders", EventCount: 2}},
+		{Id: "7.1.1", CommitScn: "200"}: {{GroupId: "orders", EventCount: 1}},
+	}
+	markers := model.TxMarkers(records, counts)
+	require.Len(t, markers, 4)
+
+	assert.Equal(t, model.TxMarker{Status: model.TxBegin, Id: "7.1.1", CommitScn: "100", EventTs: "ts1"}, markers[0])
+	assert.Equal(t, model.TxMarker{Status: model.TxEnd, Id: "7.1.1", CommitScn: "100", EventTs: "ts2",
+		EventCount: 2, Groups: counts[model.TxRef{Id: "7.1.1", CommitScn: "100"}]}, markers[1])
+	assert.Equal(t, model.TxMarker{Status: model.TxBegin, Id: "7.1.1", CommitScn: "200", EventTs: "ts3"}, markers[2])
+	assert.Equal(t, model.TxMarker{Status: model.TxEnd, Id: "7.1.1", CommitScn: "200", EventTs: "ts3",
+		EventCount: 1, Groups: counts[model.TxRef{Id: "7.1.1", CommitScn: "200"}]}, markers[3])
+}
+
+func TestBroker_isUnavailable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"dial", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{"leader", fmt.Errorf("write: %w", kafka.LeaderNotAvailable), true},
+		{"write errors", kafka.WriteErrors{nil, kafka.NotLeaderForPartition}, true},
+		{"message too large", kafka.MessageSizeTooLarge, false},
+		{"deadline", fmt.Errorf("write: %w", context.DeadlineExceeded), false},
+		{"canceled", kafka.WriteErrors{context.Canceled}, false},
+		{"other", errors.New("invalid record"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isUnavailable(tt.err))
+		})
+	}
+}
+
+func TestBroker_classOf(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want model.ErrorClass
+	}{
+		{"leader", fmt.Errorf("write: %w", kafka.LeaderNotAvailable), model.Retriable},
+		{"message too large", kafka.MessageSizeTooLarge, model.Poison},
+		{"client message too large", kafka.MessageTooLargeError{}, model.Poison},
+		{"topic authorization", kafka.TopicAuthorizationFailed, model.Fatal},
+		{"write errors", kafka.WriteErrors{nil, kafka.NotLeaderForPartition, kafka.InvalidRecord}, model.Poison},
+		{"other", errors.New("unexpected EOF"), model.Retriable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, classOf(tt.err))
+		})
+	}
+}
+
+func TestBroker_headers(t *testing.T) {
+	record := &model.Record{
+		Meta: model.Meta{
+			Pk:   model.Pk{Name: "key", Value: "42"},
+			Op:   model.CREATE,
+			Ts:   "2024-06-10T07:45:56.948651 +00:00",
+			UxTs: "1718005556948",
+		},
+	}
+
+	// The meta of the row events is in the value
+	assert.Nil(t, headers(record))
+	assert.True(t, record.EventTime().IsZero())
+
+	// The sequence and the time of the event are sent as the headers too
+	record.SetEvent(model.Meta{EventTs: "2024-06-10T07:45:56.948000 +00:00", UxEventTs: "1718005556948", Seq: "1001"})
+	assert.Equal(t, []kafka.Header{
+		{Key: "__seq", Value: []byte("1001")},
+		{Key: "__event_ts", Value: []byte("2024-06-10T07:45:56.948000 +00:00")},
+	}, headers(record))
+	assert.Equal(t, int64(1718005556948), record.EventTime().UnixMilli())
+	assert.Equal(t, "1001", record.Fields["__seq"])
+
+	record.Payload = []byte(`{"order_id":42}`)
+	record.Headers = map[string]string{"type": "OrderPlaced", "source": "shop"}
+
+	assert.Equal(t, []kafka.Header{
+		{Key: "source", Value: []byte("shop")},
+		{Key: "type", Value: []byte("OrderPlaced")},
+		{Key: "__op", Value: []byte("c")},
+		{Key: "__ts", Value: []byte("2024-06-10T07:45:56.948651 +00:00")},
+		{Key: "__ux_ts", Value: []byte("1718005556948")},
+		{Key: "__seq", Value: []byte("1001")},
+	}, headers(record))
+
+	// The transaction of the event
+	record.SetEvent(model.Meta{EventTs: "2024-06-10T07:45:56.948000 +00:00", UxEventTs: "1718005556948", Seq: "1001",
+		TxId: "7.12.3301", CommitScn: "88120331"})
+	assert.Equal(t, kafka.Header{Key: "__tx_id", Value: []byte("7.12.3301")}, headers(record)[6])
+	assert.Equal(t, "88120331", record.Fields["__commit_scn"])
+
+	value, err := record.GetValue()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"order_id":42}`, string(value))
+
+	// The empty payload is sent without a value
+	record.Payload = []byte{}
+	value, err = record.GetValue()
+	assert.NoError(t, err)
+	assert.Nil(t, value)
+}
@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	ora "github.com/sijms/go-ora/v2"
+)
+
+const alertStatusSignaled = 0
+
+// The name of the setting of the signaling (see org$outbox_api.SETTING_SIGNAL_ENABLED)
+const settingSignalEnabled = "signal_enabled"
+
+// IsSignalEnabled reports whether the alerts are signaled on the publishing of the events
+// (see org$outbox_api.setSignalEnabled), the tasks are not woken up otherwise.
+func (r *Repository) IsSignalEnabled(ctx context.Context) (bool, error) {
+	var value sql.NullString
+	err := r.Db.QueryRowContext(ctx,
+		"select value from "+r.schema+".OUTBOX_SETTING where name = :1", settingSignalEnabled).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("db - get signal setting error: %w", err)
+	}
+
+	return value.String == "Y", nil
+}
+
+// ListenAlerts waits for the alerts about new events in the outbox (see org$gate_api.registerAlert)
+// and calls notify for the part of the group where the events have been published. The names of the alerts
+// are truncated (see org$outbox_api.alertName), so an alert shared by several parts notifies all of them.
+//
+// The parts are passed as a map of the group code to the number of its parts.
+// A dedicated database session is used for listening, it is released when the context is cancelled.
+// The alert wait is limited by the timeout, so the cancellation is checked at least once per timeout.
+func (r *Repository) ListenAlerts(ctx context.Context, parts map[string]int, timeout time.Duration,
+	notify func(groupId string, partId int)) error {
+
+	conn, err := r.Db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("db - get connection error: %w", err)
+	}
+
+	defer func() {
+		_, err := conn.ExecContext(context.Background(),
+			"begin "+r.schema+".org$gate_api.removeAlerts(); end;")
+		if err != nil {
+			slog.Error("db - remove alerts error", "err", err)
+		}
+		_ = conn.Close()
+	}()
+
+	type part struct {
+		groupId string
+		partId  int
+	}
+
+	alerts := make(map[string][]part)
+	for groupId, partCount := range parts {
+		for i := 0; i < partCount; i++ {
+			var name string
+			_, err = conn.ExecContext(ctx,
+				"begin "+r.schema+".org$gate_api.registerAlert(p_group_id => :1, p_part_id => :2, r_name => :3); end;",
+				groupId, i, ora.Out{Dest: &name, Size: 30},
+			)
+			if err != nil {
+				return fmt.Errorf("db - register alert error: %w", err)
+			}
+
+			alerts[name] = append(alerts[name], part{groupId, i})
+		}
+	}
+
+	slog.Info("db - listen alerts", "alerts_count", len(alerts))
+
+	seconds := max(int(timeout/time.Second), 1)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		var name string
+		var status int
+		_, err = conn.ExecContext(ctx,
+			"begin "+r.schema+".org$gate_api.waitAlert(p_timeout => :1, r_name => :2, r_status => :3); end;",
+			seconds, ora.Out{Dest: &name, Size: 30}, &status,
+		)
+		if err != nil {
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return nil
+			}
+			return fmt.Errorf("db - wait alert error: %w", err)
+		}
+
+		if status != alertStatusSignaled {
+			continue
+		}
+
+		for _, p := range alerts[name] {
+			notify(p.groupId, p.partId)
+		}
+	}
+}
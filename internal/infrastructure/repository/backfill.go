@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/eugene-vodyanko/orgonaut/internal/model"
+	ora "github.com/sijms/go-ora/v2"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GetBackfillRecords receives the next chunk of the rows matching the filter after the key lastPk
+// (empty - from the start) in ascending order of the primary key, the records have the READ action.
+// The outbox is not used. It returns the last key of the chunk to pass to the next call,
+// and the done flag if there are no more rows.
+func (r *Repository) GetBackfillRecords(ctx context.Context, task *model.Task, filter model.Filter,
+	lastPk string) ([]*model.Record, string, bool, error) {
+
+	start := time.Now()
+
+	query := "begin " +
+		r.schema +
+		".org$gate_api.getBackfillRows(" +
+		"  p_rows => :1" +
+		", p_predicate => :2" +
+		", p_values => :3" +
+		", p_last_pk => :4" +
+		", p_qry_columns => :5" +
+		", p_qry_from => :6" +
+		", p_qry_pk_column => :7" +
+		", r_rows_dump => :8" +
+		", r_rows_count => :9" +
+		", r_last_pk => :10" +
+		", r_done => :11" +
+		"); " +
+		"end;"
+
+	tx, err := getTx(ctx, r.Db)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	// The keys are numeric (see Filter.Validate), they are passed as strings to keep the precision
+	var last any
+	if lastPk != "" {
+		last = lastPk
+	}
+
+	where, values := predicate(task.Query.PkColumn, filter)
+
+	var rowsDump ora.Blob
+	var rowsCount int
+	var nextPk string
+	var done int
+
+	_, err = tx.ExecContext(ctx, query,
+		task.BatchSize,
+		where,
+		values,
+		last,
+		task.Query.Columns,
+		task.Query.From,
+		task.Query.PkColumn,
+
+		// output
+		ora.Out{Dest: &rowsDump, Size: 1000},
+		&rowsCount,
+		ora.Out{Dest: &nextPk, Size: 64},
+		&done,
+	)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("db - get backfill rows error: %w", classify(err))
+	}
+
+	records, err := makeRecords(rowsDump.Data)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("db - convert backfill rows error: %w", model.NewClassError(model.Poison, err))
+	}
+
+	slog.Debug("db - get backfill records",
+		"elapsed", time.Since(start),
+		"group_id", task.GroupId,
+		"amount", len(records),
+		"last_pk", nextPk,
+	)
+
+	return records, nextPk, done == 1, nil
+}
+
+// predicate makes the SQL condition of the filter validated by Filter.Validate: the values are referenced
+// as the bind variables :v1..:vN (see org$gate_api.getBackfillRows), the columns and the operators are allow-listed.
+func predicate(pkColumn string, f model.Filter) (string, []string) {
+	var conds, values []string
+	bind := func(v string) string {
+		values = append(values, v)
+		return ":v" + strconv.Itoa(len(values))
+	}
+
+	if f.FromPk != "" {
+		conds = append(conds, pkColumn+" >= "+bind(f.FromPk))
+	}
+
+	if f.ToPk != "" {
+		conds = append(conds, pkColumn+" <= "+bind(f.ToPk))
+	}
+
+	if len(f.Keys) > 0 {
+		binds := make([]string, len(f.Keys))
+		for i, v := range f.Keys {
+			binds[i] = bind(v)
+		}
+		conds = append(conds, pkColumn+" in ("+strings.Join(binds, ", ")+")")
+	}
+
+	for _, v := range f.Conditions {
+		conds = append(conds, v.Column+" "+v.Op+" "+bind(v.Value))
+	}
+
+	return strings.Join(conds, " and "), values
+}
@@ -0,0 +1,20 @@
+package repository
+
+import (
+	"github.com/eugene-vodyanko/orgonaut/internal/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRepository_predicate(t *testing.T) {
+	p, values := predicate("id", model.Filter{FromPk: "10", ToPk: "20"})
+	assert.Equal(t, "id >= :v1 and id <= :v2", p)
+	assert.Equal(t, []string{"10", "20"}, values)
+
+	p, values = predicate("id", model.Filter{
+		Keys:       []string{"1", "2", "3"},
+		Conditions: []model.Condition{{Column: "region", Op: "=", Value: "EU' or 1=1 --"}},
+	})
+	assert.Equal(t, "id in (:v1, :v2, :v3) and region = :v4", p)
+	assert.Equal(t, []string{"1", "2", "3", "EU' or 1=1 --"}, values)
+}
@@ -3,6 +3,7 @@ package repository
 import (
 	"bytes"
 	"compress/gzip"
+	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"github.com/eugene-vodyanko/orgonaut/internal/model"
@@ -34,6 +35,11 @@ func makeRecords(input []byte) ([]*model.Record, error) {
 type row struct {
 	model.Meta
 	Fields []byte `xml:",innerxml"`
+	// The payload events only (see org$gate_api.getNextPayloadEvents)
+	Payload *string `xml:"__payload"`
+	Headers string  `xml:"__headers"`
+	// The before images only (see org$gate_api.getNextEvents)
+	Before string `xml:"__before"`
 }
 
 func decodeRecords(r io.Reader) ([]*model.Record, error) {
@@ -61,10 +67,30 @@ func decodeRecords(r io.Reader) ([]*model.Record, error) {
 					return nil, fmt.Errorf("field token error: %w", err)
 				}
 
-				rows = append(rows, &model.Record{
+				record := &model.Record{
 					Meta:   row.Meta,
 					Fields: m,
-				})
+				}
+
+				if row.Payload != nil {
+					// The empty payload is kept non-nil (the message without a value)
+					record.Payload = append([]byte{}, *row.Payload...)
+					if row.Headers != "" {
+						// The poison record is not sent (see Record.Error), the other records of the batch are
+						if err = json.Unmarshal([]byte(row.Headers), &record.Headers); err != nil {
+							record.Error = fmt.Sprintf("headers of key %s error: %s", row.Meta.Pk.Value, err)
+						}
+					}
+				}
+
+				if row.Before != "" {
+					record.Before, err = parseImage(row.Before)
+					if err != nil {
+						record.Error = fmt.Sprintf("before image of key %s error: %s", row.Meta.Pk.Value, err)
+					}
+				}
+
+				rows = append(rows, record)
 
 			}
 		}
@@ -93,6 +119,37 @@ func parseFields(s io.Reader) (map[string]string, error) {
 	return r, nil
 }
 
+// parseImage parses the before image: the flat JSON object of the column values, the names are lowercased
+// as the fields are, the null values are omitted as the null fields are (the numbers are kept as they are).
+func parseImage(s string) (map[string]string, error) {
+	d := json.NewDecoder(strings.NewReader(s))
+	d.UseNumber()
+
+	var image map[string]any
+	if err := d.Decode(&image); err != nil {
+		return nil, err
+	}
+
+	r := make(map[string]string, len(image))
+	for k, v := range image {
+		switch v := v.(type) {
+		case nil:
+		case string:
+			r[strings.ToLower(k)] = v
+		case json.Number:
+			r[strings.ToLower(k)] = v.String()
+		default:
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			r[strings.ToLower(k)] = string(b)
+		}
+	}
+
+	return r, nil
+}
+
 func getGZipReader(input []byte) (io.Reader, error) {
 	if input != nil {
 		bytesReader := bytes.NewReader(input)
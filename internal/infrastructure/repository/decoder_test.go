@@ -2,7 +2,9 @@ package repository
 
 import (
 	"fmt"
+	"github.com/eugene-vodyanko/orgonaut/internal/model"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"strings"
 	"testing"
 	"time"
@@ -52,3 +54,100 @@ func TestDecoder_decodeRecords(t *testing.T) {
 
 	fmt.Println("elapsed:", elapsed.Sub(start))
 }
+
+func TestDecoder_decodePayloadRecords(t *testing.T) {
+	// language=xml
+	payloads := `<?xml version="1.0"?><ROWSET>
+<ROW>
+<__pk_name>key</__pk_name>
+<__pk_val>42</__pk_val>
+<__op>c</__op>
+<__ux_ts>1718005556948</__ux_ts>
+<__ts>2024-06-10T07:45:56.948000 +00:00</__ts>
+<__payload>{&quot;note&quot;:&quot;a &lt; b &amp; c&quot;}</__payload>
+<__headers>{&quot;type&quot;:&quot;OrderPlaced&quot;}</__headers>
+</ROW>
+<ROW>
+<__pk_name>key</__pk_name>
+<__pk_val>43</__pk_val>
+<__op>d</__op>
+<__ux_ts>1718005556948</__ux_ts>
+<__ts>2024-06-10T07:45:56.948000 +00:00</__ts>
+<__payload></__payload>
+<__headers></__headers>
+</ROW>
+</ROWSET>
+`
+
+	records, err := decodeRecords(strings.NewReader(payloads))
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+
+	assert.Equal(t, "42", records[0].Pk.Value)
+	assert.Equal(t, `{"note":"a < b & c"}`, string(records[0].Payload))
+	assert.Equal(t, map[string]string{"type": "OrderPlaced"}, records[0].Headers)
+
+	// The empty payload is kept (the message without a value)
+	assert.NotNil(t, records[1].Payload)
+	assert.Empty(t, records[1].Payload)
+	assert.Nil(t, records[1].Headers)
+
+	// The rows have no payload
+	records, err = decodeRecords(strings.NewReader(rows))
+	assert.NoError(t, err)
+	assert.Nil(t, records[0].Payload)
+}
+
+func TestDecoder_decodeBeforeImages(t *testing.T) {
+	// language=xml
+	images := `<?xml version="1.0"?><ROWSET>
+<ROW>
+<__pk_val>2</__pk_val>
+<__before>{&quot;ID&quot;:2,&quot;STR&quot;:&quot;str:1&quot;,&quot;DT&quot;:&quot;2024-04-14 22:44:37&quot;,&quot;NOTE&quot;:null}</__before>
+</ROW>
+</ROWSET>
+`
+
+	records, err := decodeRecords(strings.NewReader(images))
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+
+	// The names are lowercased, the null values are omitted
+	assert.Equal(t, map[string]string{"id": "2", "str": "str:1", "dt": "2024-04-14 22:44:37"}, records[0].Before)
+
+	r := &model.Record{
+		Meta:   model.Meta{Pk: model.Pk{Name: "id", Value: "2"}, Op: model.UPDATE},
+		Fields: map[string]string{"__ts": "1718009156929", "id": "2", "dt": "2024-04-14 22:44:37", "str": "str:2"},
+		Before: records[0].Before,
+		Image:  model.ImageDiff,
+	}
+
+	// The changed columns only
+	value, err := r.GetValue()
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"__ts":"1718009156929","before":{"str":"str:1"},"after":{"str":"str:2"}}`, string(value))
+
+	r.Image = model.ImageFull
+	value, err = r.GetValue()
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"__ts":"1718009156929","before":{"id":"2","dt":"2024-04-14 22:44:37","str":"str:1"},`+
+		`"after":{"id":"2","dt":"2024-04-14 22:44:37","str":"str:2"}}`, string(value))
+
+	// The deleted row has no after image
+	r.Op, r.Fields = model.DELETE, map[string]string{"__ts": "1718009156929", "id": "2"}
+	value, err = r.GetValue()
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"__ts":"1718009156929","before":{"id":"2","dt":"2024-04-14 22:44:37","str":"str:1"},`+
+		`"after":null}`, string(value))
+
+	// The record with the broken image is the dead letter, the batch is decoded
+	broken := strings.Replace(images, "&quot;NOTE&quot;:null}", "&quot;NOTE&quot;:", 1)
+	records, err = decodeRecords(strings.NewReader(broken))
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Contains(t, records[0].Error, "before image of key 2")
+
+	d := records[0].AsDeadLetter(records[0].Error)
+	assert.Nil(t, d.Before)
+	assert.Equal(t, "2", d.Fields["__pk_val"])
+}
@@ -0,0 +1,156 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/eugene-vodyanko/orgonaut/internal/model"
+)
+
+// Oracle type codes of the numeric columns (see dbms_sql.describe_columns2)
+const (
+	typeNumber       = 2
+	typeBinaryFloat  = 100
+	typeBinaryDouble = 101
+)
+
+// QueryInfo is the description of the task query.
+type QueryInfo struct {
+	// PkType is the Oracle type code of the pk column
+	PkType int
+	// ColumnCount is the number of the selected columns (including the meta columns)
+	ColumnCount int
+}
+
+// PkNumeric reports whether the pk column is numeric (the outbox keys are numbers).
+func (i QueryInfo) PkNumeric() bool {
+	return i.PkType == typeNumber || i.PkType == typeBinaryFloat || i.PkType == typeBinaryDouble
+}
+
+// DescribeQuery parses the query built for the task (see org$gate_api.describeQuery) without executing it.
+// An invalid query (e.g. unknown table or pk column) results in the parse error.
+func (r *Repository) DescribeQuery(ctx context.Context, q model.Query) (QueryInfo, error) {
+	query := "begin " +
+		r.schema +
+		".org$gate_api.describeQuery(" +
+		"  p_qry_columns => :1" +
+		", p_qry_from => :2" +
+		", p_qry_pk_column => :3" +
+		", r_pk_type => :4" +
+		", r_col_count => :5" +
+		"); " +
+		"end;"
+
+	var pkType, colCount int
+
+	_, err := r.Db.ExecContext(ctx, query,
+		q.Columns,
+		q.From,
+		q.PkColumn,
+		// output
+		&pkType,
+		&colCount,
+	)
+	if err != nil {
+		return QueryInfo{}, fmt.Errorf("db - describe query error: %w", err)
+	}
+
+	return QueryInfo{
+		PkType:      pkType,
+		ColumnCount: colCount,
+	}, nil
+}
+
+// GetPartRange returns the range of the part ids used by the new events of the group.
+// If there are no new events, ok is false.
+func (r *Repository) GetPartRange(ctx context.Context, groupId string) (minPart, maxPart int, ok bool, err error) {
+	query := "select min(part_id), max(part_id) from " + r.schema + ".EVENT_LOG" +
+		" where group_id = :1 and state = 'n'"
+
+	var lo, hi sql.NullInt64
+	if err = r.Db.QueryRowContext(ctx, query, groupId).Scan(&lo, &hi); err != nil {
+		return 0, 0, false, fmt.Errorf("db - get part range error: %w", err)
+	}
+
+	return int(lo.Int64), int(hi.Int64), lo.Valid, nil
+}
+
+// Column is the column of the table (see all_tab_columns).
+type Column struct {
+	Name     string
+	DataType string
+}
+
+// TableInfo is the description of the table from the data dictionary.
+type TableInfo struct {
+	Owner string
+	Name  string
+	// Columns are in order of the column id
+	Columns []Column
+	// PkColumns are the columns of the primary key in order of the position, empty - there is no primary key
+	PkColumns []Column
+}
+
+// DescribeTable describes the table of the owner (empty - the current schema) by the data dictionary views.
+// The names are matched as they are, so the unquoted names are expected in upper case.
+func (r *Repository) DescribeTable(ctx context.Context, owner, name string) (TableInfo, error) {
+	info := TableInfo{Owner: owner, Name: name}
+
+	if info.Owner == "" {
+		err := r.Db.QueryRowContext(ctx, "select sys_context('userenv', 'current_schema') from dual").Scan(&info.Owner)
+		if err != nil {
+			return info, fmt.Errorf("db - get current schema error: %w", err)
+		}
+	}
+
+	columns := "select column_name, data_type from all_tab_columns" +
+		" where owner = :1 and table_name = :2" +
+		" order by column_id"
+
+	var err error
+	info.Columns, err = r.queryColumns(ctx, columns, info.Owner, info.Name)
+	if err != nil {
+		return info, fmt.Errorf("db - describe table columns error: %w", err)
+	}
+
+	pk := "select cc.column_name, tc.data_type" +
+		" from all_constraints c" +
+		" join all_cons_columns cc on cc.owner = c.owner and cc.constraint_name = c.constraint_name" +
+		" join all_tab_columns tc on tc.owner = c.owner and tc.table_name = c.table_name and tc.column_name = cc.column_name" +
+		" where c.owner = :1 and c.table_name = :2 and c.constraint_type = 'P'" +
+		" order by cc.position"
+
+	info.PkColumns, err = r.queryColumns(ctx, pk, info.Owner, info.Name)
+	if err != nil {
+		return info, fmt.Errorf("db - describe table pk error: %w", err)
+	}
+
+	return info, nil
+}
+
+// ExecDDL executes the DDL statement (e.g. the generated trigger), it is committed implicitly.
+func (r *Repository) ExecDDL(ctx context.Context, ddl string) error {
+	if _, err := r.Db.ExecContext(ctx, ddl); err != nil {
+		return fmt.Errorf("db - exec ddl error: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) queryColumns(ctx context.Context, query string, args ...any) ([]Column, error) {
+	rows, err := r.Db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var result []Column
+	for rows.Next() {
+		var c Column
+		if err = rows.Scan(&c.Name, &c.DataType); err != nil {
+			return nil, err
+		}
+		result = append(result, c)
+	}
+
+	return result, rows.Err()
+}
@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"errors"
+	"github.com/eugene-vodyanko/orgonaut/internal/model"
+	"github.com/sijms/go-ora/v2/network"
+)
+
+// classify sets the class of the database error by the Oracle error code.
+// The errors not listed (including the connectivity ones, e.g. ORA-03113) remain retriable.
+func classify(err error) error {
+	var oraErr *network.OracleError
+	if !errors.As(err, &oraErr) {
+		return err
+	}
+
+	switch oraErr.ErrCode {
+	case 904, // invalid identifier
+		907,  // missing right parenthesis
+		918,  // column ambiguously defined
+		923,  // FROM keyword not found where expected
+		932,  // inconsistent datatypes
+		933,  // SQL command not properly ended
+		936,  // missing expression
+		942,  // table or view does not exist
+		1017, // invalid username/password
+		1031, // insufficient privileges
+		4063, // package body has errors
+		6550: // PL/SQL compilation error (e.g. unknown package)
+		return model.NewClassError(model.Fatal, err)
+
+	case 1489, // result of string concatenation is too long
+		6502,  // PL/SQL: numeric or value error
+		19011, // character string buffer too small
+		22835, // buffer too small for CLOB to CHAR conversion
+		31011: // XML parsing failed
+		return model.NewClassError(model.Poison, err)
+	}
+
+	return err
+}
@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"github.com/eugene-vodyanko/orgonaut/internal/model"
+	"github.com/sijms/go-ora/v2/network"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRepository_classify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want model.ErrorClass
+	}{
+		{"end-of-file on communication channel", &network.OracleError{ErrCode: 3113}, model.Retriable},
+		{"table or view does not exist", &network.OracleError{ErrCode: 942}, model.Fatal},
+		{"invalid identifier", fmt.Errorf("exec: %w", &network.OracleError{ErrCode: 904}), model.Fatal},
+		{"buffer too small", &network.OracleError{ErrCode: 19011}, model.Poison},
+		{"not oracle", errors.New("driver: bad connection"), model.Retriable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classify(tt.err)
+			assert.Equal(t, tt.want, model.ClassOf(err))
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/eugene-vodyanko/orgonaut/internal/model"
+	"time"
+)
+
+// GetOutboxState returns the state of the outbox events per group and part (see EVENT_LOG table).
+// If groupId is empty, all the groups are returned.
+func (r *Repository) GetOutboxState(ctx context.Context, groupId string) ([]model.PartState, error) {
+	query := "select group_id, part_id," +
+		" count(case when state = 'n' then 1 end) new_cnt," +
+		" count(case when state = 'p' then 1 end) processed_cnt," +
+		" min(case when state = 'n' then ts end) oldest_new_ts," +
+		" max(case when state = 'p' then ts end) last_processed_ts," +
+		" nvl(round((cast(systimestamp as date) - cast(min(case when state = 'n' then ts end) as date)) * 86400), 0) lag_sec" +
+		" from " + r.schema + ".EVENT_LOG" +
+		" where (:1 is null or group_id = :2)" +
+		" group by group_id, part_id" +
+		" order by group_id, part_id"
+
+	rows, err := r.Db.QueryContext(ctx, query, groupId, groupId)
+	if err != nil {
+		return nil, fmt.Errorf("db - get outbox state error: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var result []model.PartState
+	for rows.Next() {
+		var v model.PartState
+		var oldestNew, lastProcessed sql.NullTime
+		var lag int64
+
+		err = rows.Scan(&v.GroupId, &v.PartId, &v.New, &v.Processed, &oldestNew, &lastProcessed, &lag)
+		if err != nil {
+			return nil, fmt.Errorf("db - scan outbox state error: %w", err)
+		}
+
+		v.OldestNew = oldestNew.Time
+		v.LastProcessed = lastProcessed.Time
+		v.Lag = time.Duration(lag) * time.Second
+
+		result = append(result, v)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("db - get outbox state error: %w", err)
+	}
+
+	return result, nil
+}
+
+// GetConsumerState returns the state of the outbox events per named consumer, group and part
+// by the positions of the consumers (see CONSUMER_POSITION table): the events after the position are new,
+// the events at or before it are processed. If groupId is empty, all the groups are returned.
+func (r *Repository) GetConsumerState(ctx context.Context, groupId string) ([]model.PartState, error) {
+	query := "select group_id, consumer, part_id," +
+		" count(case when consumed = 0 then 1 end) new_cnt," +
+		" count(case when consumed = 1 then 1 end) processed_cnt," +
+		" min(case when consumed = 0 then ts end) oldest_new_ts," +
+		" max(case when consumed = 1 then ts end) last_processed_ts," +
+		" nvl(round((cast(systimestamp as date) - cast(min(case when consumed = 0 then ts end) as date)) * 86400), 0) lag_sec" +
+		" from (select p.group_id, p.consumer, p.part_id, e.ts," +
+		" case when e.ts is null then null when " + _consumedByPosition + " then 1 else 0 end consumed" +
+		" from " + r.schema + ".CONSUMER_POSITION p" +
+		" left join " + r.schema + ".EVENT_LOG e on e.group_id = p.group_id and e.part_id = p.part_id" +
+		" where (:1 is null or p.group_id = :2))" +
+		" group by group_id, consumer, part_id" +
+		" order by group_id, consumer, part_id"
+
+	rows, err := r.Db.QueryContext(ctx, query, groupId, groupId)
+	if err != nil {
+		return nil, fmt.Errorf("db - get consumer state error: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var result []model.PartState
+	for rows.Next() {
+		var v model.PartState
+		var oldestNew, lastProcessed sql.NullTime
+		var lag int64
+
+		err = rows.Scan(&v.GroupId, &v.Consumer, &v.PartId, &v.New, &v.Processed, &oldestNew, &lastProcessed, &lag)
+		if err != nil {
+			return nil, fmt.Errorf("db - scan consumer state error: %w", err)
+		}
+
+		v.OldestNew = oldestNew.Time
+		v.LastProcessed = lastProcessed.Time
+		v.Lag = time.Duration(lag) * time.Second
+
+		result = append(result, v)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("db - get consumer state error: %w", err)
+	}
+
+	return result, nil
+}
+
+// Check verifies that the outbox is accessible.
+func (r *Repository) Check(ctx context.Context) error {
+	var n int
+	err := r.Db.QueryRowContext(ctx, "select count(*) from "+r.schema+".EVENT_LOG where rownum = 1").Scan(&n)
+	if err != nil {
+		return fmt.Errorf("db - check outbox error: %w", err)
+	}
+
+	return nil
+}
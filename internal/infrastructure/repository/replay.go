@@ -0,0 +1,310 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/eugene-vodyanko/orgonaut/internal/model"
+	ora "github.com/sijms/go-ora/v2"
+	"log/slog"
+	"time"
+)
+
+// The format of the event time passed to the database (see TS_FORMAT of org$gate_api)
+const (
+	_tsLayout = "2006-01-02 15:04:05.000"
+	_tsFormat = "YYYY-MM-DD HH24:MI:SS.FF3"
+)
+
+// The states of the events (see STATE_NEW and STATE_PROCESSED of org$outbox_api)
+const (
+	_stateNew       = "n"
+	_stateProcessed = "p"
+)
+
+// _consumedByPosition is the condition of the events (e) relayed by the named consumer with the position (p):
+// at or before the SCN and the rowid of the position (see org$gate_api.fetchConsumerEvents), the rowid is unset
+// after the rewind, the position of the previous versions is by the time.
+const _consumedByPosition = "(p.last_scn is not null and (nvl(e.commit_scn, e.ora_rowscn) < p.last_scn" +
+	" or nvl(e.commit_scn, e.ora_rowscn) = p.last_scn and (p.last_rid is null or e.rowid <= p.last_rid))" +
+	" or p.last_scn is null and e.ts <= p.last_ts)"
+
+// consumedEvents returns the source of the events (e) of the group relayed by the consumer with the arguments of
+// its conditions: the events marked as processed for the unnamed consumer, the events at or before the position
+// of the part for the named one (p, see CONSUMER_POSITION table).
+func (r *Repository) consumedEvents(groupId, consumer string) (string, []any) {
+	if consumer == "" {
+		return " from " + r.schema + ".EVENT_LOG e" +
+			" where e.group_id = :1" +
+			" and e.state = :2", []any{groupId, _stateProcessed}
+	}
+
+	return " from " + r.schema + ".EVENT_LOG e, " + r.schema + ".CONSUMER_POSITION p" +
+		" where e.group_id = :1" +
+		" and p.group_id = e.group_id and p.part_id = e.part_id and p.consumer = :2" +
+		" and " + _consumedByPosition, []any{groupId, consumer}
+}
+
+// CountReplayEvents returns the number of the events of the group relayed by the consumer in the window per part
+// (see PartState.Processed), so the replay can be estimated before it is done (dry run).
+func (r *Repository) CountReplayEvents(ctx context.Context, groupId, consumer string,
+	w model.ReplayWindow) ([]model.PartState, error) {
+
+	from, args := r.consumedEvents(groupId, consumer)
+	query := "select e.part_id, count(*)" + from +
+		" and e.ts >= to_timestamp(:3, '" + _tsFormat + "')" +
+		" and e.ts < to_timestamp(:4, '" + _tsFormat + "')" +
+		" and (:5 is null or instr(',' || :6 || ',', ',' || e.part_id || ',') > 0)" +
+		" group by e.part_id" +
+		" order by e.part_id"
+
+	parts := w.PartList()
+
+	rows, err := r.Db.QueryContext(ctx, query,
+		append(args, w.From.Format(_tsLayout), w.To.Format(_tsLayout), parts, parts)...)
+	if err != nil {
+		return nil, fmt.Errorf("db - count replay events error: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var result []model.PartState
+	for rows.Next() {
+		v := model.PartState{GroupId: groupId, Consumer: consumer}
+
+		err = rows.Scan(&v.PartId, &v.Processed)
+		if err != nil {
+			return nil, fmt.Errorf("db - scan replay events error: %w", err)
+		}
+
+		result = append(result, v)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("db - count replay events error: %w", err)
+	}
+
+	return result, nil
+}
+
+// ResetReplayEvents marks up to the limit of the processed events of the group in the window
+// after the position pos (zero - from the start) as new, so they are relayed again by the tasks of the group.
+// The events are taken in order of the time and the rowid, so the events reset by the previous chunks
+// and processed by the tasks since then are not taken again. It returns the number of the reset events
+// and the position of the last one to pass to the next call (pos if there are no more events),
+// the events are reset within the transaction of the context.
+func (r *Repository) ResetReplayEvents(ctx context.Context, groupId string, w model.ReplayWindow,
+	pos model.ReplayPosition, limit int) (int, model.ReplayPosition, error) {
+
+	where := " where group_id = :1" +
+		" and state = :2" +
+		" and ts >= to_timestamp(:3, '" + _tsFormat + "')" +
+		" and ts < to_timestamp(:4, '" + _tsFormat + "')" +
+		" and (:5 is null or instr(',' || :6 || ',', ',' || part_id || ',') > 0)" +
+		" and (:7 is null or ts > to_timestamp(:8, '" + _tsFormat + "')" +
+		" or (ts = to_timestamp(:9, '" + _tsFormat + "') and rowid > chartorowid(:10)))"
+
+	tx, err := getTx(ctx, r.Db)
+	if err != nil {
+		return 0, pos, err
+	}
+
+	var lastTs, lastRid any
+	if pos.Ts != "" {
+		lastTs, lastRid = pos.Ts, pos.Rid
+	}
+
+	parts := w.PartList()
+	args := []any{groupId, _stateProcessed, w.From.Format(_tsLayout), w.To.Format(_tsLayout), parts, parts,
+		lastTs, lastTs, lastTs, lastRid}
+
+	// The last event of the chunk is the upper bound of the update
+	var lastChunkTs, lastChunkRid sql.NullString
+	err = tx.QueryRowContext(ctx,
+		"select max(ts_str) keep (dense_rank last order by ts, rid), max(rid_str) keep (dense_rank last order by ts, rid)"+
+			" from (select ts, rowid rid, to_char(ts, '"+_tsFormat+"') ts_str, rowidtochar(rowid) rid_str"+
+			" from "+r.schema+".EVENT_LOG"+where+" order by ts, rowid) where rownum <= :11",
+		append(args, limit)...).Scan(&lastChunkTs, &lastChunkRid)
+	if err != nil {
+		return 0, pos, fmt.Errorf("db - reset replay events error: %w", classify(err))
+	}
+	if !lastChunkTs.Valid {
+		return 0, pos, nil
+	}
+	next := model.ReplayPosition{Ts: lastChunkTs.String, Rid: lastChunkRid.String}
+
+	res, err := tx.ExecContext(ctx,
+		"update "+r.schema+".EVENT_LOG set state = '"+_stateNew+"'"+where+
+			" and (ts < to_timestamp(:11, '"+_tsFormat+"')"+
+			" or (ts = to_timestamp(:12, '"+_tsFormat+"') and rowid <= chartorowid(:13)))",
+		append(args, next.Ts, next.Ts, next.Rid)...)
+	if err != nil {
+		return 0, pos, fmt.Errorf("db - reset replay events error: %w", classify(err))
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, pos, fmt.Errorf("db - reset replay events error: %w", err)
+	}
+
+	return int(n), next, nil
+}
+
+// RewindConsumer moves the position of the named consumer of the group back before the first relayed event
+// of the window in each part (see CountReplayEvents), so the consumer relays the events again (the events
+// after the window as well, the position is by the commit order). It returns the number of the relayed events
+// of the window, the position is changed within the transaction of the context.
+func (r *Repository) RewindConsumer(ctx context.Context, groupId, consumer string, w model.ReplayWindow) (int, error) {
+	from, args := r.consumedEvents(groupId, consumer)
+	where := " and e.ts >= to_timestamp(:3, '" + _tsFormat + "')" +
+		" and e.ts < to_timestamp(:4, '" + _tsFormat + "')" +
+		" and (:5 is null or instr(',' || :6 || ',', ',' || e.part_id || ',') > 0)"
+
+	tx, err := getTx(ctx, r.Db)
+	if err != nil {
+		return 0, err
+	}
+
+	parts := w.PartList()
+	args = append(args, w.From.Format(_tsLayout), w.To.Format(_tsLayout), parts, parts)
+
+	var n int
+	err = tx.QueryRowContext(ctx, "select count(*)"+from+where, args...).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("db - rewind consumer error: %w", classify(err))
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	// The events of the first SCN are read again, since the rowid is unset
+	_, err = tx.ExecContext(ctx,
+		"merge into "+r.schema+".CONSUMER_POSITION t"+
+			" using (select e.part_id, min(nvl(e.commit_scn, e.ora_rowscn)) - 1 scn"+from+where+" group by e.part_id) v"+
+			" on (t.group_id = :7 and t.consumer = :8 and t.part_id = v.part_id)"+
+			" when matched then update set t.last_scn = v.scn, t.last_rid = null, t.updated_ts = systimestamp",
+		append(args, groupId, consumer)...)
+	if err != nil {
+		return 0, fmt.Errorf("db - rewind consumer error: %w", classify(err))
+	}
+
+	return n, nil
+}
+
+// GetReplayRecords receives the rows of the next chunk of the relayed events of the consumer of the group in the window
+// after the position pos (zero - from the start) in order of the event time. The state of the events
+// is not changed. It returns the position of the last event to pass to the next call,
+// and the done flag if there are no more events.
+func (r *Repository) GetReplayRecords(ctx context.Context, task *model.Task, w model.ReplayWindow,
+	pos model.ReplayPosition) ([]*model.Record, model.ReplayPosition, bool, error) {
+
+	start := time.Now()
+
+	query := "begin " +
+		r.schema +
+		".org$gate_api.getReplayEvents(" +
+		"  p_group_id => :1" +
+		", p_consumer => :2" +
+		", p_parts => :3" +
+		", p_from_ts => :4" +
+		", p_to_ts => :5" +
+		", p_last_ts => :6" +
+		", p_last_rid => :7" +
+		", p_rows => :8" +
+		", p_qry_columns => :9" +
+		", p_qry_from => :10" +
+		", p_qry_pk_column => :11" +
+		", p_before => :12" +
+		", p_compaction => :13" +
+		", r_upd_rows_dump => :14" +
+		", r_upd_rows_count => :15" +
+		", r_del_rows_dump => :16" +
+		", r_del_rows_count => :17" +
+		", r_last_ts => :18" +
+		", r_last_rid => :19" +
+		", r_done => :20" +
+		", r_events_dump => :21" +
+		"); " +
+		"end;"
+
+	tx, err := getTx(ctx, r.Db)
+	if err != nil {
+		return nil, pos, false, err
+	}
+
+	var lastTs, lastRid any
+	if pos.Ts != "" {
+		lastTs, lastRid = pos.Ts, pos.Rid
+	}
+
+	var updRowsDump ora.Blob
+	var updRowsCount int
+	var delRowsDump ora.Blob
+	var delRowsCount int
+	var next model.ReplayPosition
+	var done int
+	var eventsDump ora.Blob
+
+	before := 0
+	if task.HasBeforeImage() {
+		before = 1
+	}
+
+	_, err = tx.ExecContext(ctx, query,
+		task.GroupId,
+		task.Consumer,
+		w.PartList(),
+		w.From.Format(_tsLayout),
+		w.To.Format(_tsLayout),
+		lastTs,
+		lastRid,
+		task.BatchSize,
+		task.Query.Columns,
+		task.Query.From,
+		task.Query.PkColumn,
+		before,
+		compaction(task),
+
+		// output
+		ora.Out{Dest: &updRowsDump, Size: 1000},
+		&updRowsCount,
+		ora.Out{Dest: &delRowsDump, Size: 1000},
+		&delRowsCount,
+		ora.Out{Dest: &next.Ts, Size: 32},
+		ora.Out{Dest: &next.Rid, Size: 32},
+		&done,
+		ora.Out{Dest: &eventsDump, Size: 1000},
+	)
+	if err != nil {
+		return nil, pos, false, fmt.Errorf("db - get replay events error: %w", classify(err))
+	}
+
+	updRecords, err := makeRecords(updRowsDump.Data)
+	if err != nil {
+		return nil, pos, false, fmt.Errorf("db - convert updated rows error: %w", model.NewClassError(model.Poison, err))
+	}
+
+	delRecords, err := makeRecords(delRowsDump.Data)
+	if err != nil {
+		return nil, pos, false, fmt.Errorf("db - convert deleted rows error: %w", model.NewClassError(model.Poison, err))
+	}
+
+	updRecords, delRecords, err = applyEvents(task, eventsDump.Data, updRecords, delRecords)
+	if err != nil {
+		return nil, pos, false, fmt.Errorf("db - convert events error: %w", model.NewClassError(model.Poison, err))
+	}
+
+	slog.Debug("db - get replay records",
+		"elapsed", time.Since(start),
+		"group_id", task.GroupId,
+		"upd_amount", len(updRecords),
+		"del_amount", len(delRecords),
+		"last_ts", next.Ts,
+	)
+
+	records := append(updRecords, delRecords...)
+	if task.Ordered {
+		orderByEvent(records)
+	}
+
+	return records, next, done == 1, nil
+}
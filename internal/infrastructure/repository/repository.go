@@ -1,12 +1,17 @@
 package repository
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"github.com/eugene-vodyanko/orgonaut/internal/model"
 	"github.com/eugene-vodyanko/orgonaut/pkg/oracle"
 	ora "github.com/sijms/go-ora/v2"
 	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -31,19 +36,29 @@ func NewRepository(schema string, db *oracle.Oracle) *Repository {
 func (r *Repository) GetRecords(ctx context.Context, task *model.Task) ([]*model.Record, error) {
 	start := time.Now()
 
-	rowset, err := getGZipXmlRowSet(ctx, task, r.schema, r.Oracle)
+	getRowSet := getGZipXmlRowSet
+	if task.IsPayload() {
+		getRowSet = getGZipXmlPayloadSet
+	}
+
+	rowset, err := getRowSet(ctx, task, r.schema, r.Oracle)
 	if err != nil {
-		return nil, fmt.Errorf("db - get xml rowset error: %w", err)
+		return nil, fmt.Errorf("db - get xml rowset error: %w", classify(err))
 	}
 
 	updRecords, err := makeRecords(rowset.updatedRows)
 	if err != nil {
-		return nil, fmt.Errorf("db - convert updated rows error: %w", err)
+		return nil, fmt.Errorf("db - convert updated rows error: %w", model.NewClassError(model.Poison, err))
 	}
 
 	delRecords, err := makeRecords(rowset.deletedRows)
 	if err != nil {
-		return nil, fmt.Errorf("db - convert deleted rows error: %w", err)
+		return nil, fmt.Errorf("db - convert deleted rows error: %w", model.NewClassError(model.Poison, err))
+	}
+
+	updRecords, delRecords, err = applyEvents(task, rowset.events, updRecords, delRecords)
+	if err != nil {
+		return nil, fmt.Errorf("db - convert events error: %w", model.NewClassError(model.Poison, err))
 	}
 
 	elapsed := time.Now()
@@ -56,14 +71,274 @@ func (r *Repository) GetRecords(ctx context.Context, task *model.Task) ([]*model
 		"del_amount", len(delRecords),
 	)
 
-	return append(updRecords, delRecords...), nil
+	records := append(updRecords, delRecords...)
+	if task.Ordered {
+		orderByEvent(records)
+	}
+
+	return records, nil
 }
 
 type rowSet struct {
 	updatedRows []byte
 	deletedRows []byte
+	events      []byte
+}
+
+// applyEvents sets the events of the dump (see org$gate_api.getNextEvents) to the updated and the deleted rows
+// (the events are compacted, or a record is made per event), then the orphaned update events are handled
+// by the policy of the task.
+func applyEvents(task *model.Task, dump []byte, updRecords, delRecords []*model.Record) ([]*model.Record, []*model.Record, error) {
+	events, err := makeRecords(dump)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	orphans := findOrphans(events, updRecords)
+
+	if task.IsCompacted() {
+		setEvents(task, events, updRecords, delRecords)
+	} else {
+		updRecords, delRecords = expandEvents(task, events, updRecords, delRecords)
+	}
+
+	switch task.Orphans {
+	case model.OrphanLog:
+		if len(orphans) > 0 {
+			keys := make([]string, 0, len(orphans))
+			for _, v := range orphans {
+				keys = append(keys, v.Pk.Value)
+			}
+			slog.Warn("db - orphaned update events, the rows are not found",
+				"group_id", task.GroupId,
+				"part_id", task.PartId,
+				"keys", strings.Join(keys, ","),
+			)
+		}
+	case model.OrphanDelete:
+		for _, v := range orphans {
+			delRecords = append(delRecords, orphanRecord(task, v, model.DELETE))
+		}
+	case model.OrphanDLQ:
+		for _, v := range orphans {
+			r := orphanRecord(task, v, v.Op)
+			r.Error = "orphaned update event, the row is not found"
+			updRecords = append(updRecords, r)
+		}
+	}
+
+	return updRecords, delRecords, nil
+}
+
+// findOrphans returns the last events of the keys, which are inserted or updated by the events of the batch,
+// but their rows are not found by the task query (the keys deleted by the later events are not orphaned).
+func findOrphans(events []*model.Record, updRecords []*model.Record) []*model.Record {
+	found := make(map[string]bool, len(updRecords))
+	for _, v := range updRecords {
+		found[v.Pk.Value] = true
+	}
+
+	last := make(map[string]*model.Record, len(events))
+	var keys []string
+	for _, v := range events {
+		if _, ok := last[v.Pk.Value]; !ok {
+			keys = append(keys, v.Pk.Value)
+		}
+		last[v.Pk.Value] = v
+	}
+
+	var result []*model.Record
+	for _, k := range keys {
+		if v := last[k]; v.Op != "" && v.Op != model.DELETE && !found[k] {
+			result = append(result, v)
+		}
+	}
+
+	return result
+}
+
+// orphanRecord makes the record of the key of the orphaned event with the operation: the fields are the key
+// as of the deleted rows (see org$gate_api.dumpDeletedRows), the time is of the event.
+func orphanRecord(task *model.Task, event *model.Record, op model.Action) *model.Record {
+	r := &model.Record{Meta: event.Meta}
+	r.Pk.Name = task.Query.PkColumn
+	r.Op = op
+	r.Ts, r.UxTs = event.EventTs, event.UxEventTs
+	r.Fields = map[string]string{
+		strings.ToLower(task.Query.PkColumn): event.Pk.Value,
+		"__pk_name":                          task.Query.PkColumn,
+		"__pk_val":                           event.Pk.Value,
+		"__op":                               string(op),
+		"__ts":                               event.EventTs,
+		"__ux_ts":                            event.UxEventTs,
+	}
+	r.SetEvent(event.Meta)
+
+	if task.HasBeforeImage() {
+		r.Before = event.Before
+		r.Image = task.BeforeImage
+	}
+
+	return r
 }
 
+// setEvents sets the time and the sequence number of the events of the records by the key (see org$gate_api.getNextEvents),
+// and the before images in the format of the value of the task, if the task sends them.
+func setEvents(task *model.Task, events []*model.Record, records ...[]*model.Record) {
+	byKey := make(map[string]*model.Record, len(events))
+	for _, v := range events {
+		byKey[v.Pk.Value] = v
+	}
+
+	for _, list := range records {
+		for _, r := range list {
+			if v, ok := byKey[r.Pk.Value]; ok {
+				r.SetEvent(v.Meta)
+				if task.HasBeforeImage() {
+					r.Before = v.Before
+					if v.Error != "" {
+						r.Error = v.Error
+					}
+				}
+			}
+			if task.HasBeforeImage() {
+				r.Image = task.BeforeImage
+			}
+		}
+	}
+}
+
+// expandEvents makes the record of each event of the batch, if the events are not compacted (see Task.Compaction):
+// the row of the key is read once, so the records of the events of the key share its state (the deleted ones - the key).
+// The records are in order of the events, the events of the rows not found by the task query are skipped.
+func expandEvents(task *model.Task, events []*model.Record, updRecords, delRecords []*model.Record) ([]*model.Record, []*model.Record) {
+	byKey := func(records []*model.Record) map[string]*model.Record {
+		m := make(map[string]*model.Record, len(records))
+		for _, v := range records {
+			m[v.Pk.Value] = v
+		}
+		return m
+	}
+	updated, deleted := byKey(updRecords), byKey(delRecords)
+
+	var upd, del []*model.Record
+	for _, ev := range events {
+		rows := updated
+		if ev.Op == model.DELETE {
+			rows = deleted
+		}
+
+		row, ok := rows[ev.Pk.Value]
+		if !ok {
+			continue
+		}
+
+		r := *row
+		r.Fields = maps.Clone(row.Fields)
+		if ev.Op != "" {
+			r.Op = ev.Op
+		}
+		r.SetEvent(ev.Meta)
+		if task.HasBeforeImage() {
+			r.Before = ev.Before
+			r.Image = task.BeforeImage
+			if ev.Error != "" {
+				r.Error = ev.Error
+			}
+		}
+
+		if r.Op == model.DELETE {
+			del = append(del, &r)
+		} else {
+			upd = append(upd, &r)
+		}
+	}
+
+	return upd, del
+}
+
+// orderByEvent sorts the records in order of the events: by the sequence number, or by the time
+// if it is unknown for some of them (the events published before the sequence). The sort is stable,
+// so the records of the same time keep their order.
+func orderByEvent(records []*model.Record) {
+	seq := make(map[*model.Record]int64, len(records))
+	for _, r := range records {
+		v, err := strconv.ParseInt(r.Seq, 10, 64)
+		if err != nil {
+			seq = nil
+			break
+		}
+		seq[r] = v
+	}
+
+	slices.SortStableFunc(records, func(a, b *model.Record) int {
+		if seq != nil {
+			return cmp.Compare(seq[a], seq[b])
+		}
+
+		ta, _ := strconv.ParseInt(a.UxEventTs, 10, 64)
+		tb, _ := strconv.ParseInt(b.UxEventTs, 10, 64)
+		return cmp.Compare(ta, tb)
+	})
+}
+
+// compaction returns the mode of the compaction of the events (see org$gate_api.COMPACTION_NONE).
+func compaction(task *model.Task) int {
+	if task.IsCompacted() {
+		return 1
+	}
+	return 0
+}
+
+// getGZipXmlPayloadSet receives the next payload events of the part as-is (see org$gate_api.getNextPayloadEvents),
+// they are returned as the updated rows.
+func getGZipXmlPayloadSet(ctx context.Context, task *model.Task, schema string, oracle *oracle.Oracle) (*rowSet, error) {
+	query := "begin " +
+		schema +
+		".org$gate_api.getNextPayloadEvents(" +
+		"  p_group_id => :1" +
+		", p_consumer => :2" +
+		", p_part_id => :3" +
+		", p_rows => :4" +
+		", p_pk_name => :5" +
+		", r_rows_dump => :6" +
+		", r_rows_count => :7" +
+		"); " +
+		"end;"
+
+	tx, err := getTx(ctx, oracle.Db)
+	if err != nil {
+		return nil, err
+	}
+
+	var consumer any
+	if task.Consumer != "" {
+		consumer = task.Consumer
+	}
+
+	var rowsDump ora.Blob
+	var rowsCount int
+
+	_, err = tx.ExecContext(ctx, query,
+		task.GroupId,
+		consumer,
+		task.PartId,
+		task.BatchSize,
+		task.PayloadPkName(),
+
+		// output
+		ora.Out{Dest: &rowsDump, Size: 1000},
+		&rowsCount,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rowSet{updatedRows: rowsDump.Data}, nil
+}
+
+// getGZipXmlRowSet receives the next events of the part, the events of the named consumer
+// are read after its position (see org$gate_api.getNextConsumerEvents), otherwise they are marked as processed.
 func getGZipXmlRowSet(ctx context.Context, task *model.Task, schema string, oracle *oracle.Oracle) (*rowSet, error) {
 	query := "begin " +
 		schema +
@@ -74,13 +349,38 @@ func getGZipXmlRowSet(ctx context.Context, task *model.Task, schema string, orac
 		", p_qry_columns => :4" +
 		", p_qry_from => :5" +
 		", p_qry_pk_column => :6" +
-		", r_upd_rows_dump => :7" +
-		", r_upd_rows_count => :8" +
-		", r_del_rows_dump => :9" +
-		", r_del_rows_count => :10" +
+		", p_before => :7" +
+		", p_compaction => :8" +
+		", r_upd_rows_dump => :9" +
+		", r_upd_rows_count => :10" +
+		", r_del_rows_dump => :11" +
+		", r_del_rows_count => :12" +
+		", r_events_dump => :13" +
 		"); " +
 		"end;"
 
+	if task.Consumer != "" {
+		query = "begin " +
+			schema +
+			".org$gate_api.getNextConsumerEvents(" +
+			"  p_group_id => :1" +
+			", p_consumer => :2" +
+			", p_part_id => :3" +
+			", p_rows => :4" +
+			", p_qry_columns => :5" +
+			", p_qry_from => :6" +
+			", p_qry_pk_column => :7" +
+			", p_before => :8" +
+			", p_compaction => :9" +
+			", r_upd_rows_dump => :10" +
+			", r_upd_rows_count => :11" +
+			", r_del_rows_dump => :12" +
+			", r_del_rows_count => :13" +
+			", r_events_dump => :14" +
+			"); " +
+			"end;"
+	}
+
 	tx, err := getTx(ctx, oracle.Db)
 	if err != nil {
 		return nil, err
@@ -91,8 +391,14 @@ func getGZipXmlRowSet(ctx context.Context, task *model.Task, schema string, orac
 	var updRowsCount int
 	var delRowsDump ora.Blob
 	var delRowsCount int
+	var eventsDump ora.Blob
 
-	_, err = tx.ExecContext(ctx, query,
+	before := 0
+	if task.HasBeforeImage() {
+		before = 1
+	}
+
+	args := []any{
 		// eg: "test_tab"
 		task.GroupId,
 		// eg: 2
@@ -105,14 +411,25 @@ func getGZipXmlRowSet(ctx context.Context, task *model.Task, schema string, orac
 		task.Query.From,
 		// eg: "id"
 		task.Query.PkColumn,
+		// eg: 1
+		before,
+		// eg: 1
+		compaction(task),
 
 		// output
 		ora.Out{Dest: &updRowsDump, Size: 1000},
 		&updRowsCount,
 		ora.Out{Dest: &delRowsDump, Size: 1000},
 		&delRowsCount,
-	)
+		ora.Out{Dest: &eventsDump, Size: 1000},
+	}
+
+	if task.Consumer != "" {
+		// eg: "search"
+		args = slices.Insert(args, 1, any(task.Consumer))
+	}
 
+	_, err = tx.ExecContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -125,5 +442,9 @@ func getGZipXmlRowSet(ctx context.Context, task *model.Task, schema string, orac
 		rowset.deletedRows = delRowsDump.Data
 	}
 
+	if eventsDump.Data != nil {
+		rowset.events = eventsDump.Data
+	}
+
 	return &rowset, nil
 }
@@ -1,9 +1,12 @@
 package repository
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"github.com/eugene-vodyanko/orgonaut/internal/model"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"testing"
 	"time"
 )
@@ -69,3 +72,191 @@ func TestRepository_GetRecords(t *testing.T) {
 
 	t.Logf("elapsed: %v", elapsed.Sub(start))
 }
+
+func TestRepository_setEvents(t *testing.T) {
+	// language=xml
+	events := `<?xml version="1.0"?><ROWSET>
+<ROW>
+<__pk_val>2</__pk_val>
+<__ux_event_ts>1718005556948</__ux_event_ts>
+<__event_ts>2024-06-10T07:45:56.948000 +00:00</__event_ts>
+<__seq>1001</__seq>
+<__tx_id>7.12.3301</__tx_id>
+<__commit_scn>88120331</__commit_scn>
+<__before>{&quot;ID&quot;:2,&quot;STR&quot;:&quot;str:1&quot;}</__before>
+</ROW>
+</ROWSET>
+`
+
+	var dump bytes.Buffer
+	w := gzip.NewWriter(&dump)
+	_, err := w.Write([]byte(events))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	updated := []*model.Record{
+		{Meta: model.Meta{Pk: model.Pk{Name: "id", Value: "2"}, Op: model.UPDATE}, Fields: map[string]string{"id": "2"}},
+		// The event of the row is not found (e.g. the key is not of the chunk)
+		{Meta: model.Meta{Pk: model.Pk{Name: "id", Value: "3"}, Op: model.UPDATE}, Fields: map[string]string{"id": "3"}},
+	}
+
+	records, err := makeRecords(dump.Bytes())
+	require.NoError(t, err)
+
+	task := &model.Task{}
+	setEvents(task, records, updated)
+
+	assert.Equal(t, "1001", updated[0].Seq)
+	assert.Equal(t, "1718005556948", updated[0].UxEventTs)
+	assert.Equal(t, "2024-06-10T07:45:56.948000 +00:00", updated[0].Fields["__event_ts"])
+	assert.Equal(t, "7.12.3301", updated[0].TxId)
+	assert.Equal(t, "88120331", updated[0].Fields["__commit_scn"])
+	assert.Nil(t, updated[0].Before)
+	assert.Empty(t, updated[1].Seq)
+
+	// The before images are set if the task sends them
+	task.BeforeImage = model.ImageFull
+	setEvents(task, records, updated)
+	assert.Equal(t, map[string]string{"id": "2", "str": "str:1"}, updated[0].Before)
+	assert.Equal(t, model.ImageFull, updated[1].Image)
+}
+
+func TestRepository_expandEvents(t *testing.T) {
+	// language=xml
+	events := `<?xml version="1.0"?><ROWSET>
+<ROW><__pk_val>2</__pk_val><__op>c</__op><__ux_event_ts>1718005556948</__ux_event_ts><__seq>1001</__seq></ROW>
+<ROW><__pk_val>3</__pk_val><__op>d</__op><__ux_event_ts>1718005556949</__ux_event_ts><__seq>1002</__seq></ROW>
+<ROW><__pk_val>2</__pk_val><__op>u</__op><__ux_event_ts>1718005556950</__ux_event_ts><__seq>1003</__seq>
+<__before>{&quot;ID&quot;:2,&quot;STR&quot;:&quot;str:1&quot;}</__before></ROW>
+<ROW><__pk_val>4</__pk_val><__op>u</__op><__ux_event_ts>1718005556951</__ux_event_ts><__seq>1004</__seq></ROW>
+</ROWSET>
+`
+
+	var dump bytes.Buffer
+	w := gzip.NewWriter(&dump)
+	_, err := w.Write([]byte(events))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	updated := []*model.Record{
+		{Meta: model.Meta{Pk: model.Pk{Name: "id", Value: "2"}, Op: model.UPDATE}, Fields: map[string]string{"id": "2", "str": "str:2"}},
+	}
+	deleted := []*model.Record{
+		{Meta: model.Meta{Pk: model.Pk{Name: "id", Value: "3"}, Op: model.DELETE}, Fields: map[string]string{"ID": "3"}},
+	}
+
+	task := &model.Task{Compaction: model.CompactionNone, BeforeImage: model.ImageFull}
+	records, err := makeRecords(dump.Bytes())
+	require.NoError(t, err)
+
+	upd, del := expandEvents(task, records, updated, deleted)
+
+	// The row of the key 4 is not found, its event is skipped
+	require.Len(t, upd, 2)
+	require.Len(t, del, 1)
+
+	assert.Equal(t, model.CREATE, upd[0].Op)
+	assert.Equal(t, "1001", upd[0].Seq)
+	assert.Nil(t, upd[0].Before)
+	assert.Equal(t, model.UPDATE, upd[1].Op)
+	assert.Equal(t, "1003", upd[1].Fields["__seq"])
+	assert.Equal(t, map[string]string{"id": "2", "str": "str:1"}, upd[1].Before)
+	assert.Equal(t, "str:2", upd[1].Fields["str"])
+	assert.Equal(t, "1001", upd[0].Fields["__seq"], "the fields are not shared")
+
+	assert.Equal(t, "1002", del[0].Seq)
+	assert.Equal(t, model.ImageFull, del[0].Image)
+}
+
+func TestRepository_applyEventsOrphans(t *testing.T) {
+	// language=xml
+	events := `<?xml version="1.0"?><ROWSET>
+<ROW><__pk_val>2</__pk_val><__op>u</__op><__ux_event_ts>1718005556948</__ux_event_ts><__event_ts>2024-06-10T07:45:56.948000 +00:00</__event_ts><__seq>1001</__seq></ROW>
+<ROW><__pk_val>3</__pk_val><__op>u</__op><__ux_event_ts>1718005556949</__ux_event_ts><__event_ts>2024-06-10T07:45:56.949000 +00:00</__event_ts><__seq>1002</__seq></ROW>
+<ROW><__pk_val>4</__pk_val><__op>d</__op><__ux_event_ts>1718005556950</__ux_event_ts><__seq>1003</__seq></ROW>
+</ROWSET>
+`
+
+	var dump bytes.Buffer
+	w := gzip.NewWriter(&dump)
+	_, err := w.Write([]byte(events))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	rows := func() ([]*model.Record, []*model.Record) {
+		return []*model.Record{
+			{Meta: model.Meta{Pk: model.Pk{Name: "id", Value: "2"}, Op: model.UPDATE}, Fields: map[string]string{"id": "2"}},
+		}, []*model.Record{
+			{Meta: model.Meta{Pk: model.Pk{Name: "id", Value: "4"}, Op: model.DELETE}, Fields: map[string]string{"id": "4"}},
+		}
+	}
+
+	task := &model.Task{Query: model.Query{PkColumn: "id"}}
+
+	// The row of the key 3 is not found, the event is skipped
+	for _, policy := range []string{model.OrphanIgnore, model.OrphanLog} {
+		task.Orphans = policy
+		upd, del := rows()
+		upd, del, err = applyEvents(task, dump.Bytes(), upd, del)
+		require.NoError(t, err)
+		assert.Len(t, upd, 1)
+		assert.Len(t, del, 1)
+	}
+
+	task.Orphans = model.OrphanDelete
+	upd, del := rows()
+	upd, del, err = applyEvents(task, dump.Bytes(), upd, del)
+	require.NoError(t, err)
+	require.Len(t, upd, 1)
+	require.Len(t, del, 2)
+	assert.Equal(t, model.DELETE, del[1].Op)
+	assert.Equal(t, model.Pk{Name: "id", Value: "3"}, del[1].Pk)
+	assert.Equal(t, "1002", del[1].Seq)
+	assert.Equal(t, "3", del[1].Fields["id"])
+	assert.Equal(t, "2024-06-10T07:45:56.949000 +00:00", del[1].Ts)
+	assert.Empty(t, del[1].Error)
+
+	task.Orphans = model.OrphanDLQ
+	upd, del = rows()
+	upd, del, err = applyEvents(task, dump.Bytes(), upd, del)
+	require.NoError(t, err)
+	require.Len(t, upd, 2)
+	require.Len(t, del, 1)
+	assert.Equal(t, model.UPDATE, upd[1].Op)
+	assert.Equal(t, "3", upd[1].Pk.Value)
+	assert.NotEmpty(t, upd[1].Error)
+	assert.Empty(t, upd[0].Error)
+}
+
+func TestRepository_orderByEvent(t *testing.T) {
+	record := func(key, seq, ts string) *model.Record {
+		return &model.Record{Meta: model.Meta{Pk: model.Pk{Name: "id", Value: key}, Seq: seq, UxEventTs: ts}}
+	}
+	keys := func(records []*model.Record) []string {
+		var result []string
+		for _, v := range records {
+			result = append(result, v.Pk.Value)
+		}
+		return result
+	}
+
+	// The updated rows go first, then the deleted ones
+	records := []*model.Record{
+		record("1", "12", "1718005556950"),
+		record("2", "9", "1718005556948"),
+		record("3", "10", "1718005556948"),
+		record("4", "11", "1718005556949"),
+	}
+	orderByEvent(records)
+	assert.Equal(t, []string{"2", "3", "4", "1"}, keys(records))
+
+	// The time is used if the sequence is unknown, the records of the same time keep their order
+	records = []*model.Record{
+		record("1", "12", "1718005556950"),
+		record("3", "", "1718005556948"),
+		record("2", "9", "1718005556948"),
+		record("4", "11", "1718005556949"),
+	}
+	orderByEvent(records)
+	assert.Equal(t, []string{"3", "2", "4", "1"}, keys(records))
+}
@@ -0,0 +1,302 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	scripts "github.com/eugene-vodyanko/orgonaut/scripts/sql"
+	"github.com/sijms/go-ora/v2/network"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// APIVersion is the version of the API of the packages the application works with (see org$gate_api.API_VERSION).
+const APIVersion = 1
+
+// Migration is the versioned change of the schema, the migrations are applied once in order of the version.
+type Migration struct {
+	Version int
+	Name    string
+	// Scripts are the embedded scripts (see scripts/sql), they are executed before the statements
+	Scripts []string
+	// Statements are the single SQL statements
+	Statements []string
+	// Packages re-creates the packages by their current scripts
+	Packages bool
+}
+
+// AppliedMigration is the migration recorded in the SCHEMA_MIGRATION table.
+type AppliedMigration struct {
+	Version   int
+	Name      string
+	AppliedTs time.Time
+}
+
+// The packages in order of the dependencies
+var packages = []string{
+	"org$util.pck",
+	"org$xml_encode.sql",
+	"org$xml_factory.sql",
+	"org$outbox_api.sql",
+	"org$gate_api.sql",
+}
+
+// Migrations are idempotent: the errors of the existing objects are ignored, so the migrations
+// of the table scripts and the alters are also applied to the schema installed by install.sql
+// (and to the EVENT_LOG table of the versions before the migrator).
+//
+// The released migrations are never changed: after a release, each change of the schema is a new version
+// (the new tables by their scripts, the new columns by the alters, the changed table scripts are kept
+// in sync with the alters for the new installations). The packages are re-created by a new migration
+// on each change of them (with API_VERSION if it is incompatible), the scripts are the current ones,
+// so only the last of such migrations is executed, the previous ones are recorded
+// (the current packages may depend on the objects of the later migrations).
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "tables",
+		Scripts: []string{"org_event_log.sql", "org_task_state.sql", "org_snapshot_state.sql", "org_consumer_position.sql",
+			"org_outbox_setting.sql"},
+	},
+	{
+		Version:    2,
+		Name:       "event_log payload and headers",
+		Statements: []string{"alter table EVENT_LOG add (payload CLOB, headers VARCHAR2(4000))"},
+	},
+	{
+		Version:    3,
+		Name:       "event_log before image",
+		Statements: []string{"alter table EVENT_LOG add (before_image CLOB)"},
+	},
+	{
+		Version: 4,
+		Name:    "event_log sequence",
+		Statements: []string{
+			"create sequence EVENT_LOG_SEQ cache 1000",
+			"alter table EVENT_LOG add (seq NUMBER)",
+		},
+	},
+	{
+		Version:    5,
+		Name:       "event_log transaction",
+		Statements: []string{"alter table EVENT_LOG add (tx_id VARCHAR2(64), commit_scn NUMBER)"},
+	},
+	{
+		Version: 6,
+		Name:    "event_log transaction index",
+		Scripts: []string{"org_event_log_tx.sql"},
+	},
+	{
+		Version:  7,
+		Name:     "packages api 1",
+		Packages: true,
+	},
+}
+
+// Oracle error codes of the existing objects
+var existsErrCodes = []int{
+	955,  // name is already used by an existing object
+	1408, // such column list already indexed
+	1430, // column being added already exists in table
+	2260, // table can have only one primary key
+	2275, // such a referential constraint already exists in the table
+}
+
+// GetAppliedMigrations returns the applied migrations in order of the version,
+// ok is false if the SCHEMA_MIGRATION table does not exist (the migrator is not used yet).
+func (r *Repository) GetAppliedMigrations(ctx context.Context) (result []AppliedMigration, ok bool, err error) {
+	rows, err := r.Db.QueryContext(ctx,
+		"select version, name, applied_ts from "+r.schema+".SCHEMA_MIGRATION order by version")
+	if err != nil {
+		var oraErr *network.OracleError
+		if errors.As(err, &oraErr) && oraErr.ErrCode == 942 {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("db - get migrations error: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var v AppliedMigration
+		if err = rows.Scan(&v.Version, &v.Name, &v.AppliedTs); err != nil {
+			return nil, false, fmt.Errorf("db - scan migrations error: %w", err)
+		}
+		result = append(result, v)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("db - get migrations error: %w", err)
+	}
+
+	return result, true, nil
+}
+
+// ApplyMigration executes the statements of the migration in the schema, then it is recorded
+// (the DDL statements are committed implicitly, so the migration is not atomic, but it is idempotent).
+// The SCHEMA_MIGRATION table is created if it does not exist.
+func (r *Repository) ApplyMigration(ctx context.Context, m Migration) error {
+	names := append([]string{"org_schema_migration.sql"}, m.Scripts...)
+	if m.Packages && m.Version == lastPackagesVersion() {
+		names = append(names, packages...)
+	}
+
+	var statements []string
+	for _, name := range names {
+		script, err := readScript(name)
+		if err != nil {
+			return fmt.Errorf("db - migration %d error: %w", m.Version, err)
+		}
+		statements = append(statements, splitScript(script)...)
+	}
+	statements = append(statements, m.Statements...)
+
+	conn, err := r.Db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("db - migration %d connection error: %w", m.Version, err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	// The objects are created unqualified, the packages are qualified by the schema
+	_, err = conn.ExecContext(ctx, "alter session set current_schema = "+r.schema)
+	if err != nil {
+		return fmt.Errorf("db - migration %d set schema error: %w", m.Version, err)
+	}
+
+	for _, v := range statements {
+		_, err = conn.ExecContext(ctx, qualify(v, r.schema))
+		if err != nil && !isExistsError(err) {
+			return fmt.Errorf("db - migration %d statement error: %w\n%s", m.Version, err, firstLine(v))
+		}
+	}
+
+	// The compilation errors of the packages are not reported by the statements
+	var invalid sql.NullString
+	err = conn.QueryRowContext(ctx,
+		"select listagg(object_name || ' ' || object_type, ', ') within group (order by object_name)"+
+			" from all_objects where owner = sys_context('userenv', 'current_schema')"+
+			" and object_name like 'ORG$%' and status = 'INVALID'").Scan(&invalid)
+	if err != nil {
+		return fmt.Errorf("db - migration %d check objects error: %w", m.Version, err)
+	}
+	if invalid.String != "" {
+		return fmt.Errorf("db - migration %d error: invalid objects: %s", m.Version, invalid.String)
+	}
+
+	_, err = conn.ExecContext(ctx,
+		"insert into SCHEMA_MIGRATION (version, name, applied_ts) values (:1, :2, systimestamp)", m.Version, m.Name)
+	if err != nil {
+		return fmt.Errorf("db - record migration %d error: %w", m.Version, err)
+	}
+
+	return nil
+}
+
+// GetAPIVersion returns the version of the API of the installed packages (see org$gate_api.apiVersion).
+func (r *Repository) GetAPIVersion(ctx context.Context) (int, error) {
+	var version int
+	_, err := r.Db.ExecContext(ctx, "begin :1 := "+r.schema+".org$gate_api.apiVersion(); end;", &version)
+	if err != nil {
+		return 0, fmt.Errorf("db - get api version error: %w", err)
+	}
+	return version, nil
+}
+
+// HasRowDependencies reports whether EVENT_LOG keeps the SCN per row (rowdependencies, see org_event_log.sql),
+// otherwise ora_rowscn is of the block, so the commit order of the events and the counts of the transactions
+// are approximate. It is set on the creation of the table only, the tables of the previous versions lack it.
+func (r *Repository) HasRowDependencies(ctx context.Context) (bool, error) {
+	var deps string
+	err := r.Db.QueryRowContext(ctx,
+		"select dependencies from all_tables where owner = upper(:1) and table_name = 'EVENT_LOG'", r.schema).Scan(&deps)
+	if err != nil {
+		return false, fmt.Errorf("db - get event_log dependencies error: %w", err)
+	}
+	return deps == "ENABLED", nil
+}
+
+func lastPackagesVersion() int {
+	version := 0
+	for _, v := range Migrations {
+		if v.Packages {
+			version = v.Version
+		}
+	}
+	return version
+}
+
+func readScript(name string) (string, error) {
+	b, err := scripts.FS.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+var (
+	plsqlRe  = regexp.MustCompile(`(?i)^(create\s+(or\s+replace\s+)?(package|procedure|function|trigger|type)\b|begin\b|declare\b)`)
+	schemaRe = regexp.MustCompile(`(?i)\borgon\.`)
+)
+
+// splitScript splits the SQL*Plus script into the statements: the PL/SQL units are terminated by the slash line,
+// the other statements by the semicolon at the end of the line. The comments between the statements are skipped.
+func splitScript(script string) []string {
+	var result []string
+	var stmt []string
+	plsql := false
+
+	for _, line := range strings.Split(strings.ReplaceAll(script, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if len(stmt) == 0 {
+			if trimmed == "" || trimmed == "/" || strings.HasPrefix(trimmed, "--") {
+				continue
+			}
+			plsql = plsqlRe.MatchString(trimmed)
+		}
+
+		switch {
+		case plsql && trimmed == "/":
+			result = append(result, strings.TrimSpace(strings.Join(stmt, "\n")))
+			stmt = nil
+		case !plsql && strings.HasSuffix(trimmed, ";"):
+			stmt = append(stmt, strings.TrimSuffix(strings.TrimRight(line, " \t"), ";"))
+			result = append(result, strings.TrimSpace(strings.Join(stmt, "\n")))
+			stmt = nil
+		default:
+			stmt = append(stmt, line)
+		}
+	}
+
+	if len(stmt) > 0 {
+		result = append(result, strings.TrimSpace(strings.Join(stmt, "\n")))
+	}
+
+	return result
+}
+
+// qualify replaces the default schema of the scripts (orgon) with the schema.
+func qualify(stmt, schema string) string {
+	return schemaRe.ReplaceAllLiteralString(stmt, schema+".")
+}
+
+func isExistsError(err error) bool {
+	var oraErr *network.OracleError
+	if !errors.As(err, &oraErr) {
+		return false
+	}
+	for _, v := range existsErrCodes {
+		if oraErr.ErrCode == v {
+			return true
+		}
+	}
+	return false
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
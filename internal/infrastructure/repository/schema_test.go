@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestSchema_splitScript(t *testing.T) {
+	// language=sql
+	script := `-- The comment
+create table T
+(
+  id NUMBER -- the key
+);
+
+alter table T add constraint T_PK primary key (ID);
+create or replace package orgon.p is
+procedure a;
+end p;
+/
+
+create or replace package body orgon.p is
+procedure a is begin null; end;
+end p;
+/
+create index T_IDX on T (ID)`
+
+	statements := splitScript(script)
+	require.Len(t, statements, 5)
+	assert.Equal(t, "create table T\n(\n  id NUMBER -- the key\n)", statements[0])
+	assert.Equal(t, "alter table T add constraint T_PK primary key (ID)", statements[1])
+	assert.Equal(t, "create or replace package orgon.p is\nprocedure a;\nend p;", statements[2])
+	assert.True(t, strings.HasSuffix(statements[3], "end p;"))
+	assert.Equal(t, "create index T_IDX on T (ID)", statements[4])
+
+	assert.Equal(t, "create or replace package app.p is", qualify("create or replace package ORGON.p is", "app"))
+}
+
+func TestSchema_Migrations(t *testing.T) {
+	for i, m := range Migrations {
+		assert.Equal(t, i+1, m.Version, "versions are ordered without gaps")
+
+		for _, name := range m.Scripts {
+			script, err := readScript(name)
+			require.NoError(t, err)
+			assert.NotEmpty(t, splitScript(script), name)
+		}
+	}
+
+	// The packages are the spec and the body each
+	for _, name := range packages {
+		script, err := readScript(name)
+		require.NoError(t, err)
+
+		statements := splitScript(script)
+		assert.Len(t, statements, 2, name)
+		for _, v := range statements {
+			assert.Regexp(t, `^create or replace package (body )?orgon\.org\$\w+ is`, v)
+			assert.True(t, strings.HasSuffix(v, ";"), name)
+		}
+	}
+
+	// The last version of the packages is the required one
+	script, err := readScript("org$gate_api.sql")
+	require.NoError(t, err)
+	assert.Contains(t, script, fmt.Sprintf("API_VERSION constant number := %d;", APIVersion))
+}
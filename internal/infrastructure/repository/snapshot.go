@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/eugene-vodyanko/orgonaut/internal/model"
+	ora "github.com/sijms/go-ora/v2"
+	"log/slog"
+	"time"
+)
+
+// GetSnapshotRecords receives the next chunk of the snapshot (initial load) of the task query
+// in ascending order of the primary key, the records have the READ action.
+//
+// The position of the snapshot is kept in the database (see SNAPSHOT_STATE table, by the group and the consumer) and advanced within
+// the transaction of the context, so the snapshot is resumed from the last committed chunk.
+// The done flag is true when the snapshot is complete (the last chunk may be non-empty).
+func (r *Repository) GetSnapshotRecords(ctx context.Context, task *model.Task) ([]*model.Record, bool, error) {
+	start := time.Now()
+
+	query := "begin " +
+		r.schema +
+		".org$gate_api.getSnapshotRows(" +
+		"  p_group_id => :1" +
+		", p_consumer => :2" +
+		", p_rows => :3" +
+		", p_qry_columns => :4" +
+		", p_qry_from => :5" +
+		", p_qry_pk_column => :6" +
+		", r_rows_dump => :7" +
+		", r_rows_count => :8" +
+		", r_done => :9" +
+		"); " +
+		"end;"
+
+	tx, err := getTx(ctx, r.Db)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var rowsDump ora.Blob
+	var rowsCount int
+	var done int
+
+	_, err = tx.ExecContext(ctx, query,
+		// The snapshot of each consumer of the group is kept separately
+		task.GroupId,
+		task.Consumer,
+		task.BatchSize,
+		task.Query.Columns,
+		task.Query.From,
+		task.Query.PkColumn,
+
+		// output
+		ora.Out{Dest: &rowsDump, Size: 1000},
+		&rowsCount,
+		&done,
+	)
+	if err != nil {
+		return nil, false, fmt.Errorf("db - get snapshot rows error: %w", classify(err))
+	}
+
+	records, err := makeRecords(rowsDump.Data)
+	if err != nil {
+		return nil, false, fmt.Errorf("db - convert snapshot rows error: %w", model.NewClassError(model.Poison, err))
+	}
+
+	slog.Debug("db - get snapshot records",
+		"elapsed", time.Since(start),
+		"group_id", task.GroupId,
+		"amount", len(records),
+		"done", done == 1,
+	)
+
+	return records, done == 1, nil
+}
@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	pausedYes = "y"
+	pausedNo  = "n"
+)
+
+// SavePaused persists the pause state of the runner task (see TASK_STATE table),
+// so it survives the restarts of the application.
+func (r *Repository) SavePaused(ctx context.Context, tag string, paused bool) error {
+	flag := pausedNo
+	if paused {
+		flag = pausedYes
+	}
+
+	query := "merge into " + r.schema + ".TASK_STATE s" +
+		" using (select :1 tag, :2 paused from dual) n" +
+		" on (s.tag = n.tag)" +
+		" when matched then update set s.paused = n.paused, s.updated_ts = systimestamp" +
+		" when not matched then insert (tag, paused, updated_ts) values (n.tag, n.paused, systimestamp)"
+
+	_, err := r.Db.ExecContext(ctx, query, tag, flag)
+	if err != nil {
+		return fmt.Errorf("db - save task state error: %w", err)
+	}
+
+	return nil
+}
+
+// GetPaused returns the tags of the paused runner tasks.
+func (r *Repository) GetPaused(ctx context.Context) ([]string, error) {
+	rows, err := r.Db.QueryContext(ctx,
+		"select tag from "+r.schema+".TASK_STATE where paused = :1", pausedYes)
+	if err != nil {
+		return nil, fmt.Errorf("db - get task state error: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var tags []string
+	for rows.Next() {
+		var tag string
+		if err = rows.Scan(&tag); err != nil {
+			return nil, fmt.Errorf("db - scan task state error: %w", err)
+		}
+		tags = append(tags, tag)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("db - get task state error: %w", err)
+	}
+
+	return tags, nil
+}
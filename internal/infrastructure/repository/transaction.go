@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/eugene-vodyanko/orgonaut/internal/model"
+	"strconv"
+	"strings"
+)
+
+// The maximum number of the expressions in the list of Oracle
+const _maxInList = 1000
+
+// GetTxCounts returns the number of the events of the transactions in the outbox per group (see model.TxMarker),
+// the groups are in order of the id. The events are matched by the id and the commit SCN of the transaction
+// (the SCN of the rows of EVENT_LOG, see org_event_log.sql), so the earlier transactions of the reused ids
+// are not counted.
+func (r *Repository) GetTxCounts(ctx context.Context, txs []model.TxRef) (map[model.TxRef][]model.TxGroupCount, error) {
+	result := make(map[model.TxRef][]model.TxGroupCount, len(txs))
+
+	for len(txs) > 0 {
+		chunk := txs[:min(len(txs), _maxInList)]
+		txs = txs[len(chunk):]
+
+		ids := make([]string, len(chunk))
+		pairs := make([]string, len(chunk))
+		args := make([]any, 0, 3*len(chunk))
+		for i, v := range chunk {
+			n := len(chunk) + 2*i
+			ids[i] = ":" + strconv.Itoa(i+1)
+			pairs[i] = "(:" + strconv.Itoa(n+1) + ", :" + strconv.Itoa(n+2) + ")"
+			args = append(args, v.Id)
+		}
+		for _, v := range chunk {
+			args = append(args, v.Id, v.CommitScn)
+		}
+
+		// The ids are matched by the index, then the pairs are filtered
+		query := "select tx_id, scn, group_id, count(*) from" +
+			" (select tx_id, to_char(nvl(commit_scn, ora_rowscn)) scn, group_id from " + r.schema + ".EVENT_LOG" +
+			" where tx_id in (" + strings.Join(ids, ", ") + "))" +
+			" where (tx_id, scn) in (" + strings.Join(pairs, ", ") + ")" +
+			" group by tx_id, scn, group_id" +
+			" order by tx_id, scn, group_id"
+
+		err := func() error {
+			rows, err := r.Db.QueryContext(ctx, query, args...)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = rows.Close() }()
+
+			for rows.Next() {
+				var ref model.TxRef
+				var v model.TxGroupCount
+				if err = rows.Scan(&ref.Id, &ref.CommitScn, &v.GroupId, &v.EventCount); err != nil {
+					return err
+				}
+				result[ref] = append(result[ref], v)
+			}
+
+			return rows.Err()
+		}()
+		if err != nil {
+			return nil, fmt.Errorf("db - get tx counts error: %w", classify(err))
+		}
+	}
+
+	return result, nil
+}
@@ -20,7 +20,7 @@ func NewTxManager(db *sql.DB) *TxManager {
 //
 // The transaction commits when function were finished without error and rollback in other
 func (tm *TxManager) WithinTransaction(ctx context.Context, txFunc func(ctx context.Context) error) error {
-	tx, err := tm.db.Begin()
+	tx, err := tm.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("begin transaction error: %w", err)
 	}
@@ -57,5 +57,5 @@ func getTx(ctx context.Context, db *sql.DB) (*sql.Tx, error) {
 		return tx, nil
 	}
 
-	return db.Begin()
+	return db.BeginTx(ctx, nil)
 }
@@ -0,0 +1,59 @@
+package model
+
+import "errors"
+
+// ErrorClass defines how a failure of the relay is handled
+type ErrorClass int
+
+const (
+	// Retriable failures are transient (e.g. lost connection, leader election), the relay is retried
+	Retriable ErrorClass = iota
+	// Poison failures are caused by the data of the records (e.g. undecodable row, too large message),
+	// retrying the same batch does not help
+	Poison
+	// Fatal failures are caused by the configuration (e.g. unknown table or column),
+	// they persist until the configuration is fixed
+	Fatal
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case Poison:
+		return "poison"
+	case Fatal:
+		return "fatal"
+	default:
+		return "retriable"
+	}
+}
+
+// ClassError is the error with the class
+type ClassError struct {
+	Class ErrorClass
+	Err   error
+}
+
+func (e *ClassError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ClassError) Unwrap() error {
+	return e.Err
+}
+
+// NewClassError classifies the error, nil error stays nil
+func NewClassError(class ErrorClass, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ClassError{Class: class, Err: err}
+}
+
+// ClassOf returns the class of the error, the errors not classified are retriable
+func ClassOf(err error) ErrorClass {
+	var e *ClassError
+	if errors.As(err, &e) {
+		return e.Class
+	}
+	return Retriable
+}
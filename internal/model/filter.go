@@ -0,0 +1,70 @@
+package model
+
+import (
+	"errors"
+	"regexp"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+var (
+	numberRe = regexp.MustCompile(`^-?\d+(\.\d+)?$`)
+	columnRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_$#]{0,127}$`)
+)
+
+const (
+	// MaxFilterKeys is the maximum number of the keys of the filter (the limit of the IN list in Oracle)
+	MaxFilterKeys = 1000
+	// MaxFilterConditions is the maximum number of the conditions of the filter
+	MaxFilterConditions = 10
+)
+
+// FilterOps are the operators of the filter conditions
+var FilterOps = []interface{}{"=", "<>", "<", "<=", ">", ">=", "like"}
+
+// Filter selects the rows of the task query to re-emit (backfill): by the primary key range,
+// by the list of the keys and/or by the conditions, the conditions are combined with AND.
+// The values are passed to the database as the bind variables.
+type Filter struct {
+	// FromPk is the lower bound of the key (inclusive), empty - unbounded
+	FromPk string `json:"from_pk,omitempty"`
+	// ToPk is the upper bound of the key (inclusive), empty - unbounded
+	ToPk string `json:"to_pk,omitempty"`
+	// Keys is the list of the keys (see MaxFilterKeys)
+	Keys []string `json:"keys,omitempty"`
+	// Conditions on the columns of the query, e.g.: {"column": "region", "op": "=", "value": "EU"}
+	Conditions []Condition `json:"conditions,omitempty"`
+}
+
+// Condition compares the column of the query with the value.
+type Condition struct {
+	// Column is the name of the column of the query (the plain identifier)
+	Column string `json:"column"`
+	// Op is one of FilterOps
+	Op    string `json:"op"`
+	Value string `json:"value"`
+}
+
+func (f *Filter) Validate() error {
+	if f.FromPk == "" && f.ToPk == "" && len(f.Keys) == 0 && len(f.Conditions) == 0 {
+		return errors.New("pk range, keys or conditions are required")
+	}
+
+	return validation.ValidateStruct(
+		f,
+		validation.Field(&f.FromPk, validation.Match(numberRe)),
+		validation.Field(&f.ToPk, validation.Match(numberRe)),
+		validation.Field(&f.Keys, validation.Length(0, MaxFilterKeys),
+			validation.Each(validation.Required, validation.Match(numberRe))),
+		validation.Field(&f.Conditions, validation.Length(0, MaxFilterConditions)),
+	)
+}
+
+func (c Condition) Validate() error {
+	return validation.ValidateStruct(
+		&c,
+		validation.Field(&c.Column, validation.Required, validation.Match(columnRe)),
+		validation.Field(&c.Op, validation.Required, validation.In(FilterOps...)),
+		validation.Field(&c.Value, validation.Length(0, 4000)),
+	)
+}
@@ -0,0 +1,65 @@
+package model
+
+import "strings"
+
+// The formats of the before image of the rows (see Task.BeforeImage)
+const (
+	// ImageNone sends the fields of the row (default)
+	ImageNone = "none"
+	// ImageFull sends the state of the row before and after the change
+	ImageFull = "full"
+	// ImageDiff sends the changed columns only, before and after the change
+	ImageDiff = "diff"
+)
+
+// envelope makes the value of the row event with the before image:
+// the meta fields and the state of the row before and after the change (null - the row does not exist)
+func (r *Record) envelope() map[string]any {
+	result := make(map[string]any, 8)
+
+	var after map[string]string
+	if r.Op != DELETE {
+		after = make(map[string]string, len(r.Fields))
+	}
+
+	for k, v := range r.Fields {
+		if strings.HasPrefix(k, "__") {
+			result[k] = v
+		} else if after != nil {
+			after[k] = v
+		}
+	}
+
+	before := r.Before
+	if r.Image == ImageDiff && before != nil && after != nil {
+		before, after = diff(before, after)
+	}
+
+	result["before"] = before
+	result["after"] = after
+
+	return result
+}
+
+// diff returns the values of the changed columns only, the missing column is null
+func diff(before, after map[string]string) (map[string]string, map[string]string) {
+	b := make(map[string]string)
+	a := make(map[string]string)
+
+	for k, v := range before {
+		if w, ok := after[k]; !ok || w != v {
+			b[k] = v
+			if ok {
+				a[k] = w
+			}
+		}
+	}
+
+	for k, w := range after {
+		if _, ok := before[k]; !ok {
+			a[k] = w
+		}
+	}
+
+	return b, a
+}
@@ -4,6 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	validation "github.com/go-ozzo/ozzo-validation"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Action string
@@ -13,6 +16,7 @@ const (
 	CREATE Action = "c" // insert
 	UPDATE Action = "u" // update
 	DELETE Action = "d" // delete
+	READ   Action = "r" // snapshot read
 )
 
 // Record describes the internal representation of the modified row from the database
@@ -21,6 +25,18 @@ const (
 type Record struct {
 	Meta
 	Fields map[string]string
+	// Payload is the application-provided value of the payload event (see ModePayload), it is sent as-is
+	// instead of the fields (empty - the message without a value), nil - the event of the row.
+	Payload []byte
+	// Headers are the application-provided headers of the payload event
+	Headers map[string]string
+	// Before is the state of the row before the change (the before image), nil - not captured or the new row
+	Before map[string]string
+	// Image is the format of the value with the before image: ImageNone (empty), ImageFull or ImageDiff
+	Image string
+	// Error is the reason the record is not sent to the topic of the task (e.g. the orphaned update event,
+	// the poison record), it is sent to the dead letter topic or skipped (see Task.DLQTopic, Task.OnPoison), empty - the regular record
+	Error string
 }
 
 // Meta information contains auxiliary fields.
@@ -30,6 +46,15 @@ type Meta struct {
 	Op   Action `xml:"__op" json:"__op"`
 	Ts   string `xml:"__ts" json:"__ts"`
 	UxTs string `xml:"__ux_ts" json:"__ux_ts"`
+	// The time and the sequence number of the outbox event of the record (the last one, if the events are compacted),
+	// empty - the record is not made of an event (e.g. a snapshot)
+	EventTs   string `xml:"__event_ts" json:"__event_ts"`
+	UxEventTs string `xml:"__ux_event_ts" json:"__ux_event_ts"`
+	Seq       string `xml:"__seq" json:"__seq"`
+	// The id of the transaction of the event (see dbms_transaction.local_transaction_id) and its commit SCN,
+	// which orders the transactions by the commit, empty - unknown (e.g. the events published before the upgrade)
+	TxId      string `xml:"__tx_id" json:"__tx_id"`
+	CommitScn string `xml:"__commit_scn" json:"__commit_scn"`
 }
 
 // Pk represents a primary single-part key with a string representation of the value.
@@ -53,9 +78,63 @@ func (r *Record) GetValue() ([]byte, error) {
 		return nil, err
 	}
 
+	if r.Payload != nil {
+		if len(r.Payload) == 0 {
+			return nil, nil
+		}
+		return r.Payload, nil
+	}
+
+	if r.Image == ImageFull || r.Image == ImageDiff {
+		return json.Marshal(r.envelope())
+	}
+
 	return json.Marshal(r.Fields)
 }
 
+// AsDeadLetter returns the record of the key to send to the dead letter topic with the reason in the __error header:
+// the fields are the key and the meta only, the value of the record may be the cause of the failure.
+func (r *Record) AsDeadLetter(reason string) *Record {
+	d := &Record{Meta: r.Meta, Error: reason}
+	d.Fields = map[string]string{
+		strings.ToLower(r.Pk.Name): r.Pk.Value,
+		"__pk_name":                r.Pk.Name,
+		"__pk_val":                 r.Pk.Value,
+		"__op":                     string(r.Op),
+		"__ts":                     r.Ts,
+		"__ux_ts":                  r.UxTs,
+	}
+	if r.Seq != "" || r.EventTs != "" {
+		d.SetEvent(r.Meta)
+	}
+	return d
+}
+
+// EventTime returns the time of the outbox event, zero if it is unknown.
+func (m *Meta) EventTime() time.Time {
+	ms, err := strconv.ParseInt(m.UxEventTs, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+// SetEvent sets the time, the sequence number and the transaction of the event of the record,
+// they are also added to the fields, so they are sent in the value of the row event.
+func (r *Record) SetEvent(event Meta) {
+	r.EventTs, r.UxEventTs, r.Seq = event.EventTs, event.UxEventTs, event.Seq
+	r.TxId, r.CommitScn = event.TxId, event.CommitScn
+
+	if r.Fields == nil {
+		r.Fields = make(map[string]string, 5)
+	}
+	r.Fields["__event_ts"] = event.EventTs
+	r.Fields["__ux_event_ts"] = event.UxEventTs
+	r.Fields["__seq"] = event.Seq
+	r.Fields["__tx_id"] = event.TxId
+	r.Fields["__commit_scn"] = event.CommitScn
+}
+
 func (p *Pk) Validate() error {
 	return validation.ValidateStruct(
 		p,
@@ -67,6 +146,6 @@ func (p *Pk) Validate() error {
 func (m *Meta) Validate() error {
 	return validation.ValidateStruct(
 		m,
-		validation.Field(&m.Op, validation.Required, validation.In(UPDATE, DELETE, CREATE)),
+		validation.Field(&m.Op, validation.Required, validation.In(UPDATE, DELETE, CREATE, READ)),
 	)
 }
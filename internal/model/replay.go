@@ -0,0 +1,52 @@
+package model
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+// ReplayWindow selects the processed outbox events of the group to re-deliver (replay):
+// by the time of the event [From, To) and optionally by the parts
+type ReplayWindow struct {
+	// From is the lower bound of the event time (inclusive), in the time zone of the database
+	From time.Time `json:"from"`
+	// To is the upper bound of the event time (exclusive), in the time zone of the database
+	To time.Time `json:"to"`
+	// Parts is the list of the parts, empty - all the parts
+	Parts []int `json:"parts,omitempty"`
+}
+
+// ReplayPosition is the position of the last replayed event (the time and the rowid of the event),
+// zero value - the start of the window
+type ReplayPosition struct {
+	Ts  string
+	Rid string
+}
+
+func (w *ReplayWindow) Validate() error {
+	if w.From.IsZero() || w.To.IsZero() {
+		return errors.New("from and to are required")
+	}
+
+	if !w.From.Before(w.To) {
+		return errors.New("from must be before to")
+	}
+
+	return validation.ValidateStruct(
+		w,
+		validation.Field(&w.Parts, validation.Each(validation.Min(0))),
+	)
+}
+
+// PartList returns the parts as a comma-separated list, empty - all the parts.
+func (w *ReplayWindow) PartList() string {
+	parts := make([]string, 0, len(w.Parts))
+	for _, p := range w.Parts {
+		parts = append(parts, strconv.Itoa(p))
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,22 @@
+package model
+
+import "time"
+
+// PartState is the state of the outbox events of the single part of the group for the consumer
+type PartState struct {
+	GroupId string
+	// Consumer is the named consumer of the group (see CONSUMER_POSITION table), empty - the unnamed consumer
+	// (the state of the events)
+	Consumer string
+	PartId   int
+	// New is the number of the events waiting for the relay
+	New int64
+	// Processed is the number of the relayed events kept in the outbox
+	Processed int64
+	// OldestNew is the time of the oldest waiting event (zero if there are none)
+	OldestNew time.Time
+	// LastProcessed is the time of the latest relayed event (zero if there are none)
+	LastProcessed time.Time
+	// Lag is the age of the oldest waiting event
+	Lag time.Duration
+}
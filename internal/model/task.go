@@ -1,12 +1,81 @@
 package model
 
 import (
+	"errors"
+	"regexp"
+
 	validation "github.com/go-ozzo/ozzo-validation"
 )
 
+var consumerRe = regexp.MustCompile(`^\w{1,30}$`)
+
+// MaxGroupIdLen is the maximum length of the group code (see EVENT_LOG table)
+const MaxGroupIdLen = 64
+
+// The modes of the task
+const (
+	// ModeRows relays the current state of the rows of the events read by the task query (default)
+	ModeRows = "rows"
+	// ModePayload relays the payload of the events provided by the application as-is, the query is not used
+	ModePayload = "payload"
+)
+
+// The compaction of the events of a batch
+const (
+	// CompactionLastWins sends the last event of the key (default)
+	CompactionLastWins = "last_wins"
+	// CompactionNone sends every event, the row of the key is read once for all of them
+	CompactionNone = "none"
+)
+
+// The policies of the orphaned update events: the events of the keys, which rows are not found by the task query
+// (e.g. the row is deleted bypassing the outbox, or it is filtered out by the query)
+const (
+	// OrphanIgnore skips the events (default)
+	OrphanIgnore = "ignore"
+	// OrphanLog skips the events with the warning
+	OrphanLog = "log"
+	// OrphanDelete sends the events as the deletes of the keys
+	OrphanDelete = "delete"
+	// OrphanDLQ sends the events to the dead letter topic (see Task.DLQTopic)
+	OrphanDLQ = "dlq"
+)
+
+// The handling of the poison records (undecodable or rejected by the broker), if the dead letter topic is not set
+// (otherwise they are sent to it)
+const (
+	// PoisonPark does not commit the batch, the task is parked until the data or the configuration is fixed (default)
+	PoisonPark = "park"
+	// PoisonSkip skips the poison records with the error, the rest of the batch is relayed
+	PoisonSkip = "skip"
+)
+
+// DefaultPayloadPkName is the name of the key of the payload events, unless the pk column is set
+const DefaultPayloadPkName = "key"
+
 // Task is used for the internal representation of the replication work unit
 type Task struct {
-	GroupId   string
+	GroupId string
+	// Consumer is the name of the consumer of the group, it tracks its own position in the outbox
+	// (empty - the events are marked as processed)
+	Consumer string
+	// Mode is ModeRows (empty) or ModePayload
+	Mode string
+	// BeforeImage is the format of the before image of the rows: ImageNone (empty), ImageFull or ImageDiff
+	BeforeImage string
+	// Ordered sends the records of the batch in order of the events, otherwise the updated rows go first
+	Ordered bool
+	// Compaction is CompactionLastWins (empty) or CompactionNone
+	Compaction string
+	// Orphans is the policy of the orphaned update events: OrphanIgnore (empty), OrphanLog, OrphanDelete or OrphanDLQ
+	Orphans string
+	// DLQTopic is the topic of the dead letters: the orphaned update events (see OrphanDLQ) and the poison records,
+	// empty - the poison records are handled by OnPoison
+	DLQTopic string
+	// OnPoison is the handling of the poison records without DLQTopic: PoisonPark (empty) or PoisonSkip
+	OnPoison string
+	// TxTopic is the topic of the transaction markers of the batches (see TxMarkers), empty - not sent
+	TxTopic   string
 	PartId    int
 	BatchSize int
 	Topic     string
@@ -20,13 +89,82 @@ type Query struct {
 }
 
 func (t *Task) Validate() error {
-	return validation.ValidateStruct(
-		t,
+	fields := []*validation.FieldRules{
 		validation.Field(&t.Topic, validation.Required),
 		validation.Field(&t.GroupId, validation.Required),
+		validation.Field(&t.Consumer, validation.Match(consumerRe)),
+		validation.Field(&t.Mode, validation.In(ModeRows, ModePayload)),
+		validation.Field(&t.BeforeImage, validation.In(ImageNone, ImageFull, ImageDiff)),
+		validation.Field(&t.Compaction, validation.In(CompactionLastWins, CompactionNone)),
+		validation.Field(&t.Orphans, validation.In(OrphanIgnore, OrphanLog, OrphanDelete, OrphanDLQ)),
+		validation.Field(&t.OnPoison, validation.In(PoisonPark, PoisonSkip)),
 		validation.Field(&t.BatchSize, validation.Required),
-		validation.Field(&t.Query),
-	)
+	}
+
+	if t.Orphans == OrphanDLQ {
+		fields = append(fields, validation.Field(&t.DLQTopic, validation.Required))
+	}
+
+	// The payload events are relayed as-is, the query is not used
+	if !t.IsPayload() {
+		fields = append(fields, validation.Field(&t.Query))
+	} else if t.HasBeforeImage() {
+		return errors.New("before image is not supported in payload mode")
+	}
+
+	return validation.ValidateStruct(t, fields...)
+}
+
+// SkipsPoison reports whether the poison records are skipped: they are not sent to the dead letter topic
+// and the task is not parked.
+func (t *Task) SkipsPoison() bool {
+	return t.DLQTopic == "" && t.OnPoison == PoisonSkip
+}
+
+// IsPayload reports whether the task relays the payload events.
+func (t *Task) IsPayload() bool {
+	return t.Mode == ModePayload
+}
+
+// HasBeforeImage reports whether the before images of the rows are sent.
+func (t *Task) HasBeforeImage() bool {
+	return t.BeforeImage == ImageFull || t.BeforeImage == ImageDiff
+}
+
+// IsCompacted reports whether the events of a key in a batch are compacted (the payload events are never compacted).
+func (t *Task) IsCompacted() bool {
+	return t.Compaction != CompactionNone
+}
+
+// PayloadPkName returns the name of the key of the payload events.
+func (t *Task) PayloadPkName() string {
+	if t.Query.PkColumn != "" {
+		return t.Query.PkColumn
+	}
+	return DefaultPayloadPkName
+}
+
+// ValidateGroup checks the group code and the number of the parts of the group,
+// the number of the parts must be equal to the number of the buckets used by the publisher.
+func ValidateGroup(groupId string, partCount int) error {
+	return validation.Errors{
+		"group_id":   validation.Validate(groupId, validation.Required, validation.Length(1, MaxGroupIdLen)),
+		"part_count": validation.Validate(partCount, validation.Required, validation.Min(1)),
+	}.Filter()
+}
+
+// Key returns the key of the group and the consumer: the group id of the unnamed consumer,
+// otherwise "<group_id>@<consumer>".
+func (t *Task) Key() string {
+	return GroupKey(t.GroupId, t.Consumer)
+}
+
+// GroupKey returns the key of the group and the consumer (see Task.Key).
+func GroupKey(groupId, consumer string) string {
+	if consumer == "" {
+		return groupId
+	}
+	return groupId + "@" + consumer
 }
 
 func (q *Query) Validate() error {
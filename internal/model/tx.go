@@ -0,0 +1,100 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// The statuses of the transaction markers
+const (
+	TxBegin = "BEGIN"
+	TxEnd   = "END"
+)
+
+// TxMarker is the boundary of the source transaction of the events sent in a batch. The END marker carries
+// the number of the events of the transaction in the outbox per group, so the consumers can apply the changes
+// of the transaction atomically when all of them are received (the events of the transaction may be split
+// across the batches and the parts, so the markers of the transaction may be repeated).
+type TxMarker struct {
+	Status     string         `json:"status"`
+	Id         string         `json:"id"`
+	CommitScn  string         `json:"commit_scn,omitempty"`
+	EventTs    string         `json:"event_ts,omitempty"`
+	EventCount int            `json:"event_count,omitempty"`
+	Groups     []TxGroupCount `json:"groups,omitempty"`
+}
+
+// TxGroupCount is the number of the events of the transaction of the group.
+type TxGroupCount struct {
+	GroupId    string `json:"group_id"`
+	EventCount int    `json:"event_count"`
+}
+
+// GetKey returns the key of the message of the marker, the id of the transaction.
+func (m *TxMarker) GetKey() []byte {
+	return []byte(m.Id)
+}
+
+// GetValue returns the value of the message of the marker.
+func (m *TxMarker) GetValue() ([]byte, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("tx marker %s error: %w", m.Id, err)
+	}
+	return b, nil
+}
+
+// TxRef is the transaction of the records, the ids are reused by Oracle, so it is identified
+// by the id and the commit SCN.
+type TxRef struct {
+	Id        string
+	CommitScn string
+}
+
+// TxRefs returns the transactions of the records in order of their first records.
+func TxRefs(records []*Record) []TxRef {
+	seen := make(map[TxRef]bool)
+	var result []TxRef
+	for _, v := range records {
+		ref := TxRef{Id: v.TxId, CommitScn: v.CommitScn}
+		if v.TxId != "" && !seen[ref] {
+			seen[ref] = true
+			result = append(result, ref)
+		}
+	}
+	return result
+}
+
+// TxMarkers returns the BEGIN and the END markers of the transactions of the records, the time of the BEGIN marker
+// is of the first record of the transaction, of the END one - of the last. The counts are of the events
+// of the transaction in the outbox per group.
+func TxMarkers(records []*Record, counts map[TxRef][]TxGroupCount) []TxMarker {
+	first := make(map[TxRef]*Record)
+	last := make(map[TxRef]*Record)
+	for _, v := range records {
+		if v.TxId == "" {
+			continue
+		}
+		ref := TxRef{Id: v.TxId, CommitScn: v.CommitScn}
+		if _, ok := first[ref]; !ok {
+			first[ref] = v
+		}
+		last[ref] = v
+	}
+
+	var result []TxMarker
+	for _, ref := range TxRefs(records) {
+		total := 0
+		for _, v := range counts[ref] {
+			total += v.EventCount
+		}
+
+		result = append(result,
+			TxMarker{Status: TxBegin, Id: ref.Id, CommitScn: ref.CommitScn, EventTs: first[ref].EventTs},
+			TxMarker{Status: TxEnd, Id: ref.Id, CommitScn: ref.CommitScn, EventTs: last[ref].EventTs,
+				EventCount: total, Groups: counts[ref]},
+		)
+	}
+
+	return result
+}
@@ -8,13 +8,22 @@ import (
 type (
 	Relayer interface {
 		Relay(context.Context, *model.Task) (uint16, error)
+		Snapshot(context.Context, *model.Task) (uint16, bool, error)
 	}
 
 	Repository interface {
 		GetRecords(context.Context, *model.Task) ([]*model.Record, error)
+		GetSnapshotRecords(context.Context, *model.Task) ([]*model.Record, bool, error)
+		GetBackfillRecords(context.Context, *model.Task, model.Filter, string) ([]*model.Record, string, bool, error)
+		GetReplayRecords(context.Context, *model.Task, model.ReplayWindow, model.ReplayPosition) ([]*model.Record, model.ReplayPosition, bool, error)
+		CountReplayEvents(context.Context, string, string, model.ReplayWindow) ([]model.PartState, error)
+		ResetReplayEvents(context.Context, string, model.ReplayWindow, model.ReplayPosition, int) (int, model.ReplayPosition, error)
+		RewindConsumer(context.Context, string, string, model.ReplayWindow) (int, error)
+		GetTxCounts(context.Context, []model.TxRef) (map[model.TxRef][]model.TxGroupCount, error)
 	}
 
 	Broker interface {
 		SendRecords(context.Context, string, []*model.Record) error
+		SendMarkers(context.Context, string, []model.TxMarker) error
 	}
 )
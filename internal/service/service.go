@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/eugene-vodyanko/orgonaut/internal/model"
+	"log/slog"
 )
 
 // RelayService is the main engine of the application
@@ -24,6 +25,12 @@ func New(sourceBroker Repository, tx Transactor, destBroker Broker) *RelayServic
 // if the function completes without errors. Otherwise, the transaction is rolled back.
 // Processing will not progress until the cause of the error is resolved.
 // Delivery guarantees can be understood as at least once.
+//
+// If the task has the transaction topic, the BEGIN markers of the transactions of the records are sent
+// to it before the records and the END markers after them (see model.TxMarkers).
+//
+// The errors keep the class set by the repository and the broker (see model.ClassOf):
+// the retriable ones are transient, the poison and fatal ones persist until the data or the configuration is fixed.
 func (s *RelayService) Relay(ctx context.Context, task *model.Task) (uint16, error) {
 
 	var amount int
@@ -35,15 +42,336 @@ func (s *RelayService) Relay(ctx context.Context, task *model.Task) (uint16, err
 
 		amount = len(items)
 
+		var begins, ends []model.TxMarker
+		if task.TxTopic != "" {
+			begins, ends, err = s.txMarkers(txCtx, items)
+			if err != nil {
+				return fmt.Errorf("service - get tx markers: %w", err)
+			}
+		}
+
+		if len(begins) > 0 {
+			err = s.dest.SendMarkers(ctx, task.TxTopic, begins)
+			if err != nil {
+				return fmt.Errorf("service - send tx begin markers: %w", err)
+			}
+		}
+
 		if amount > 0 {
-			err = s.dest.SendRecords(ctx, task.Topic, items)
+			err = s.sendRecords(ctx, task, items)
 			if err != nil {
 				return fmt.Errorf("service - send records: %w", err)
 			}
 		}
 
+		if len(ends) > 0 {
+			err = s.dest.SendMarkers(ctx, task.TxTopic, ends)
+			if err != nil {
+				return fmt.Errorf("service - send tx end markers: %w", err)
+			}
+		}
+
 		return nil
 	})
 
 	return uint16(amount), err
 }
+
+// Snapshot requests the next chunk of the snapshot (initial load) of the task query and sends it to the broker,
+// it returns the number of the sent records and whether the snapshot is complete.
+//
+// The position of the snapshot is advanced in the same transaction, so it is committed
+// only if the records are sent, and the snapshot is resumed after errors or restarts.
+func (s *RelayService) Snapshot(ctx context.Context, task *model.Task) (uint16, bool, error) {
+
+	var amount int
+	var done bool
+	err := s.tx.WithinTransaction(ctx, func(txCtx context.Context) error {
+		items, finished, err := s.source.GetSnapshotRecords(txCtx, task)
+		if err != nil {
+			return fmt.Errorf("service - get snapshot records error: %w", err)
+		}
+
+		amount, done = len(items), finished
+
+		if amount > 0 {
+			err = s.sendRecords(ctx, task, items)
+			if err != nil {
+				return fmt.Errorf("service - send snapshot records: %w", err)
+			}
+		}
+
+		return nil
+	})
+
+	return uint16(amount), done, err
+}
+
+// Backfill re-emits the current state of the rows of the task query matching the filter
+// (e.g. to repair the downstream state after the consumer bugs): the rows are read in chunks
+// of the task batch size and sent to the broker, the outbox is not used.
+// The progress is called after each chunk with the total number of the sent records.
+func (s *RelayService) Backfill(ctx context.Context, task *model.Task, filter model.Filter,
+	progress func(sent int)) (int, error) {
+
+	if task.IsPayload() {
+		return 0, fmt.Errorf("service - backfill is not supported in %q mode", model.ModePayload)
+	}
+
+	if err := filter.Validate(); err != nil {
+		return 0, fmt.Errorf("service - backfill filter error: %w", err)
+	}
+
+	var sent int
+	var lastPk string
+	for done := false; !done; {
+		err := s.tx.WithinTransaction(ctx, func(txCtx context.Context) error {
+			items, nextPk, finished, err := s.source.GetBackfillRecords(txCtx, task, filter, lastPk)
+			if err != nil {
+				return fmt.Errorf("service - get backfill records error: %w", err)
+			}
+
+			if len(items) > 0 {
+				err = s.sendRecords(ctx, task, items)
+				if err != nil {
+					return fmt.Errorf("service - send backfill records: %w", err)
+				}
+			}
+
+			sent += len(items)
+			lastPk, done = nextPk, finished
+			return nil
+		})
+		if err != nil {
+			return sent, err
+		}
+
+		if progress != nil {
+			progress(sent)
+		}
+	}
+
+	return sent, nil
+}
+
+// CountReplay returns the number of the events of the group relayed by the consumer (empty - the unnamed one)
+// in the window per part, it is used to estimate the replay before it is done (dry run).
+func (s *RelayService) CountReplay(ctx context.Context, groupId, consumer string,
+	w model.ReplayWindow) ([]model.PartState, error) {
+
+	if err := w.Validate(); err != nil {
+		return nil, fmt.Errorf("service - replay window error: %w", err)
+	}
+
+	parts, err := s.source.CountReplayEvents(ctx, groupId, consumer, w)
+	if err != nil {
+		return nil, fmt.Errorf("service - count replay events error: %w", err)
+	}
+
+	return parts, nil
+}
+
+// ResetReplay marks the processed events of the group in the window as new, so the tasks of the group
+// relay them again to the task topic. The events are reset in chunks of the batch size in order of the event time,
+// each chunk is committed separately and the next one starts after its last event, so the events relayed
+// by the tasks meanwhile are not reset twice. The progress is called after each chunk with the total number of the reset events.
+//
+// The state of the events is not used by the named consumer, so its position is moved back before the window instead
+// (see Repository.RewindConsumer), the events after the window are relayed again as well.
+func (s *RelayService) ResetReplay(ctx context.Context, groupId, consumer string, w model.ReplayWindow, batchSize int,
+	progress func(reset int)) (int, error) {
+
+	if err := w.Validate(); err != nil {
+		return 0, fmt.Errorf("service - replay window error: %w", err)
+	}
+
+	if consumer != "" {
+		var n int
+		err := s.tx.WithinTransaction(ctx, func(txCtx context.Context) error {
+			var err error
+			n, err = s.source.RewindConsumer(txCtx, groupId, consumer, w)
+			if err != nil {
+				return fmt.Errorf("service - rewind consumer error: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		if progress != nil {
+			progress(n)
+		}
+		return n, nil
+	}
+
+	var total int
+	var pos model.ReplayPosition
+	for done := false; !done; {
+		var n int
+		err := s.tx.WithinTransaction(ctx, func(txCtx context.Context) error {
+			var next model.ReplayPosition
+			var err error
+			n, next, err = s.source.ResetReplayEvents(txCtx, groupId, w, pos, batchSize)
+			if err != nil {
+				return fmt.Errorf("service - reset replay events error: %w", err)
+			}
+			pos, done = next, next == pos
+			return nil
+		})
+		if err != nil {
+			return total, err
+		}
+
+		total += n
+
+		if progress != nil {
+			progress(total)
+		}
+	}
+
+	return total, nil
+}
+
+// Replay re-delivers the events of the group relayed by the consumer of the task in the window to the topic
+// (the task topic or a different one), the state of the events and the positions are not changed, so the replay
+// does not interfere with the tasks of the group. The events are read in chunks of the task batch size in order of the event time,
+// the current state of the rows is sent as Relay does. The progress is called after each chunk
+// with the total number of the sent records.
+func (s *RelayService) Replay(ctx context.Context, task *model.Task, w model.ReplayWindow,
+	progress func(sent int)) (int, error) {
+
+	if task.IsPayload() {
+		return 0, fmt.Errorf("service - replay is not supported in %q mode", model.ModePayload)
+	}
+
+	if err := w.Validate(); err != nil {
+		return 0, fmt.Errorf("service - replay window error: %w", err)
+	}
+
+	var sent int
+	var pos model.ReplayPosition
+	for done := false; !done; {
+		err := s.tx.WithinTransaction(ctx, func(txCtx context.Context) error {
+			items, next, finished, err := s.source.GetReplayRecords(txCtx, task, w, pos)
+			if err != nil {
+				return fmt.Errorf("service - get replay records error: %w", err)
+			}
+
+			if len(items) > 0 {
+				err = s.sendRecords(ctx, task, items)
+				if err != nil {
+					return fmt.Errorf("service - send replay records: %w", err)
+				}
+			}
+
+			sent += len(items)
+			pos, done = next, finished
+			return nil
+		})
+		if err != nil {
+			return sent, err
+		}
+
+		if progress != nil {
+			progress(sent)
+		}
+	}
+
+	return sent, nil
+}
+
+// sendRecords sends the records to the topic of the task, the dead letters (the orphaned update events,
+// the poison records) to the dead letter topic of the task.
+//
+// The batch rejected as poison is sent record by record to find the poison records,
+// so a single bad record does not stop the task. If the dead letter topic is not set, the poison records
+// fail the batch as fatal (so it is not committed and the task is parked), unless they are skipped on demand
+// (see model.Task.SkipsPoison).
+func (s *RelayService) sendRecords(ctx context.Context, task *model.Task, records []*model.Record) error {
+	var items, letters []*model.Record
+	for _, v := range records {
+		if v.Error != "" {
+			letters = append(letters, v)
+		} else {
+			items = append(items, v)
+		}
+	}
+
+	deadLetters := task.DLQTopic != "" || task.SkipsPoison()
+
+	if len(letters) > 0 && !deadLetters {
+		return model.NewClassError(model.Fatal,
+			fmt.Errorf("poison record of key %v: %s (dlq_topic is not set)", letters[0].Pk.Value, letters[0].Error))
+	}
+
+	if len(items) > 0 {
+		err := s.dest.SendRecords(ctx, task.Topic, items)
+		if model.ClassOf(err) == model.Poison {
+			if !deadLetters {
+				return model.NewClassError(model.Fatal, fmt.Errorf("poison batch (dlq_topic is not set): %w", err))
+			}
+
+			for _, v := range items {
+				if err = s.dest.SendRecords(ctx, task.Topic, []*model.Record{v}); err != nil {
+					if model.ClassOf(err) != model.Poison {
+						return err
+					}
+					letters = append(letters, v.AsDeadLetter(err.Error()))
+				}
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if len(letters) == 0 {
+		return nil
+	}
+
+	if task.SkipsPoison() {
+		for _, v := range letters {
+			slog.Error("service - poison record is skipped (on_poison: skip)",
+				"group", task.GroupId, "part", task.PartId, "key", v.Pk.Value, "error", v.Error)
+		}
+		return nil
+	}
+
+	dlq := make([]*model.Record, 0, len(letters))
+	for _, v := range letters {
+		if v.Payload != nil || v.Before != nil {
+			v = v.AsDeadLetter(v.Error)
+		}
+		dlq = append(dlq, v)
+	}
+
+	if err := s.dest.SendRecords(ctx, task.DLQTopic, dlq); err != nil {
+		return fmt.Errorf("dlq: %w", err)
+	}
+
+	return nil
+}
+
+// txMarkers returns the BEGIN and the END markers of the transactions of the records,
+// the counts of the events are read within the transaction of txCtx.
+func (s *RelayService) txMarkers(txCtx context.Context, records []*model.Record) (begins, ends []model.TxMarker, err error) {
+	txs := model.TxRefs(records)
+	if len(txs) == 0 {
+		return nil, nil, nil
+	}
+
+	counts, err := s.source.GetTxCounts(txCtx, txs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, v := range model.TxMarkers(records, counts) {
+		if v.Status == model.TxBegin {
+			begins = append(begins, v)
+		} else {
+			ends = append(ends, v)
+		}
+	}
+
+	return begins, ends, nil
+}
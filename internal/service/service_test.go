@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/eugene-vodyanko/orgonaut/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// repositoryStub serves the records of a single batch.
+type repositoryStub struct {
+	Repository
+	records []*model.Record
+}
+
+func (r *repositoryStub) GetRecords(context.Context, *model.Task) ([]*model.Record, error) {
+	return r.records, nil
+}
+
+// brokerStub rejects the records of the poison keys sent to the topic as poison (the dead letters are accepted)
+// and keeps the sent records by the topic.
+type brokerStub struct {
+	topic  string
+	poison map[string]bool
+	sent   map[string][]*model.Record
+}
+
+func (b *brokerStub) SendRecords(_ context.Context, topic string, records []*model.Record) error {
+	for _, v := range records {
+		if topic == b.topic && b.poison[v.Pk.Value] {
+			return model.NewClassError(model.Poison, errors.New("message too large"))
+		}
+	}
+	b.sent[topic] = append(b.sent[topic], records...)
+	return nil
+}
+
+func (b *brokerStub) SendMarkers(context.Context, string, []model.TxMarker) error {
+	return nil
+}
+
+// transactorStub counts the committed transactions.
+type transactorStub struct {
+	commits int
+}
+
+func (t *transactorStub) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := fn(ctx); err != nil {
+		return err
+	}
+	t.commits++
+	return nil
+}
+
+func TestRelayService_RelayPoison(t *testing.T) {
+	records := func() []*model.Record {
+		return []*model.Record{
+			{Meta: model.Meta{Pk: model.Pk{Name: "id", Value: "1"}, Op: model.UPDATE}},
+			{Meta: model.Meta{Pk: model.Pk{Name: "id", Value: "2"}, Op: model.UPDATE}},
+			{Meta: model.Meta{Pk: model.Pk{Name: "id", Value: "3"}, Op: model.UPDATE}, Error: "bad before image"},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		task    model.Task
+		fatal   bool
+		sent    []string
+		letters []string
+	}{
+		{
+			name:  "no dlq",
+			task:  model.Task{Topic: "topic"},
+			fatal: true,
+		},
+		{
+			name:    "dlq",
+			task:    model.Task{Topic: "topic", DLQTopic: "dlq"},
+			sent:    []string{"1"},
+			letters: []string{"3", "2"},
+		},
+		{
+			name: "skip",
+			task: model.Task{Topic: "topic", OnPoison: model.PoisonSkip},
+			sent: []string{"1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := &transactorStub{}
+			broker := &brokerStub{topic: "topic", poison: map[string]bool{"2": true}, sent: make(map[string][]*model.Record)}
+			s := New(&repositoryStub{records: records()}, tx, broker)
+
+			_, err := s.Relay(context.Background(), &tt.task)
+
+			if tt.fatal {
+				require.Error(t, err)
+				assert.Equal(t, model.Fatal, model.ClassOf(err))
+				// The batch is not committed, so the poison records are relayed again after the task is resumed
+				assert.Equal(t, 0, tx.commits)
+				assert.Empty(t, broker.sent)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, 1, tx.commits)
+			assert.Equal(t, tt.sent, keys(broker.sent["topic"]))
+			assert.Equal(t, tt.letters, keys(broker.sent["dlq"]))
+		})
+	}
+}
+
+func keys(records []*model.Record) []string {
+	var v []string
+	for _, r := range records {
+		v = append(v, r.Pk.Value)
+	}
+	return v
+}
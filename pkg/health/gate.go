@@ -0,0 +1,138 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const _defaultProbeInterval = 1000 * time.Millisecond
+
+// ProbeFunc checks the availability of a resource, it returns nil if the resource is available.
+type ProbeFunc func(ctx context.Context) error
+
+// Gate is a shared health state of a resource (e.g. a sink) used by many workers.
+//
+// Once a worker reports the resource is unavailable (see Trip), the gate is closed and the workers
+// are expected to pause their work (see Ready) instead of hammering the resource independently.
+// While the gate is closed, a lightweight probe is called periodically,
+// after the first successful probe the gate is opened and the recovery callbacks are called.
+type Gate struct {
+	name     string
+	probe    ProbeFunc
+	interval time.Duration
+
+	mu        sync.RWMutex
+	ready     bool
+	lastErr   error
+	onRecover []func()
+	tripped   chan struct{}
+}
+
+// NewGate creates an open gate of the resource with the specified name.
+// The probe is called with the interval while the gate is closed (see Run).
+func NewGate(name string, probe ProbeFunc, interval time.Duration) *Gate {
+	if interval <= 0 {
+		interval = _defaultProbeInterval
+	}
+
+	return &Gate{
+		name:     "gate:" + name,
+		probe:    probe,
+		interval: interval,
+		ready:    true,
+		tripped:  make(chan struct{}, 1),
+	}
+}
+
+// OnRecover adds the callback called after the gate is opened again.
+// It must be called before Run.
+func (g *Gate) OnRecover(f func()) {
+	g.onRecover = append(g.onRecover, f)
+}
+
+// Ready reports whether the resource is considered available.
+func (g *Gate) Ready() bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return g.ready
+}
+
+// Err returns the error that closed the gate, or nil if the gate is open.
+func (g *Gate) Err() error {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return g.lastErr
+}
+
+// Trip closes the gate because of the error until the probe succeeds.
+func (g *Gate) Trip(err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.ready {
+		return
+	}
+
+	g.ready = false
+	g.lastErr = err
+
+	slog.Warn(fmt.Sprintf("%s - resource is unavailable, close", g.name), "err", err)
+
+	select {
+	case g.tripped <- struct{}{}:
+	default:
+	}
+}
+
+// Run probes the resource while the gate is closed, until the context is cancelled.
+func (g *Gate) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-g.tripped:
+			if !g.waitProbe(ctx) {
+				return
+			}
+
+			g.mu.Lock()
+			g.ready = true
+			g.lastErr = nil
+			g.mu.Unlock()
+
+			slog.Info(fmt.Sprintf("%s - resource is available, open", g.name))
+
+			for _, f := range g.onRecover {
+				f()
+			}
+		}
+	}
+}
+
+// waitProbe calls the probe until it succeeds, it returns false if the context is cancelled.
+func (g *Gate) waitProbe(ctx context.Context) bool {
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+			probeCtx, cancel := context.WithTimeout(ctx, g.interval)
+			err := g.probe(probeCtx)
+			cancel()
+
+			if err == nil {
+				return true
+			}
+
+			slog.Debug(fmt.Sprintf("%s - probe error", g.name), "err", err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package health_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eugene-vodyanko/orgonaut/pkg/health"
+)
+
+func TestGate_TripAndRecover(t *testing.T) {
+	var available atomic.Bool
+
+	probe := func(ctx context.Context) error {
+		if !available.Load() {
+			return errors.New("unavailable")
+		}
+		return nil
+	}
+
+	recovered := make(chan struct{}, 1)
+
+	g := health.NewGate("test", probe, 10*time.Millisecond)
+	g.OnRecover(func() {
+		recovered <- struct{}{}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go g.Run(ctx)
+
+	if !g.Ready() {
+		t.Fatal("gate must be open initially")
+	}
+
+	g.Trip(errors.New("connection refused"))
+
+	if g.Ready() || g.Err() == nil {
+		t.Fatal("gate must be closed after trip")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if g.Ready() {
+		t.Fatal("gate must be closed while the probe fails")
+	}
+
+	available.Store(true)
+
+	select {
+	case <-recovered:
+	case <-time.After(time.Second):
+		t.Fatal("gate has not been recovered")
+	}
+
+	if !g.Ready() || g.Err() != nil {
+		t.Fatal("gate must be open after recovery")
+	}
+}
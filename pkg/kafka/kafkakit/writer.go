@@ -1,7 +1,9 @@
 package kafkakit
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/segmentio/kafka-go"
@@ -69,3 +71,59 @@ func NewWriter(brokers []string, topic string, compress bool, batchSize int, bat
 		topic:   topic,
 	}, nil
 }
+
+// Ping checks the availability of the cluster: it connects to the first available broker
+// and requests the list of the cluster brokers.
+func (w *Writer) Ping(ctx context.Context) error {
+	var errs []error
+	for _, broker := range w.brokers {
+		conn, err := kafka.DialContext(ctx, "tcp", broker)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		_, err = conn.Brokers()
+		_ = conn.Close()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("no available brokers: %w", errors.Join(errs...))
+}
+
+// Topics returns the names of the topics existing in the cluster.
+func (w *Writer) Topics(ctx context.Context) ([]string, error) {
+	var errs []error
+	for _, broker := range w.brokers {
+		conn, err := kafka.DialContext(ctx, "tcp", broker)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		partitions, err := conn.ReadPartitions()
+		_ = conn.Close()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		seen := make(map[string]bool)
+		var topics []string
+		for _, p := range partitions {
+			if !seen[p.Topic] {
+				seen[p.Topic] = true
+				topics = append(topics, p.Topic)
+			}
+		}
+
+		return topics, nil
+	}
+
+	return nil, fmt.Errorf("no available brokers: %w", errors.Join(errs...))
+}
@@ -0,0 +1,187 @@
+// Package outbox publishes the change events of the application into the outbox of Orgonaut
+// (see org$outbox_api) within the transaction of the application, so the events are committed
+// or rolled back together with the changes.
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/eugene-vodyanko/orgonaut/internal/model"
+	ora "github.com/sijms/go-ora/v2"
+)
+
+// Action is the type of the change, it is shared with the relay (see model.Action).
+type Action = model.Action
+
+// The actions of the events
+const (
+	Insert = model.CREATE
+	Update = model.UPDATE
+	Delete = model.DELETE
+)
+
+// Event is the change of the row with the numeric primary key.
+type Event struct {
+	Action Action
+	Key    int64
+}
+
+// Publisher publishes the events into the outbox of the schema of Orgonaut.
+//
+// The group and the number of the buckets must match the group_id and the part_count
+// of the task relaying the group, otherwise some parts are never relayed.
+type Publisher struct {
+	schema string
+}
+
+func NewPublisher(schema string) *Publisher {
+	return &Publisher{schema: schema}
+}
+
+// PublishInsert publishes the insert event of the row with the key within the transaction.
+func (p *Publisher) PublishInsert(ctx context.Context, tx *sql.Tx, group string, key int64, bucketCount int) error {
+	return p.publish(ctx, tx, "putInsertEvent", group, key, bucketCount)
+}
+
+// PublishUpdate publishes the update event of the row with the key within the transaction.
+func (p *Publisher) PublishUpdate(ctx context.Context, tx *sql.Tx, group string, key int64, bucketCount int) error {
+	return p.publish(ctx, tx, "putUpdateEvent", group, key, bucketCount)
+}
+
+// PublishDelete publishes the delete event of the row with the key within the transaction.
+func (p *Publisher) PublishDelete(ctx context.Context, tx *sql.Tx, group string, key int64, bucketCount int) error {
+	return p.publish(ctx, tx, "putDeleteEvent", group, key, bucketCount)
+}
+
+// PublishPayload publishes the payload event within the transaction (see org$outbox_api.putPayloadEvent):
+// the payload and the headers are relayed as-is by the task in "payload" mode, the key is used
+// as the key of the message and for the sharding. The nil payload is relayed as the message without a value.
+func (p *Publisher) PublishPayload(ctx context.Context, tx *sql.Tx, group string, action Action, key int64,
+	payload []byte, headers map[string]string, bucketCount int) error {
+
+	if err := model.ValidateGroup(group, bucketCount); err != nil {
+		return fmt.Errorf("outbox - validation error: %w", err)
+	}
+
+	if err := validateAction(action); err != nil {
+		return fmt.Errorf("outbox - validation error: %w", err)
+	}
+
+	var hdrs any
+	if len(headers) > 0 {
+		b, err := json.Marshal(headers)
+		if err != nil {
+			return fmt.Errorf("outbox - headers error: %w", err)
+		}
+		hdrs = string(b)
+	}
+
+	if tx == nil {
+		return errors.New("outbox - transaction is required")
+	}
+
+	query := "begin " +
+		p.schema +
+		".org$outbox_api.putPayloadEvent(" +
+		"  p_group_id => :1" +
+		", p_key_n => :2" +
+		", p_payload => :3" +
+		", p_bucket_count => :4" +
+		", p_action => :5" +
+		", p_headers => :6" +
+		"); " +
+		"end;"
+
+	_, err := tx.ExecContext(ctx, query,
+		group,
+		key,
+		ora.Clob{String: string(payload), Valid: payload != nil},
+		bucketCount,
+		string(action),
+		hdrs,
+	)
+	if err != nil {
+		return fmt.Errorf("outbox - publish payload event error: %w", err)
+	}
+
+	return nil
+}
+
+// PublishMany publishes the events in a single round trip within the transaction,
+// the keys and the actions are passed as arrays (see org$outbox_api.putEvents).
+func (p *Publisher) PublishMany(ctx context.Context, tx *sql.Tx, group string, events []Event, bucketCount int) error {
+	if err := model.ValidateGroup(group, bucketCount); err != nil {
+		return fmt.Errorf("outbox - validation error: %w", err)
+	}
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	keys := make([]int64, len(events))
+	actions := make([]string, len(events))
+	for i, v := range events {
+		if err := validateAction(v.Action); err != nil {
+			return fmt.Errorf("outbox - event[%d] validation error: %w", i, err)
+		}
+		keys[i], actions[i] = v.Key, string(v.Action)
+	}
+
+	if tx == nil {
+		return errors.New("outbox - transaction is required")
+	}
+
+	query := "begin " +
+		p.schema +
+		".org$outbox_api.putEvents(" +
+		"  p_group_id => :1" +
+		", p_keys => :2" +
+		", p_actions => :3" +
+		", p_bucket_count => :4" +
+		"); " +
+		"end;"
+
+	_, err := tx.ExecContext(ctx, query, group, keys, actions, bucketCount)
+	if err != nil {
+		return fmt.Errorf("outbox - publish events error: %w", err)
+	}
+
+	return nil
+}
+
+func (p *Publisher) publish(ctx context.Context, tx *sql.Tx, proc, group string, key int64, bucketCount int) error {
+	if err := model.ValidateGroup(group, bucketCount); err != nil {
+		return fmt.Errorf("outbox - validation error: %w", err)
+	}
+
+	if tx == nil {
+		return errors.New("outbox - transaction is required")
+	}
+
+	query := "begin " +
+		p.schema +
+		".org$outbox_api." + proc + "(" +
+		"  p_group_id => :1" +
+		", p_key_n => :2" +
+		", p_bucket_count => :3" +
+		"); " +
+		"end;"
+
+	_, err := tx.ExecContext(ctx, query, group, key, bucketCount)
+	if err != nil {
+		return fmt.Errorf("outbox - publish event error: %w", err)
+	}
+
+	return nil
+}
+
+func validateAction(a Action) error {
+	switch a {
+	case Insert, Update, Delete:
+		return nil
+	}
+	return fmt.Errorf("unsupported action: %q", a)
+}
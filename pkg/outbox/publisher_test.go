@@ -0,0 +1,30 @@
+package outbox
+
+import (
+	"context"
+	"github.com/eugene-vodyanko/orgonaut/internal/model"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestPublisher_validation(t *testing.T) {
+	p := NewPublisher("orgon")
+	ctx := context.Background()
+
+	// The settings are checked before the database is called
+	assert.ErrorContains(t, p.PublishUpdate(ctx, nil, "", 1, 42), "group_id")
+	assert.ErrorContains(t, p.PublishInsert(ctx, nil, strings.Repeat("g", model.MaxGroupIdLen+1), 1, 42), "group_id")
+	assert.ErrorContains(t, p.PublishDelete(ctx, nil, "group_1", 1, 0), "part_count")
+	assert.ErrorContains(t, p.PublishUpdate(ctx, nil, "group_1", 1, 42), "transaction is required")
+
+	assert.ErrorContains(t, p.PublishPayload(ctx, nil, "orders", model.READ, 1, []byte("{}"), nil, 42), "action")
+	assert.ErrorContains(t, p.PublishPayload(ctx, nil, "orders", Insert, 1, []byte("{}"),
+		map[string]string{"type": "OrderPlaced"}, 42), "transaction is required")
+
+	assert.NoError(t, p.PublishMany(ctx, nil, "group_1", nil, 42))
+	assert.ErrorContains(t, p.PublishMany(ctx, nil, "group_1", []Event{{Update, 1}, {model.READ, 2}}, 42),
+		"event[1]")
+	assert.ErrorContains(t, p.PublishMany(ctx, nil, "group_1", []Event{{Insert, 1}, {Delete, 2}}, 42),
+		"transaction is required")
+}
@@ -0,0 +1,170 @@
+package runner
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+// State is the state of the task circuit breaker.
+type State int
+
+const (
+	// StateClosed is the normal state: the handler is called according to the poll policy.
+	StateClosed State = iota
+	// StateOpen means the handler has failed too many times in a row and is not called until the open interval passes.
+	StateOpen
+	// StateHalfOpen means the open interval has passed and the next handler call is a trial one.
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("state(%d)", int(s))
+}
+
+// ErrorPolicy describes the retry schedule of a task after handler errors.
+// The intervals are set in milliseconds, zero values are replaced with the poll policy values of the runner.
+type ErrorPolicy struct {
+	InitialInterval    int
+	MaxInterval        int
+	BackoffCoefficient int
+	// Jitter is the fraction (from 0 to 1) of the interval by which it is randomly spread.
+	Jitter float64
+	// FailureThreshold is the number of consecutive failures that opens the circuit breaker.
+	// If zero, the circuit breaker is disabled.
+	FailureThreshold int
+	// OpenInterval is the time the circuit breaker stays open before a trial call.
+	// If zero, MaxInterval is used.
+	OpenInterval int
+}
+
+// Hooks are called by the task goroutines, so they must be safe for concurrent use and must not block.
+// Any of the hooks can be nil.
+type Hooks struct {
+	// OnError is called on each handler error with the number of consecutive failures (except the fatal ones).
+	OnError func(tag string, err error, failures int)
+	// OnPark is called when the task is parked by the fatal handler error (see ErrFatal).
+	OnPark func(tag string, err error)
+	// OnSuccess is called on each handler call without error, processed is the result of the handler.
+	OnSuccess func(tag string, processed bool)
+	// OnStateChange is called on each transition of the task circuit breaker.
+	OnStateChange func(tag string, from, to State)
+}
+
+// breaker tracks the consecutive failures of a single task.
+type breaker struct {
+	name     string
+	tag      string
+	policy   ErrorPolicy
+	hooks    Hooks
+	state    State
+	failures int
+	interval int
+	openedAt time.Time
+}
+
+func newBreaker(name, tag string, policy ErrorPolicy, hooks Hooks) *breaker {
+	return &breaker{
+		name:   name,
+		tag:    tag,
+		policy: policy,
+		hooks:  hooks,
+	}
+}
+
+// allow reports whether the handler can be called, otherwise it returns the time to wait (in milliseconds).
+func (b *breaker) allow() (bool, int) {
+	if b.state != StateOpen {
+		return true, 0
+	}
+
+	remaining := b.policy.OpenInterval - int(time.Since(b.openedAt).Milliseconds())
+	if remaining > 0 {
+		return false, remaining
+	}
+
+	b.setState(StateHalfOpen)
+	return true, 0
+}
+
+// onFailure registers the handler error and returns the time to wait (in milliseconds) before the retry.
+func (b *breaker) onFailure(err error) int {
+	b.failures++
+
+	if b.hooks.OnError != nil {
+		b.hooks.OnError(b.tag, err, b.failures)
+	}
+
+	if b.state == StateHalfOpen ||
+		(b.policy.FailureThreshold > 0 && b.failures >= b.policy.FailureThreshold && b.state == StateClosed) {
+		slog.Error(fmt.Sprintf("%s[%s] - call handler error, open circuit", b.name, b.tag),
+			"err", err, "failures", b.failures, "open_interval", b.policy.OpenInterval)
+
+		b.openedAt = time.Now()
+		b.setState(StateOpen)
+
+		return b.policy.OpenInterval
+	}
+
+	if b.interval == 0 {
+		b.interval = b.policy.InitialInterval
+	} else {
+		b.interval = min(b.interval*b.policy.BackoffCoefficient, b.policy.MaxInterval)
+	}
+
+	// Only the first failure in a row is reported as an error to avoid flooding the log
+	if b.failures == 1 {
+		slog.Error(fmt.Sprintf("%s[%s] - call handler error", b.name, b.tag), "err", err)
+	} else {
+		slog.Debug(fmt.Sprintf("%s[%s] - call handler error", b.name, b.tag), "err", err, "failures", b.failures)
+	}
+
+	return jitter(b.interval, b.policy.Jitter)
+}
+
+// onSuccess registers the handler call without error and resets the failures.
+func (b *breaker) onSuccess(processed bool) {
+	if b.failures > 0 {
+		slog.Info(fmt.Sprintf("%s[%s] - call handler recovered", b.name, b.tag), "failures", b.failures)
+	}
+
+	b.failures = 0
+	b.interval = 0
+
+	if b.state != StateClosed {
+		b.setState(StateClosed)
+	}
+
+	if b.hooks.OnSuccess != nil {
+		b.hooks.OnSuccess(b.tag, processed)
+	}
+}
+
+func (b *breaker) setState(state State) {
+	from := b.state
+	b.state = state
+
+	slog.Debug(fmt.Sprintf("%s[%s] - circuit state changed", b.name, b.tag), "from", from, "to", state)
+
+	if b.hooks.OnStateChange != nil {
+		b.hooks.OnStateChange(b.tag, from, state)
+	}
+}
+
+func jitter(interval int, fraction float64) int {
+	if fraction <= 0 || interval <= 0 {
+		return interval
+	}
+
+	spread := float64(interval) * min(fraction, 1)
+	return interval + int(spread*(2*rand.Float64()-1))
+}
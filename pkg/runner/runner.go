@@ -2,8 +2,11 @@ package runner
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
@@ -15,22 +18,35 @@ const (
 	_defaultMaxWorkers         = 1
 )
 
-type TaskHandler func(ctx context.Context) (bool, error)
-type Task struct {
-	Tag     string
-	Handler TaskHandler
-}
 type Runner struct {
 	name               string
 	initialInterval    int
 	maxInterval        int
 	backoffCoefficient int
 	maxWorkers         int
-	tasks              []Task
+	handlerTimeout     time.Duration
+	gracePeriod        time.Duration
+	errorPolicy        ErrorPolicy
+	hooks              Hooks
+
+	wg            sync.WaitGroup
+	sched         *scheduler
+	cancel        context.CancelFunc
+	handlerCancel context.CancelFunc
 
-	wg     *sync.WaitGroup
-	sema   chan struct{}
-	cancel context.CancelFunc
+	mu         sync.Mutex
+	entries    map[string]*entry
+	ctx        context.Context
+	handlerCtx context.Context
+}
+
+// UnfinishedError is returned by Stop if some tasks have not finished in time.
+type UnfinishedError struct {
+	Tags []string
+}
+
+func (e *UnfinishedError) Error() string {
+	return fmt.Sprintf("tasks have not finished: %s", strings.Join(e.Tags, ", "))
 }
 
 // NewRunner creates an instance of the task executor.
@@ -59,22 +75,81 @@ func NewRunner(label string, initialInterval, maxInterval, backoffCoefficient, m
 		maxWorkers = _defaultMaxWorkers
 	}
 
-	return &Runner{
+	r := &Runner{
 		name:               name,
 		initialInterval:    initialInterval,
 		maxInterval:        maxInterval,
 		backoffCoefficient: backoffCoefficient,
 		maxWorkers:         maxWorkers,
-		tasks:              tasks,
-		sema:               make(chan struct{}, maxWorkers),
+		sched:              newScheduler(maxWorkers),
+		entries:            make(map[string]*entry, len(tasks)),
+		errorPolicy: ErrorPolicy{
+			InitialInterval:    initialInterval,
+			MaxInterval:        maxInterval,
+			BackoffCoefficient: backoffCoefficient,
+			OpenInterval:       maxInterval,
+		},
+	}
+
+	for _, v := range tasks {
+		if err := r.AddTask(v); err != nil {
+			slog.Warn(fmt.Sprintf("%s - add task error", name), "task", v.Tag, "err", err)
+		}
 	}
+
+	return r
+}
+
+// SetErrorPolicy sets the retry schedule and the circuit breaker of the tasks after handler errors.
+// By default, the errors are retried according to the poll policy and the circuit breaker is disabled.
+// It must be called before RunTasks.
+func (r *Runner) SetErrorPolicy(p ErrorPolicy) {
+	if p.InitialInterval <= 0 {
+		p.InitialInterval = r.initialInterval
+	}
+
+	if p.MaxInterval <= 0 {
+		p.MaxInterval = r.maxInterval
+	}
+
+	if p.BackoffCoefficient <= 0 {
+		p.BackoffCoefficient = r.backoffCoefficient
+	}
+
+	if p.OpenInterval <= 0 {
+		p.OpenInterval = p.MaxInterval
+	}
+
+	r.errorPolicy = p
+}
+
+// SetHooks sets the callbacks notified about the results of the handler calls,
+// e.g. to emit metrics or alerts. It must be called before RunTasks.
+func (r *Runner) SetHooks(h Hooks) {
+	r.hooks = h
+}
+
+// SetHandlerTimeout sets the default duration limit of a single handler call
+// for the tasks without their own timeout. If zero, the duration is not limited.
+// It must be called before RunTasks.
+func (r *Runner) SetHandlerTimeout(d time.Duration) {
+	r.handlerTimeout = d
+}
+
+// SetGracePeriod sets the time given to the running handlers to finish after Stop is called,
+// then the context of the handlers is cancelled. If zero, the handlers are cancelled
+// only when the context passed to Stop is done. It must be called before RunTasks.
+func (r *Runner) SetGracePeriod(d time.Duration) {
+	r.gracePeriod = d
 }
 
 // RunTasks run each task in a separate goroutine and returns control.
 // To control the degree of parallelism with a large number of tasks,
-// a semaphore of the size "maxWorkers" is used.
+// the number of simultaneously running handlers is limited by "maxWorkers".
+// The worker slots are distributed between the task groups by their priorities and weights.
 // Each task is started immediately if the handler returns true,
-// and is sent to wait (with increasing interval) if the handler returns false
+// and is sent to wait (with increasing interval) if the handler returns false.
+// The handlers get a context derived from ctx, which is cancelled on shutdown (see Stop).
 func (r *Runner) RunTasks(ctx context.Context) error {
 
 	slog.Info(fmt.Sprintf("%s - run tasks", r.name),
@@ -82,31 +157,208 @@ func (r *Runner) RunTasks(ctx context.Context) error {
 		"max_interval", r.maxInterval,
 		"backoff_coefficient", r.backoffCoefficient,
 		"max_workers", r.maxWorkers,
+		"handler_timeout", r.handlerTimeout,
+		"grace_period", r.gracePeriod,
+		"error_policy", r.errorPolicy,
 	)
 
+	handlerCtx, handlerCancel := context.WithCancel(ctx)
 	ctx, cancel := context.WithCancel(ctx)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.handlerCancel = handlerCancel
 	r.cancel = cancel
+	r.handlerCtx = handlerCtx
+	r.ctx = ctx
+
+	for _, e := range r.entries {
+		r.start(e)
+	}
+
+	return nil
+}
 
-	var wg sync.WaitGroup
-	r.wg = &wg
+// AddTask registers the task, if the runner is already running, the task is started immediately.
+// It returns ErrTaskExists if a task with the same tag is already registered.
+func (r *Runner) AddTask(task Task) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.entries[task.Tag]; ok {
+		return fmt.Errorf("%s - add task[%s]: %w", r.name, task.Tag, ErrTaskExists)
+	}
 
-	for _, v := range r.tasks {
-		v := v
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			r.runTask(ctx, v.Tag, v.Handler)
+	e := newEntry(task)
+	r.entries[task.Tag] = e
+	r.sched.add(task)
 
-		}()
+	if r.ctx != nil {
+		r.start(e)
 	}
 
 	return nil
 }
 
-func (r *Runner) runTask(ctx context.Context, tag string, handler TaskHandler) {
+// RemoveTask stops the task and unregisters it. If the handler of the task is being called,
+// RemoveTask waits for it to finish. It returns ErrTaskNotFound if the task is not registered.
+func (r *Runner) RemoveTask(tag string) error {
+	r.mu.Lock()
+	e, ok := r.entries[tag]
+	if ok {
+		delete(r.entries, tag)
+	}
+	r.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%s - remove task[%s]: %w", r.name, tag, ErrTaskNotFound)
+	}
+
+	if e.cancel != nil {
+		e.cancel()
+		<-e.done
+	}
+
+	// The handler is finished, so the task does not wait for a slot
+	r.sched.remove(e.task)
+
+	slog.Info(fmt.Sprintf("%s - task removed", r.name), "task", tag)
+
+	return nil
+}
+
+// Tasks returns the status of the registered tasks ordered by the tag.
+func (r *Runner) Tasks() []TaskStatus {
+	r.mu.Lock()
+	entries := make([]*entry, 0, len(r.entries))
+	for _, e := range r.entries {
+		entries = append(entries, e)
+	}
+	r.mu.Unlock()
+
+	result := make([]TaskStatus, 0, len(entries))
+	for _, e := range entries {
+		result = append(result, e.status())
+	}
+
+	slices.SortFunc(result, func(a, b TaskStatus) int {
+		return strings.Compare(a.Tag, b.Tag)
+	})
+
+	return result
+}
+
+// Pause suspends the handler calls of the task until Resume, the running call is not interrupted.
+// It returns ErrTaskNotFound if the task is not registered.
+func (r *Runner) Pause(tag string) error {
+	e, err := r.entry(tag)
+	if err != nil {
+		return err
+	}
+
+	e.setPaused(true)
+	slog.Info(fmt.Sprintf("%s - task paused", r.name), "task", tag)
+
+	return nil
+}
+
+// Resume resumes the handler calls of the paused (or parked) task and runs it immediately.
+// It returns ErrTaskNotFound if the task is not registered.
+func (r *Runner) Resume(tag string) error {
+	e, err := r.entry(tag)
+	if err != nil {
+		return err
+	}
+
+	e.setPaused(false)
+	r.Wake(tag)
+	slog.Info(fmt.Sprintf("%s - task resumed", r.name), "task", tag)
+
+	return nil
+}
+
+// park pauses the task on the fatal error, retrying can not fix it.
+func (r *Runner) park(e *entry, err error) {
+	e.park()
+	slog.Error(fmt.Sprintf("%s[%s] - fatal error, task is parked until resumed", r.name, e.task.Tag), "err", err)
+
+	if r.hooks.OnPark != nil {
+		r.hooks.OnPark(e.task.Tag, err)
+	}
+}
+
+func (r *Runner) entry(tag string) (*entry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.entries[tag]
+	if !ok {
+		return nil, fmt.Errorf("%s - task[%s]: %w", r.name, tag, ErrTaskNotFound)
+	}
+
+	return e, nil
+}
+
+// start runs the task in a separate goroutine. It must be called under the lock.
+func (r *Runner) start(e *entry) {
+	ctx, cancel := context.WithCancel(r.ctx)
+	e.cancel = cancel
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer close(e.done)
+		defer cancel()
+		r.runTask(ctx, r.handlerCtx, e)
+	}()
+}
+
+func (r *Runner) unfinishedTags() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var tags []string
+	for tag, e := range r.entries {
+		if !e.finished() {
+			tags = append(tags, tag)
+		}
+	}
+	slices.Sort(tags)
+
+	return tags
+}
+
+// Wake resets the backoff of the task with the specified tag and runs it immediately
+// (as soon as a worker is available). If the task is busy at the moment, it will be run
+// once more right after the current call. Returns false if the task is unknown.
+func (r *Runner) Wake(tag string) bool {
+	r.mu.Lock()
+	e, ok := r.entries[tag]
+	r.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	select {
+	case e.wake <- struct{}{}:
+	default:
+		// The task has already been woken up
+	}
+
+	return true
+}
+
+func (r *Runner) runTask(ctx, handlerCtx context.Context, e *entry) {
+	task := e.task
+	tag := task.Tag
+	wake := e.wake
 
 	slog.Info(fmt.Sprintf("%s - run", r.name), "task", tag)
 
+	b := newBreaker(r.name, tag, r.errorPolicy, r.hooks)
+
 	var timeout int
 	timeout = 0
 	for {
@@ -115,17 +367,65 @@ func (r *Runner) runTask(ctx context.Context, tag string, handler TaskHandler) {
 			slog.Debug(fmt.Sprintf("%s[%s] - cancel signal has been received", r.name, tag))
 			return
 		default:
-			if timeout != 0 {
-				wait(ctx, timeout)
+			if e.isPaused() {
+				// Wait for resume (or the cancellation)
+				timeout = 0
+				e.setProgress(timeout, b)
+
+				select {
+				case <-ctx.Done():
+				case <-wake:
+				}
+				continue
+			}
+
+			// The wake-up signals do not interrupt the retry interval after errors
+			w := wake
+			if b.failures > 0 {
+				w = nil
+			}
+
+			if timeout != 0 && wait(ctx, timeout, w) {
+				slog.Debug(fmt.Sprintf("%s[%s] - wake-up signal has been received, reset timeout", r.name, tag))
+				timeout = 0
+			}
+
+			if ok, remaining := b.allow(); !ok {
+				timeout = remaining
+				e.setProgress(timeout, b)
+				continue
+			}
+
+			e.setBusy(true)
+			success, err := r.boundedHandler(ctx, handlerCtx, task)
+			e.setBusy(false)
+
+			if ctx.Err() == nil {
+				e.setResult(err)
 			}
 
-			success, err := r.boundedHandler(ctx, handler)
 			if err != nil {
-				slog.Error(
-					fmt.Sprintf("%s[%s] - call handler error", r.name, tag), "err", err,
-				)
+				if ctx.Err() != nil {
+					continue
+				}
+
+				if errors.Is(err, ErrFatal) {
+					r.park(e, err)
+					continue
+				}
+
+				timeout = b.onFailure(err)
+				e.setProgress(timeout, b)
+				continue
 			}
 
+			// The retry interval after errors does not affect the poll backoff
+			if b.failures > 0 {
+				timeout = 0
+			}
+
+			b.onSuccess(success)
+
 			if success {
 				timeout = 0
 			} else {
@@ -136,42 +436,103 @@ func (r *Runner) runTask(ctx context.Context, tag string, handler TaskHandler) {
 				}
 				slog.Debug(fmt.Sprintf("%s[%s] - increasing timeout up to %d ms", r.name, tag, timeout))
 			}
+
+			e.setProgress(timeout, b)
 		}
 	}
 }
 
-func wait(ctx context.Context, timeout int) {
+// wait sleeps for the timeout (in milliseconds) and returns true if it was interrupted by a wake-up signal.
+func wait(ctx context.Context, timeout int, wake <-chan struct{}) bool {
 	if timeout < 1 {
 		timeout = 1
 	}
 
+	timer := time.NewTimer(time.Duration(timeout) * time.Millisecond)
+	defer timer.Stop()
+
 	select {
-	case <-time.After(time.Duration(timeout) * time.Millisecond):
-		return
+	case <-timer.C:
+		return false
+	case <-wake:
+		return true
 	case <-ctx.Done():
-		return
+		return false
 	}
-
 }
 
-func (r *Runner) boundedHandler(ctx context.Context, handler TaskHandler) (bool, error) {
+func (r *Runner) boundedHandler(ctx, handlerCtx context.Context, task Task) (bool, error) {
 
-	select {
-	case r.sema <- struct{}{}:
-		defer func() {
-			<-r.sema
-		}()
-	case <-ctx.Done():
+	if err := r.sched.acquire(ctx, task); err != nil {
+		return false, err
+	}
+	defer r.sched.release()
+
+	timeout := task.Timeout
+	if timeout == 0 {
+		timeout = r.handlerTimeout
+	}
 
-		return false, nil
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		handlerCtx, cancel = context.WithTimeout(handlerCtx, timeout)
+		defer cancel()
 	}
-	return handler(context.Background())
+
+	return task.Handler(handlerCtx)
 }
 
-// Stop stops all task worker
-func (r *Runner) Stop(ctx context.Context) {
+// Stop stops all task workers: new handler calls are not started,
+// the running handlers are given the grace period to finish, then their context is cancelled.
+// Stop waits for the tasks until ctx is done and returns UnfinishedError with the tags
+// of the tasks that have not finished by then.
+func (r *Runner) Stop(ctx context.Context) error {
 	slog.Info(fmt.Sprintf("%s - stop, releasing resources", r.name))
-	r.cancel()
-	r.wg.Wait()
-	slog.Info(fmt.Sprintf("%s - stop, done", r.name))
+
+	r.mu.Lock()
+	cancel, handlerCancel := r.cancel, r.handlerCancel
+	r.mu.Unlock()
+
+	if cancel == nil {
+		slog.Info(fmt.Sprintf("%s - stop, not running", r.name))
+		return nil
+	}
+
+	cancel()
+	defer handlerCancel()
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	var grace <-chan time.Time
+	if r.gracePeriod > 0 {
+		timer := time.NewTimer(r.gracePeriod)
+		defer timer.Stop()
+		grace = timer.C
+	}
+
+	select {
+	case <-done:
+		slog.Info(fmt.Sprintf("%s - stop, done", r.name))
+		return nil
+	case <-grace:
+		slog.Warn(fmt.Sprintf("%s - stop, grace period is over, cancel handlers", r.name))
+	case <-ctx.Done():
+		slog.Warn(fmt.Sprintf("%s - stop, deadline is reached, cancel handlers", r.name))
+	}
+
+	handlerCancel()
+
+	select {
+	case <-done:
+		slog.Info(fmt.Sprintf("%s - stop, done", r.name))
+		return nil
+	case <-ctx.Done():
+		err := &UnfinishedError{Tags: r.unfinishedTags()}
+		slog.Error(fmt.Sprintf("%s - stop, failed", r.name), "err", err)
+		return err
+	}
 }
@@ -2,7 +2,10 @@ package runner_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"math/rand"
+	"sync/atomic"
 
 	"github.com/eugene-vodyanko/orgonaut/pkg/runner"
 	"testing"
@@ -48,5 +51,413 @@ func TestServer_Run(t *testing.T) {
 	}
 
 	time.Sleep(5000 * time.Millisecond)
-	s.Stop(ctx)
+
+	err = s.Stop(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunner_Wake(t *testing.T) {
+	calls := make(chan struct{}, 10)
+
+	handler := func(ctx context.Context) (bool, error) {
+		calls <- struct{}{}
+		return false, nil
+	}
+
+	s := runner.NewRunner("test", 10000, 10000, 2, 1,
+		runner.Task{Tag: "task1", Handler: handler},
+	)
+
+	ctx := context.Background()
+	err := s.RunTasks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Stop(ctx) }()
+
+	<-calls
+
+	if !s.Wake("task1") {
+		t.Fatal("task1 must be known")
+	}
+
+	if s.Wake("unknown") {
+		t.Fatal("unknown task must not be woken up")
+	}
+
+	select {
+	case <-calls:
+	case <-time.After(time.Second):
+		t.Fatal("task1 has not been woken up")
+	}
+}
+
+func TestRunner_StopGracePeriod(t *testing.T) {
+	started := make(chan struct{}, 1)
+
+	handler := func(ctx context.Context) (bool, error) {
+		started <- struct{}{}
+		<-ctx.Done()
+		return false, ctx.Err()
+	}
+
+	s := runner.NewRunner("test", 100, 100, 2, 1,
+		runner.Task{Tag: "task1", Handler: handler},
+	)
+	s.SetGracePeriod(100 * time.Millisecond)
+
+	err := s.RunTasks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err = s.Stop(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunner_StopUnfinished(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	defer close(release)
+
+	hung := func(ctx context.Context) (bool, error) {
+		started <- struct{}{}
+		<-release
+		return false, nil
+	}
+
+	handler := func(ctx context.Context) (bool, error) {
+		return false, nil
+	}
+
+	s := runner.NewRunner("test", 10, 10, 2, 2,
+		runner.Task{Tag: "task1", Handler: hung},
+		runner.Task{Tag: "task2", Handler: handler},
+	)
+
+	err := s.RunTasks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err = s.Stop(ctx)
+
+	var unfinished *runner.UnfinishedError
+	if !errors.As(err, &unfinished) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(unfinished.Tags) != 1 || unfinished.Tags[0] != "task1" {
+		t.Fatalf("unexpected unfinished tasks: %v", unfinished.Tags)
+	}
+}
+
+func TestRunner_HandlerTimeout(t *testing.T) {
+	errs := make(chan error, 1)
+
+	handler := func(ctx context.Context) (bool, error) {
+		<-ctx.Done()
+		select {
+		case errs <- ctx.Err():
+		default:
+		}
+		return false, ctx.Err()
+	}
+
+	s := runner.NewRunner("test", 100, 100, 2, 1,
+		runner.Task{Tag: "task1", Handler: handler, Timeout: 50 * time.Millisecond},
+	)
+
+	ctx := context.Background()
+	err := s.RunTasks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Stop(ctx) }()
+
+	select {
+	case err = <-errs:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handler has not been cancelled")
+	}
+}
+
+func TestRunner_CircuitBreaker(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+
+	handler := func(ctx context.Context) (bool, error) {
+		if failing.Load() {
+			return false, errors.New("sink is down")
+		}
+		return false, nil
+	}
+
+	states := make(chan runner.State, 10)
+	var failures atomic.Int32
+
+	s := runner.NewRunner("test", 10, 10, 2, 1,
+		runner.Task{Tag: "task1", Handler: handler},
+	)
+	s.SetErrorPolicy(runner.ErrorPolicy{
+		InitialInterval:  10,
+		MaxInterval:      10,
+		Jitter:           0.5,
+		FailureThreshold: 3,
+		OpenInterval:     100,
+	})
+	s.SetHooks(runner.Hooks{
+		OnError: func(tag string, err error, n int) {
+			failures.Add(1)
+		},
+		OnStateChange: func(tag string, from, to runner.State) {
+			states <- to
+		},
+	})
+
+	ctx := context.Background()
+	err := s.RunTasks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Stop(ctx) }()
+
+	expect := func(state runner.State) {
+		t.Helper()
+		select {
+		case v := <-states:
+			if v != state {
+				t.Fatalf("unexpected state: %s, expected: %s", v, state)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("state %s has not been reached", state)
+		}
+	}
+
+	expect(runner.StateOpen)
+	if n := failures.Load(); n != 3 {
+		t.Fatalf("unexpected failures before opening: %d", n)
+	}
+
+	expect(runner.StateHalfOpen)
+	expect(runner.StateOpen)
+
+	failing.Store(false)
+
+	expect(runner.StateHalfOpen)
+	expect(runner.StateClosed)
+}
+
+func TestRunner_AddRemoveTask(t *testing.T) {
+	calls := make(chan string, 10)
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	newHandler := func(tag string) runner.TaskHandler {
+		return func(ctx context.Context) (bool, error) {
+			calls <- tag
+			return false, nil
+		}
+	}
+
+	s := runner.NewRunner("test", 10000, 10000, 2, 2,
+		runner.Task{Tag: "task1", Handler: newHandler("task1")},
+	)
+
+	ctx := context.Background()
+	err := s.RunTasks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Stop(ctx) }()
+
+	if v := <-calls; v != "task1" {
+		t.Fatalf("unexpected call: %s", v)
+	}
+
+	err = s.AddTask(runner.Task{Tag: "task1", Handler: newHandler("task1")})
+	if !errors.Is(err, runner.ErrTaskExists) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var removed atomic.Bool
+	err = s.AddTask(runner.Task{Tag: "task2", Group: "group2", Handler: func(ctx context.Context) (bool, error) {
+		close(started)
+		<-release
+		if removed.Load() {
+			t.Error("task2 has been removed before the handler finished")
+		}
+		return false, nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-started
+
+	tasks := s.Tasks()
+	if len(tasks) != 2 || tasks[1].Tag != "task2" || tasks[1].Group != "group2" || !tasks[1].Busy {
+		t.Fatalf("unexpected tasks: %+v", tasks)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+
+	err = s.RemoveTask("task2")
+	removed.Store(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tasks = s.Tasks(); len(tasks) != 1 {
+		t.Fatalf("unexpected tasks after removal: %+v", tasks)
+	}
+
+	err = s.RemoveTask("task2")
+	if !errors.Is(err, runner.ErrTaskNotFound) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestRunner_PauseResume(t *testing.T) {
+	calls := make(chan struct{}, 10)
+
+	handler := func(ctx context.Context) (bool, error) {
+		calls <- struct{}{}
+		return false, nil
+	}
+
+	s := runner.NewRunner("test", 10, 10, 2, 1,
+		runner.Task{Tag: "task1", Handler: handler},
+	)
+
+	if err := s.Pause("task1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Pause("unknown"); !errors.Is(err, runner.ErrTaskNotFound) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx := context.Background()
+	err := s.RunTasks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Stop(ctx) }()
+
+	select {
+	case <-calls:
+		t.Fatal("paused task must not be called")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if tasks := s.Tasks(); !tasks[0].Paused {
+		t.Fatalf("unexpected status: %+v", tasks[0])
+	}
+
+	if err = s.Resume("task1"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-calls:
+	case <-time.After(time.Second):
+		t.Fatal("resumed task has not been called")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for s.Tasks()[0].LastSuccess.IsZero() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if tasks := s.Tasks(); tasks[0].Paused || tasks[0].LastSuccess.IsZero() {
+		t.Fatalf("unexpected status: %+v", tasks[0])
+	}
+}
+
+func TestRunner_ParkOnFatalError(t *testing.T) {
+	var calls atomic.Int32
+	fatal := atomic.Bool{}
+	fatal.Store(true)
+
+	handler := func(ctx context.Context) (bool, error) {
+		calls.Add(1)
+		if fatal.Load() {
+			return false, fmt.Errorf("relay: %w: table or view does not exist", runner.ErrFatal)
+		}
+		return false, nil
+	}
+
+	parked := make(chan string, 1)
+
+	s := runner.NewRunner("test", 10, 10, 2, 1,
+		runner.Task{Tag: "task1", Handler: handler},
+	)
+	s.SetHooks(runner.Hooks{
+		OnPark: func(tag string, err error) {
+			parked <- tag
+		},
+	})
+
+	ctx := context.Background()
+	err := s.RunTasks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Stop(ctx) }()
+
+	select {
+	case tag := <-parked:
+		if tag != "task1" {
+			t.Fatalf("unexpected tag: %s", tag)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("task has not been parked")
+	}
+
+	// The parked task is not retried
+	time.Sleep(100 * time.Millisecond)
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("unexpected calls: %d", n)
+	}
+
+	status := s.Tasks()[0]
+	if !status.Paused || !status.Parked || status.Failures != 0 || status.LastError == nil {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	// Resumed after the cause is fixed
+	fatal.Store(false)
+	if err = s.Resume("task1"); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for calls.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if status = s.Tasks()[0]; status.Paused || status.Parked || calls.Load() < 2 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
 }
@@ -0,0 +1,193 @@
+package runner
+
+import (
+	"context"
+	"sync"
+)
+
+// scheduler limits the number of simultaneously running handlers and distributes
+// the worker slots fairly between the task groups.
+//
+// The groups with a higher priority are always served first. The groups with the same priority
+// share the slots in proportion to their weights (stride scheduling): each grant advances
+// the pass of the group by 1/weight, and the waiting group with the smallest pass is served next.
+// Within a group, the waiters are served in FIFO order.
+//
+// The groups are kept while they have registered tasks (see add and remove) or waiters.
+type scheduler struct {
+	mu     sync.Mutex
+	free   int
+	vtime  float64
+	groups map[string]*group
+}
+
+type group struct {
+	name     string
+	weight   int
+	priority int
+	pass     float64
+	tasks    int
+	waiters  []*waiter
+}
+
+type waiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+func newScheduler(slots int) *scheduler {
+	return &scheduler{
+		free:   slots,
+		groups: make(map[string]*group),
+	}
+}
+
+// add registers the task in its group.
+func (s *scheduler) add(task Task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.group(task).tasks++
+}
+
+// remove unregisters the task, the group is deleted with its last task.
+func (s *scheduler) remove(task Task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g, ok := s.groups[groupName(task)]
+	if !ok {
+		return
+	}
+
+	g.tasks--
+	s.prune(g)
+}
+
+// acquire waits for a free worker slot for the task, the slot must be released by release.
+func (s *scheduler) acquire(ctx context.Context, task Task) error {
+	s.mu.Lock()
+
+	g := s.group(task)
+	if s.free > 0 && !s.hasWaiters() {
+		s.free--
+		s.grant(g)
+		s.prune(g)
+		s.mu.Unlock()
+		return nil
+	}
+
+	w := &waiter{ready: make(chan struct{})}
+	g.waiters = append(g.waiters, w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if w.granted {
+			// The slot has been granted concurrently with the cancellation, pass it on
+			s.next()
+			return ctx.Err()
+		}
+
+		for i, v := range g.waiters {
+			if v == w {
+				g.waiters = append(g.waiters[:i], g.waiters[i+1:]...)
+				break
+			}
+		}
+		s.prune(g)
+		return ctx.Err()
+	}
+}
+
+// release frees the worker slot and passes it to the next waiter (if any).
+func (s *scheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.next()
+}
+
+// next passes the free slot to the next waiter. It must be called under the lock.
+func (s *scheduler) next() {
+	var next *group
+	for _, g := range s.groups {
+		if len(g.waiters) == 0 {
+			continue
+		}
+
+		if next == nil ||
+			g.priority > next.priority ||
+			(g.priority == next.priority && g.pass < next.pass) {
+			next = g
+		}
+	}
+
+	if next == nil {
+		s.free++
+		return
+	}
+
+	w := next.waiters[0]
+	next.waiters = next.waiters[1:]
+
+	s.grant(next)
+	s.prune(next)
+
+	w.granted = true
+	close(w.ready)
+}
+
+// grant advances the pass of the group. It must be called under the lock.
+func (s *scheduler) grant(g *group) {
+	// An idle group does not accumulate credit, it joins at the current virtual time
+	g.pass = max(g.pass, s.vtime)
+	s.vtime = g.pass
+	g.pass += 1 / float64(g.weight)
+}
+
+// prune deletes the group without the tasks and the waiters. It must be called under the lock.
+func (s *scheduler) prune(g *group) {
+	if g.tasks <= 0 && len(g.waiters) == 0 {
+		delete(s.groups, g.name)
+	}
+}
+
+// group returns the group of the task. It must be called under the lock.
+func (s *scheduler) group(task Task) *group {
+	name := groupName(task)
+
+	g, ok := s.groups[name]
+	if !ok {
+		g = &group{name: name}
+		s.groups[name] = g
+	}
+
+	g.weight = max(task.Weight, 1)
+	g.priority = task.Priority
+
+	return g
+}
+
+// groupName returns the name of the group of the task, the tag of the task if it is not set.
+func groupName(task Task) string {
+	if task.Group != "" {
+		return task.Group
+	}
+	return task.Tag
+}
+
+// hasWaiters reports whether any task waits for a slot. It must be called under the lock.
+func (s *scheduler) hasWaiters() bool {
+	for _, g := range s.groups {
+		if len(g.waiters) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+package runner
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// grants occupies the single slot, enqueues the waiters of the tasks and counts the order of the grants.
+func grants(t *testing.T, tasks []Task, waiters int) []string {
+	t.Helper()
+
+	s := newScheduler(1)
+	ctx := context.Background()
+
+	if err := s.acquire(ctx, Task{Tag: "busy"}); err != nil {
+		t.Fatal(err)
+	}
+
+	order := make(chan string, len(tasks)*waiters)
+	for _, task := range tasks {
+		for i := 0; i < waiters; i++ {
+			task := task
+			go func() {
+				if err := s.acquire(ctx, task); err != nil {
+					t.Error(err)
+					return
+				}
+				order <- task.Group
+			}()
+		}
+	}
+
+	// Wait until all the waiters are enqueued
+	for {
+		s.mu.Lock()
+		n := 0
+		for _, g := range s.groups {
+			n += len(g.waiters)
+		}
+		s.mu.Unlock()
+
+		if n == len(tasks)*waiters {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	var result []string
+	for i := 0; i < len(tasks)*waiters; i++ {
+		s.release()
+		result = append(result, <-order)
+	}
+
+	return result
+}
+
+func TestScheduler_Weights(t *testing.T) {
+	order := grants(t, []Task{
+		{Tag: "orders", Group: "orders", Weight: 3},
+		{Tag: "audit", Group: "audit", Weight: 1},
+	}, 12)
+
+	// Among the first 8 grants, the slots are shared in proportion 3:1
+	counts := map[string]int{}
+	for _, v := range order[:8] {
+		counts[v]++
+	}
+
+	if counts["orders"] != 6 || counts["audit"] != 2 {
+		t.Fatalf("unexpected distribution: %v", counts)
+	}
+}
+
+func TestScheduler_Priority(t *testing.T) {
+	order := grants(t, []Task{
+		{Tag: "audit", Group: "audit", Weight: 10},
+		{Tag: "orders", Group: "orders", Priority: 1},
+	}, 5)
+
+	for i, v := range order[:5] {
+		if v != "orders" {
+			t.Fatalf("unexpected group of grant %d: %s", i, v)
+		}
+	}
+}
+
+func TestScheduler_Cancel(t *testing.T) {
+	s := newScheduler(1)
+
+	if err := s.acquire(context.Background(), Task{Tag: "busy"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := s.acquire(ctx, Task{Tag: "task1"}); err == nil {
+		t.Fatal("acquire must fail on cancellation")
+	}
+
+	s.release()
+
+	if err := s.acquire(context.Background(), Task{Tag: "task2"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScheduler_RemoveGroup(t *testing.T) {
+	s := newScheduler(1)
+
+	removed := Task{Tag: "audit_0", Group: "audit", Priority: 1}
+	kept := Task{Tag: "orders_0", Group: "orders"}
+	s.add(removed)
+	s.add(Task{Tag: "audit_1", Group: "audit", Priority: 1})
+	s.add(kept)
+
+	// The group is kept until its last task is removed
+	s.remove(removed)
+	if _, ok := s.groups["audit"]; !ok {
+		t.Fatal("group must be kept with the remaining task")
+	}
+
+	s.remove(Task{Tag: "audit_1", Group: "audit"})
+	if _, ok := s.groups["audit"]; ok {
+		t.Fatal("group must be deleted with the last task")
+	}
+
+	// The remaining group is scheduled
+	if err := s.acquire(context.Background(), kept); err != nil {
+		t.Fatal(err)
+	}
+
+	granted := make(chan error, 1)
+	go func() {
+		granted <- s.acquire(context.Background(), kept)
+	}()
+
+	for {
+		s.mu.Lock()
+		n := len(s.groups["orders"].waiters)
+		s.mu.Unlock()
+
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	s.release()
+
+	select {
+	case err := <-granted:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("slot has not been granted")
+	}
+
+	if len(s.groups) != 1 {
+		t.Fatalf("unexpected groups: %v", s.groups)
+	}
+}
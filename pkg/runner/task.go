@@ -0,0 +1,170 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTaskExists   = errors.New("task already exists")
+	ErrTaskNotFound = errors.New("task not found")
+	// ErrFatal marks the handler errors that can not be fixed by retrying (e.g. invalid configuration):
+	// the task is parked (paused) instead of retrying until it is resumed (see Runner.Resume).
+	ErrFatal = errors.New("fatal error")
+)
+
+type TaskHandler func(ctx context.Context) (bool, error)
+type Task struct {
+	Tag     string
+	Handler TaskHandler
+	// Timeout limits the duration of a single handler call.
+	// If zero, the default handler timeout of the runner is used (if any).
+	Timeout time.Duration
+	// Group combines the tasks sharing the worker slots, if empty, the task makes up its own group.
+	Group string
+	// Priority of the group: the waiting tasks of the group with the higher priority get a worker slot first.
+	Priority int
+	// Weight of the group: the groups of the same priority share the worker slots in proportion to the weights.
+	// If zero, the weight is 1.
+	Weight int
+}
+
+// TaskStatus is a snapshot of the task state.
+type TaskStatus struct {
+	Tag      string
+	Group    string
+	Priority int
+	Weight   int
+	Timeout  time.Duration
+	// Busy is true while the handler is being called (or waits for a worker slot).
+	Busy bool
+	// Interval is the current wait interval before the next handler call (in milliseconds).
+	Interval int
+	// State is the state of the task circuit breaker.
+	State State
+	// Failures is the number of consecutive handler errors.
+	Failures int
+	// Paused is true if the handler calls are suspended (see Runner.Pause).
+	Paused bool
+	// Parked is true if the task is paused by the fatal handler error (see ErrFatal).
+	Parked bool
+	// LastSuccess is the time of the last handler call without error.
+	LastSuccess time.Time
+	// LastError is the last handler error (it is not reset on success).
+	LastError error
+	// LastErrorAt is the time of the last handler error.
+	LastErrorAt time.Time
+}
+
+// entry is a task registered in the runner.
+type entry struct {
+	task   Task
+	wake   chan struct{}
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu          sync.Mutex
+	busy        bool
+	interval    int
+	state       State
+	failures    int
+	paused      bool
+	parked      bool
+	lastSuccess time.Time
+	lastError   error
+	lastErrorAt time.Time
+}
+
+func newEntry(task Task) *entry {
+	return &entry{
+		task: task,
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+}
+
+func (e *entry) setBusy(busy bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.busy = busy
+}
+
+func (e *entry) setPaused(paused bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.paused = paused
+	e.parked = false
+}
+
+func (e *entry) park() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.paused = true
+	e.parked = true
+}
+
+func (e *entry) isPaused() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.paused
+}
+
+func (e *entry) setResult(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err != nil {
+		e.lastError = err
+		e.lastErrorAt = time.Now()
+	} else {
+		e.lastSuccess = time.Now()
+	}
+}
+
+func (e *entry) setProgress(interval int, b *breaker) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.interval = interval
+	e.state = b.state
+	e.failures = b.failures
+}
+
+func (e *entry) status() TaskStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return TaskStatus{
+		Tag:      e.task.Tag,
+		Group:    e.task.Group,
+		Priority: e.task.Priority,
+		Weight:   e.task.Weight,
+		Timeout:  e.task.Timeout,
+		Busy:     e.busy,
+		Interval: e.interval,
+		State:    e.state,
+		Failures: e.failures,
+		Paused:   e.paused,
+		Parked:   e.parked,
+
+		LastSuccess: e.lastSuccess,
+		LastError:   e.lastError,
+		LastErrorAt: e.lastErrorAt,
+	}
+}
+
+// finished reports whether the task goroutine has finished.
+func (e *entry) finished() bool {
+	select {
+	case <-e.done:
+		return true
+	default:
+		return false
+	}
+}
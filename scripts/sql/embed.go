@@ -0,0 +1,10 @@
+// Package sql embeds the scripts of the database objects of Orgonaut,
+// so they are installed and upgraded by the application (see "orgonaut schema").
+package sql
+
+import "embed"
+
+// FS contains the scripts of the tables and the packages (install.sql is for SQL*Plus only).
+//
+//go:embed *.sql *.pck
+var FS embed.FS
@@ -4,6 +4,32 @@ prompt Creating table EVENT_LOG
 prompt ========================
 prompt
 @@org_event_log.sql
+@@org_event_log_tx.sql
+prompt
+prompt Creating table TASK_STATE
+prompt =========================
+prompt
+@@org_task_state.sql
+prompt
+prompt Creating table SNAPSHOT_STATE
+prompt =============================
+prompt
+@@org_snapshot_state.sql
+prompt
+prompt Creating table CONSUMER_POSITION
+prompt ================================
+prompt
+@@org_consumer_position.sql
+prompt
+prompt Creating table OUTBOX_SETTING
+prompt =============================
+prompt
+@@org_outbox_setting.sql
+prompt
+prompt Creating table SCHEMA_MIGRATION
+prompt ===============================
+prompt
+@@org_schema_migration.sql
 prompt
 prompt Creating package ORG$GATE_API
 prompt =============================
@@ -18,7 +44,7 @@ prompt
 prompt Creating package ORG$UTIL
 prompt =========================
 prompt
-@@org$util.sql
+@@org$util.pck
 prompt
 prompt Creating package ORG$XML_ENCODE
 prompt ===============================
@@ -71,8 +71,200 @@ begin
   dbms_output.put_line('del_cnt=' || del_cnt);
 end;
 
+-- Wait for the new events alerts of the first and second parts of the group
+declare
+  v_name varchar2(30);
+  v_status int;
+begin
+  org$gate_api.registerAlert(p_group_id => 'test_tab', p_part_id => 1, r_name => v_name);
+  org$gate_api.registerAlert(p_group_id => 'test_tab', p_part_id => 2, r_name => v_name);
+
+  org$gate_api.waitAlert(p_timeout => 10, r_name => v_name, r_status => v_status);
+  dbms_output.put_line('name=' || v_name || ', status=' || v_status);
+
+  org$gate_api.removeAlerts();
+end;
+
+-- Describe the query of the task (pk type 2 - number)
+declare
+  v_pk_type int;
+  v_col_count int;
+begin
+  org$gate_api.describeQuery(p_qry_columns    => '*',
+                             p_qry_from       => 'select t.* from test_tab t',
+                             p_qry_pk_column  => 'id',
+                             r_pk_type        => v_pk_type,
+                             r_col_count      => v_col_count
+                             );
+
+  dbms_output.put_line('pk_type=' || v_pk_type || ', col_count=' || v_col_count);
+end;
+
 */
 
+-- The version of the API of the packages, the application refuses to run against another version.
+-- It is changed with the packages by the schema migrations (see "orgonaut schema upgrade").
+API_VERSION constant number := 1;
+
+function apiVersion return number;
+
+-- The compaction of the events of a chunk (p_compaction):
+-- the last event of the key wins, or every event is returned (the rows of the key are read once).
+COMPACTION_NONE constant number := 0;
+COMPACTION_LAST_WINS constant number := 1;
+
+-- Get the next events of the part of the group for the named consumer (see CONSUMER_POSITION table)
+-- serialized as getNextEvents does. The events are not marked as processed, the position of the consumer
+-- is advanced instead (it is locked till the end of the transaction), so the group can be consumed
+-- by several consumers independently of each other and of the unnamed consumer (getNextEvents).
+-- The new consumer starts from the oldest event in the outbox.
+-- The events are read in order of the commit (the SCN of the transaction, then the rowid), the transactions
+-- committed after the read get the greater SCN, so the events are not missed however long the transactions are.
+-- The position of the previous versions (by the time) is converted on the first read, the events after it
+-- are read again from the first of them by the commit order (at least once).
+procedure getNextConsumerEvents(
+  p_group_id in varchar2
+, p_consumer in varchar2
+, p_part_id in number
+, p_rows in number
+
+, p_qry_columns in varchar2
+, p_qry_from in varchar2
+, p_qry_pk_column in varchar2
+, p_before in number default 0
+, p_compaction in number default COMPACTION_LAST_WINS
+
+, r_upd_rows_dump out nocopy blob
+, r_upd_rows_count out number
+, r_del_rows_dump out nocopy blob
+, r_del_rows_count out number
+, r_events_dump out nocopy blob
+);
+
+-- Get the next payload events of the part of the group (see org$outbox_api.putPayloadEvent)
+-- in XML format compressed with gzip. The payload and the headers are returned as-is (escaped in XML)
+-- in the __payload and __headers elements, the key of the event is named p_pk_name.
+-- The events are not compacted. They are marked as processed, or the position of the named consumer
+-- (p_consumer, null - unnamed) is advanced (see getNextConsumerEvents).
+procedure getNextPayloadEvents(
+  p_group_id in varchar2
+, p_consumer in varchar2
+, p_part_id in number
+, p_rows in number
+, p_pk_name in varchar2
+
+, r_rows_dump out nocopy blob
+, r_rows_count out number
+);
+
+-- Get the next chunk of the relayed events of the group in the time window [p_from_ts, p_to_ts)
+-- (format 'YYYY-MM-DD HH24:MI:SS.FF3') and the parts (comma-separated list, null - all the parts),
+-- after the event (p_last_ts, p_last_rid) of the previous chunk (null - from the start) in order of the time,
+-- serialized as getNextEvents does (the current state of the rows is read). The state of the events is not changed.
+-- It is used to re-deliver the events (replay). Returns the position of the last event to pass to the next call,
+-- and r_done = 1 if there are no more events.
+-- @p_consumer - the named consumer of the group: the events at or before its position are relayed
+-- (see CONSUMER_POSITION), null - the events marked as processed.
+procedure getReplayEvents(
+  p_group_id in varchar2
+, p_consumer in varchar2
+, p_parts in varchar2
+, p_from_ts in varchar2
+, p_to_ts in varchar2
+, p_last_ts in varchar2
+, p_last_rid in varchar2
+, p_rows in number
+
+, p_qry_columns in varchar2
+, p_qry_from in varchar2
+, p_qry_pk_column in varchar2
+, p_before in number default 0
+, p_compaction in number default COMPACTION_LAST_WINS
+
+, r_upd_rows_dump out nocopy blob
+, r_upd_rows_count out number
+, r_del_rows_dump out nocopy blob
+, r_del_rows_count out number
+, r_last_ts out varchar2
+, r_last_rid out varchar2
+, r_done out number
+, r_events_dump out nocopy blob
+);
+
+-- Register the session to receive the alerts about new events in the part of the group.
+-- Returns the name of the alert (see org$outbox_api.alertName).
+procedure registerAlert(
+  p_group_id in varchar2
+, p_part_id in number
+, r_name out varchar2
+);
+
+-- Wait for any of the registered alerts during the timeout (in seconds).
+-- Returns status 0 and the name of the alert if it was signaled, or status 1 on timeout.
+procedure waitAlert(
+  p_timeout in number
+, r_name out varchar2
+, r_status out number
+);
+
+-- Remove all the alert registrations of the session.
+procedure removeAlerts;
+
+-- Describe the query of the updated rows built by getNextEvents, the query is parsed, but not executed.
+-- Returns the type code of the pk column (see dbms_sql.describe_columns2) and the number of the columns,
+-- raises the parse error if the query is invalid (e.g. unknown table or column).
+procedure describeQuery(
+  p_qry_columns in varchar2
+, p_qry_from in varchar2
+, p_qry_pk_column in varchar2
+, r_pk_type out number
+, r_col_count out number
+);
+
+-- Get the next chunk of the rows of the snapshot (initial load) of the group in ascending order of the pk,
+-- serialized in XML with "__op" = 'r' (see org$outbox_api.ACTION_READ): binary gzip representation.
+-- The position is kept in the SNAPSHOT_STATE table, it is locked and advanced within the current transaction,
+-- so the snapshot is resumed from the last committed chunk. Returns r_done = 1 when the snapshot is complete.
+-- @p_consumer - the named consumer of the group, it takes its own snapshot (null - the unnamed consumer).
+procedure getSnapshotRows(
+  p_group_id in varchar2
+, p_consumer in varchar2
+, p_rows in number
+
+, p_qry_columns in varchar2
+, p_qry_from in varchar2
+, p_qry_pk_column in varchar2
+
+, r_rows_dump out nocopy blob
+, r_rows_count out number
+, r_done out number
+);
+
+-- The values of the bind variables :v1..:vN of the backfill predicate
+type TBindValues is table of varchar2(4000) index by binary_integer;
+
+-- Get the next chunk of the rows matching the predicate (e.g. 'id between :v1 and :v2') after the key p_last_pk
+-- (null - from the start) in ascending order of the pk, serialized as getSnapshotRows does.
+-- The values of the predicate are bound by name from p_values (:v1 - p_values(1)), the predicate is made
+-- by the application of the validated filter (the allow-listed columns and operators), not by the user.
+-- It is used to re-emit the current state of the rows (backfill), the outbox is not used.
+-- Returns the last key of the chunk to pass to the next call, and r_done = 1 if there are no more rows.
+procedure getBackfillRows(
+  p_rows in number
+, p_predicate in varchar2
+, p_values in TBindValues
+, p_last_pk in number
+
+, p_qry_columns in varchar2
+, p_qry_from in varchar2
+, p_qry_pk_column in varchar2
+
+, r_rows_dump out nocopy blob
+, r_rows_count out number
+, r_last_pk out number
+, r_done out number
+);
+
 -- Get the next new events serialized in XML: symbolic representation
 procedure getNextEvents(
   p_group_id in varchar2
@@ -89,7 +281,13 @@ procedure getNextEvents(
 , r_del_rows_count out number
 );
 
--- Get the next new events serialized in XML: binary gzip representation UTF8 of XML-text
+-- Get the next new events serialized in XML: binary gzip representation UTF8 of XML-text.
+-- The events of the rows are returned in r_events_dump, a row per key (the __pk_val element):
+-- the time (__event_ts, __ux_event_ts) and the sequence number (__seq) of the last event of the key in the chunk,
+-- and if p_before = 1, the before image (__before) of the first one (see org$outbox_api.putUpdateEvent),
+-- If p_compaction = COMPACTION_NONE, r_events_dump has a row per event (with the before image of the event),
+-- the updated and the deleted rows are returned once per key, so the rows are repeated for the events by the caller.
+-- The same applies to getNextConsumerEvents and getReplayEvents.
 procedure getNextEvents(
   p_group_id in varchar2
 , p_part_id in number
@@ -98,11 +296,14 @@ procedure getNextEvents(
 , p_qry_columns in varchar2
 , p_qry_from in varchar2
 , p_qry_pk_column in varchar2
+, p_before in number default 0
+, p_compaction in number default COMPACTION_LAST_WINS
 
 , r_upd_rows_dump out nocopy blob
 , r_upd_rows_count out number
 , r_del_rows_dump out nocopy blob
 , r_del_rows_count out number
+, r_events_dump out nocopy blob
 );
 
 end org$gate_api;
@@ -110,6 +311,11 @@ end org$gate_api;
 
 create or replace package body orgon.org$gate_api is
 
+SNAPSHOT_RUNNING constant varchar2(1) := 'r';
+SNAPSHOT_DONE constant varchar2(1) := 'd';
+
+TS_FORMAT constant varchar2(30) := 'YYYY-MM-DD HH24:MI:SS.FF3';
+
 function toUnixTimestamp(
   p_ts in timestamp
 , p_tz in varchar2 default DBTIMEZONE
@@ -155,10 +361,12 @@ procedure copmactAndSplitEvents(
   p_events in out nocopy org$outbox_api.TEventArray
 , r_upd_events out nocopy org$outbox_api.TEventArray
 , r_del_events out nocopy org$outbox_api.TEventArray
+, p_compaction in number default COMPACTION_LAST_WINS
 )
 is
   type TCompList is table of integer index by varchar2(32);
   copmList TCompList;
+  delList TCompList;
   pk number;
   uc pls_integer := 0;
   dc pls_integer := 0;
@@ -167,6 +375,30 @@ begin
   r_upd_events := new org$outbox_api.TEventArray();
   r_del_events := new org$outbox_api.TEventArray();
 
+  -- No compaction: the first event of the key per the action (the row is read once),
+  -- the events are repeated by the caller (see dumpEventsMeta)
+  if p_compaction = COMPACTION_NONE then
+    for i in 1..p_events.count() loop
+      pk := p_events(i).key;
+      if p_events(i).op in (org$outbox_api.ACTION_INSERT, org$outbox_api.ACTION_UPDATE) then
+        if not copmList.exists(pk) then
+          copmList(pk) := i;
+          uc := uc + 1;
+          r_upd_events.extend(1);
+          r_upd_events(uc) := p_events(i);
+        end if;
+      elsif p_events(i).op = org$outbox_api.ACTION_DELETE then
+        if not delList.exists(pk) then
+          delList(pk) := i;
+          dc := dc + 1;
+          r_del_events.extend(1);
+          r_del_events(dc) := p_events(i);
+        end if;
+      end if;
+    end loop;
+    return;
+  end if;
+
   -- Compaction: last event wins
   for i in reverse 1..p_events.count() loop
     pk := p_events(i).key;
@@ -195,24 +427,21 @@ begin
   end loop;
 end;
 
-procedure dumpUpdatedRows(
+function makeUpdatedRowsQuery(
   p_qry_columns in varchar2
 , p_qry_from in varchar2
 , p_qry_pk_column in varchar2
-, p_events in out nocopy org$outbox_api.TEventArray
-, r_rows_dump out nocopy clob
-, r_rows_count out number
-)
+, p_binds in out nocopy org$xml_factory.TBindParams
+, p_op in varchar2 default org$outbox_api.ACTION_UPDATE
+) return varchar2
 is
-  qry varchar2(32000);
-  binds org$xml_factory.TBindParams;
   alias constant varchar2(1) := 'q';
 
   function metaColumns return varchar2 is
   begin
     return
       /* action type */
-      '''' || org$outbox_api.ACTION_UPDATE || '''' || ' "__op"' || ', ' || 
+      '''' || p_op || '''' || ' "__op"' || ', ' || 
       /* pk column name */
       '''' || p_qry_pk_column || '''' || ' "__pk_name"' || ', ' || 
       /* pk column value */
@@ -236,18 +465,33 @@ is
   begin
     return '('|| p_qry_from || ') ' || alias;
   end;   
-  
+
 begin
-  for i in 1..p_events.count() loop
-    binds(i) := org$xml_factory.newBindParam(i, p_events(i).key);
-  end loop;
-  
-  qry := makeSqlPkInListQuery(
+  return makeSqlPkInListQuery(
     p_cols    => wrapQueryColumns()
   , p_from    => wrapQueryFrom() -- 'test_tab m'
   , p_pk_col  => p_qry_pk_column -- 'id'
-  , p_binds   => binds
+  , p_binds   => p_binds
   );
+end; /* makeUpdatedRowsQuery */
+
+procedure dumpUpdatedRows(
+  p_qry_columns in varchar2
+, p_qry_from in varchar2
+, p_qry_pk_column in varchar2
+, p_events in out nocopy org$outbox_api.TEventArray
+, r_rows_dump out nocopy clob
+, r_rows_count out number
+)
+is
+  qry varchar2(32000);
+  binds org$xml_factory.TBindParams;
+begin
+  for i in 1..p_events.count() loop
+    binds(i) := org$xml_factory.newBindParam(i, p_events(i).key);
+  end loop;
+  
+  qry := makeUpdatedRowsQuery(p_qry_columns, p_qry_from, p_qry_pk_column, binds);
   
   org$xml_factory.dumpCursorAsXml(
     p_query      => qry
@@ -257,6 +501,225 @@ begin
   );
 end; /* dumpUpdatedRows */
 
+procedure describeQuery(
+  p_qry_columns in varchar2
+, p_qry_from in varchar2
+, p_qry_pk_column in varchar2
+, r_pk_type out number
+, r_col_count out number
+)
+is
+  qry varchar2(32000);
+  binds org$xml_factory.TBindParams;
+  cur integer;
+  cols dbms_sql.desc_tab2;
+begin
+  binds(1) := org$xml_factory.newBindParam(1, 0);
+  qry := makeUpdatedRowsQuery(p_qry_columns, p_qry_from, p_qry_pk_column, binds);
+
+  cur := dbms_sql.open_cursor();
+  begin
+    -- The query is parsed, but not executed
+    dbms_sql.parse(cur, qry, dbms_sql.native);
+    dbms_sql.describe_columns2(cur, r_col_count, cols);
+    dbms_sql.close_cursor(cur);
+  exception
+    when others then
+      if dbms_sql.is_open(cur) then
+        dbms_sql.close_cursor(cur);
+      end if;
+      raise;
+  end;
+
+  for i in 1..r_col_count loop
+    if cols(i).col_name = '__pk_val' then
+      r_pk_type := cols(i).col_type;
+    end if;
+  end loop;
+end; /* describeQuery */
+
+procedure dumpRowsAfterKey(
+  p_qry_columns in varchar2
+, p_qry_from in varchar2
+, p_qry_pk_column in varchar2
+, p_predicate in varchar2
+, p_values in TBindValues
+, p_last_pk in number
+, p_rows in number
+, r_rows_dump out nocopy blob
+, r_rows_count out number
+, r_last_pk out number
+, r_key_count out number
+)
+is
+  keys dbms_sql.number_table;
+  binds org$xml_factory.TBindParams;
+  cur integer;
+  n integer;
+  qry varchar2(32000);
+  v_where varchar2(32000);
+  v_xml_text clob;
+begin
+  r_rows_count := 0;
+  r_last_pk := p_last_pk;
+
+  v_where := ' where ' || p_qry_pk_column || ' > :last_pk';
+  if p_predicate is not null then
+    v_where := v_where || ' and (' || p_predicate || ')';
+  end if;
+
+  -- The next chunk of the keys in ascending order, the number of the values of the predicate varies
+  cur := dbms_sql.open_cursor();
+  begin
+    dbms_sql.parse(cur,
+      'select pk from (' ||
+        'select ' || p_qry_pk_column || ' pk from (' || p_qry_from || ') q' || v_where || ' order by 1' ||
+      ') where rownum <= :max_rows',
+      dbms_sql.native);
+    dbms_sql.bind_variable(cur, 'last_pk', nvl(p_last_pk, -1e125));
+    dbms_sql.bind_variable(cur, 'max_rows', p_rows);
+    for i in 1..p_values.count() loop
+      dbms_sql.bind_variable(cur, 'v' || i, p_values(i));
+    end loop;
+    dbms_sql.define_array(cur, 1, keys, p_rows, 1);
+    n := dbms_sql.execute(cur);
+    n := dbms_sql.fetch_rows(cur);
+    dbms_sql.column_value(cur, 1, keys);
+    dbms_sql.close_cursor(cur);
+  exception
+    when others then
+      if dbms_sql.is_open(cur) then
+        dbms_sql.close_cursor(cur);
+      end if;
+      raise;
+  end;
+
+  r_key_count := keys.count();
+  if keys.count() = 0 then
+    return;
+  end if;
+
+  for i in 1..keys.count() loop
+    binds(i) := org$xml_factory.newBindParam(i, keys(i));
+  end loop;
+
+  qry := makeUpdatedRowsQuery(p_qry_columns, p_qry_from, p_qry_pk_column, binds, org$outbox_api.ACTION_READ);
+
+  org$xml_factory.dumpCursorAsXml(
+    p_query      => qry
+  , p_binds      => binds
+  , r_rows_count => r_rows_count
+  , r_rows_dump  => v_xml_text
+  );
+
+  org$util.gzipPackage(v_xml_text, r_rows_dump);
+  r_last_pk := keys(keys.count());
+end; /* dumpRowsAfterKey */
+
+procedure getSnapshotRows(
+  p_group_id in varchar2
+, p_consumer in varchar2
+, p_rows in number
+
+, p_qry_columns in varchar2
+, p_qry_from in varchar2
+, p_qry_pk_column in varchar2
+
+, r_rows_dump out nocopy blob
+, r_rows_count out number
+, r_done out number
+)
+is
+  v_state varchar2(1);
+  v_last_pk number;
+  v_key_count number;
+  v_values TBindValues;
+begin
+  r_rows_count := 0;
+  r_done := 0;
+
+  -- The checkpoint is locked till the end of the transaction
+  begin
+    select state, last_pk into v_state, v_last_pk
+      from SNAPSHOT_STATE
+     where group_id = p_group_id
+       and (consumer = p_consumer or consumer is null and p_consumer is null)
+       for update;
+  exception
+    when no_data_found then
+      v_state := SNAPSHOT_RUNNING;
+      insert into SNAPSHOT_STATE(group_id, consumer, state, last_pk, rows_count, started_ts, updated_ts)
+        values(p_group_id, p_consumer, v_state, null, 0, systimestamp, systimestamp);
+  end;
+
+  if v_state = SNAPSHOT_DONE then
+    r_done := 1;
+    return;
+  end if;
+
+  dumpRowsAfterKey(
+    p_qry_columns   => p_qry_columns
+  , p_qry_from      => p_qry_from
+  , p_qry_pk_column => p_qry_pk_column
+  , p_predicate     => null
+  , p_values        => v_values
+  , p_last_pk       => v_last_pk
+  , p_rows          => p_rows
+  , r_rows_dump     => r_rows_dump
+  , r_rows_count    => r_rows_count
+  , r_last_pk       => v_last_pk
+  , r_key_count     => v_key_count
+  );
+
+  if v_key_count < p_rows then
+    v_state := SNAPSHOT_DONE;
+    r_done := 1;
+  end if;
+
+  update SNAPSHOT_STATE
+     set state = v_state,
+         last_pk = v_last_pk,
+         rows_count = rows_count + r_rows_count,
+         updated_ts = systimestamp
+   where group_id = p_group_id
+     and (consumer = p_consumer or consumer is null and p_consumer is null);
+end; /* getSnapshotRows */
+
+procedure getBackfillRows(
+  p_rows in number
+, p_predicate in varchar2
+, p_values in TBindValues
+, p_last_pk in number
+
+, p_qry_columns in varchar2
+, p_qry_from in varchar2
+, p_qry_pk_column in varchar2
+
+, r_rows_dump out nocopy blob
+, r_rows_count out number
+, r_last_pk out number
+, r_done out number
+)
+is
+  v_key_count number;
+begin
+  dumpRowsAfterKey(
+    p_qry_columns   => p_qry_columns
+  , p_qry_from      => p_qry_from
+  , p_qry_pk_column => p_qry_pk_column
+  , p_predicate     => p_predicate
+  , p_values        => p_values
+  , p_last_pk       => p_last_pk
+  , p_rows          => p_rows
+  , r_rows_dump     => r_rows_dump
+  , r_rows_count    => r_rows_count
+  , r_last_pk       => r_last_pk
+  , r_key_count     => v_key_count
+  );
+
+  r_done := case when v_key_count < p_rows then 1 else 0 end;
+end; /* getBackfillRows */
+
 procedure dumpDeletedRows(
   p_qry_pk_column in varchar2
 , p_events in out nocopy org$outbox_api.TEventArray
@@ -284,7 +747,102 @@ begin
   r_rows_count := p_events.count();
 end; /* dumpDeletedRows */
 
-procedure getNextEvents(
+procedure dumpEventsMeta(
+  p_events in out nocopy org$outbox_api.TEventArray
+, p_before in number
+, p_compaction in number
+, r_rows_dump out nocopy blob
+)
+is
+  type TKeyList is table of integer index by varchar2(40);
+  first_keys TKeyList;
+  last_keys TKeyList;
+  k varchar2(40);
+  j integer;
+  v_before clob;
+  v_xml_text clob;
+begin
+  for i in 1..p_events.count() loop
+    k := to_char(p_events(i).key);
+    if not first_keys.exists(k) then
+      first_keys(k) := i;
+    end if;
+    last_keys(k) := i;
+  end loop;
+
+  org$xml_encode.initContext();
+
+  -- If the events are compacted (the last event wins), the time and the sequence of the row are of the last event,
+  -- the image of the first event of the key is the state before all the changes, otherwise they are of each event.
+  -- There is no image, if the row is inserted by the event.
+  for i in 1..p_events.count() loop
+    k := to_char(p_events(i).key);
+    if p_compaction = COMPACTION_NONE or first_keys(k) = i then
+      j := case when p_compaction = COMPACTION_NONE then i else last_keys(k) end;
+
+      -- <ROW>
+      org$xml_encode.beginRow();
+        org$xml_encode.addColumn(p_events(i).key, '__pk_val');
+        org$xml_encode.addColumn(p_events(j).op, '__op');
+        org$xml_encode.addColumn(toUnixTimestamp(p_events(j).ts), '__ux_event_ts');
+        org$xml_encode.addColumn(FROM_TZ(p_events(j).ts, DBTIMEZONE) AT TIME ZONE '00:00', '__event_ts');
+        org$xml_encode.addColumn(p_events(j).seq, '__seq');
+        org$xml_encode.addColumn(p_events(j).tx_id, '__tx_id');
+        org$xml_encode.addColumn(p_events(j).scn, '__commit_scn');
+
+        if p_before = 1 then
+          select before_image into v_before
+            from EVENT_LOG
+           where rowid = p_events(i).rid;
+
+          if v_before is not null then
+            org$xml_encode.addColumn(v_before, '__before');
+          end if;
+        end if;
+      org$xml_encode.endRow();
+      -- </ROW>
+    end if;
+  end loop;
+
+  org$xml_encode.closeContext(v_xml_text);
+  org$util.gzipPackage(v_xml_text, r_rows_dump);
+end; /* dumpEventsMeta */
+
+-- The updated and the deleted rows of the events compacted by p_compaction serialized in XML
+-- (see getNextEvents), p_events is not changed.
+procedure dumpEventRows(
+  p_qry_columns in varchar2
+, p_qry_from in varchar2
+, p_qry_pk_column in varchar2
+, p_compaction in number
+, p_events in out nocopy org$outbox_api.TEventArray
+
+, r_upd_rows_dump out nocopy clob
+, r_upd_rows_count out number
+, r_del_rows_dump out nocopy clob
+, r_del_rows_count out number
+)
+is
+  upd_events org$outbox_api.TEventArray;
+  del_events org$outbox_api.TEventArray;
+begin
+  r_upd_rows_count := 0;
+  r_del_rows_count := 0;
+
+  copmactAndSplitEvents(p_events, upd_events, del_events, p_compaction);
+
+  if upd_events.count() > 0 then
+    dumpUpdatedRows(p_qry_columns, p_qry_from, p_qry_pk_column, upd_events, r_upd_rows_dump, r_upd_rows_count);
+  end if;
+
+  if del_events.count() > 0 then
+    dumpDeletedRows(p_qry_pk_column, del_events, r_del_rows_dump, r_del_rows_count);
+  end if;
+end; /* dumpEventRows */
+
+-- The next new events of the part with the rows serialized in XML, the events are marked as processed.
+-- Both getNextEvents overloads are made of it.
+procedure takeNewEvents(
   p_group_id in varchar2
 , p_part_id in number
 , p_rows in number
@@ -292,37 +850,48 @@ procedure getNextEvents(
 , p_qry_columns in varchar2
 , p_qry_from in varchar2
 , p_qry_pk_column in varchar2
+, p_compaction in number
 
+, r_events out nocopy org$outbox_api.TEventArray
 , r_upd_rows_dump out nocopy clob
 , r_upd_rows_count out number
 , r_del_rows_dump out nocopy clob
 , r_del_rows_count out number
 )
 is
-  all_events org$outbox_api.TEventArray;
-  upd_events org$outbox_api.TEventArray;
-  del_events org$outbox_api.TEventArray;
 begin
-  r_upd_rows_count := 0;
-  
   org$outbox_api.getNewEvents(
     p_part_id   => p_part_id
   , p_group_id  => p_group_id
   , p_row_count => p_rows
-  , r_events    => all_events
+  , r_events    => r_events
   );
 
-  copmactAndSplitEvents(all_events, upd_events, del_events);
-  
-  if upd_events.count() > 0 then
-    dumpUpdatedRows(p_qry_columns, p_qry_from, p_qry_pk_column, upd_events, r_upd_rows_dump, r_upd_rows_count);
-  end if;    
-  
-  if del_events.count() > 0 then
-    dumpDeletedRows(p_qry_pk_column, del_events, r_del_rows_dump, r_del_rows_count);
-  end if;    
+  dumpEventRows(p_qry_columns, p_qry_from, p_qry_pk_column, p_compaction, r_events,
+                r_upd_rows_dump, r_upd_rows_count, r_del_rows_dump, r_del_rows_count);
+
+  org$outbox_api.markEventsAsProcessed(r_events);
+end; /* takeNewEvents */
+
+procedure getNextEvents(
+  p_group_id in varchar2
+, p_part_id in number
+, p_rows in number
+
+, p_qry_columns in varchar2
+, p_qry_from in varchar2
+, p_qry_pk_column in varchar2
 
-  org$outbox_api.markEventsAsProcessed(all_events);
+, r_upd_rows_dump out nocopy clob
+, r_upd_rows_count out number
+, r_del_rows_dump out nocopy clob
+, r_del_rows_count out number
+)
+is
+  all_events org$outbox_api.TEventArray;
+begin
+  takeNewEvents(p_group_id, p_part_id, p_rows, p_qry_columns, p_qry_from, p_qry_pk_column, COMPACTION_LAST_WINS,
+                all_events, r_upd_rows_dump, r_upd_rows_count, r_del_rows_dump, r_del_rows_count);
 end; /* getNextEvents */
 
 procedure getNextEvents(
@@ -333,33 +902,340 @@ procedure getNextEvents(
 , p_qry_columns in varchar2
 , p_qry_from in varchar2
 , p_qry_pk_column in varchar2
+, p_before in number default 0
+, p_compaction in number default COMPACTION_LAST_WINS
 
 , r_upd_rows_dump out nocopy blob
 , r_upd_rows_count out number
 , r_del_rows_dump out nocopy blob
 , r_del_rows_count out number
+, r_events_dump out nocopy blob
 )
 is
+  all_events org$outbox_api.TEventArray;
   v_upd_xml_text clob;
   v_del_xml_text clob;
 begin
-  getNextEvents(
-    p_group_id       => p_group_id,
-    p_part_id        => p_part_id,
-    p_rows           => p_rows,
-    p_qry_columns    => p_qry_columns,
-    p_qry_from       => p_qry_from,
-    p_qry_pk_column  => p_qry_pk_column,
-    r_upd_rows_dump  => v_upd_xml_text,
-    r_upd_rows_count => r_upd_rows_count,
-    r_del_rows_dump  => v_del_xml_text,
-    r_del_rows_count => r_del_rows_count
-  );
+  takeNewEvents(p_group_id, p_part_id, p_rows, p_qry_columns, p_qry_from, p_qry_pk_column, p_compaction,
+                all_events, v_upd_xml_text, r_upd_rows_count, v_del_xml_text, r_del_rows_count);
 
   org$util.gzipPackage(v_upd_xml_text, r_upd_rows_dump);
   org$util.gzipPackage(v_del_xml_text, r_del_rows_dump);
+
+  dumpEventsMeta(all_events, p_before, p_compaction, r_events_dump);
 end; /* getNextEvents */
 
+procedure fetchConsumerEvents(
+  p_group_id in varchar2
+, p_consumer in varchar2
+, p_part_id in number
+, p_rows in number
+, r_events out nocopy org$outbox_api.TEventArray
+)
+is
+  v_last_ts timestamp;
+  v_last_rid rowid;
+  v_last_scn number;
+begin
+  -- The position is locked till the end of the transaction
+  begin
+    select last_ts, last_rid, last_scn into v_last_ts, v_last_rid, v_last_scn
+      from CONSUMER_POSITION
+     where group_id = p_group_id
+       and consumer = p_consumer
+       and part_id = p_part_id
+       for update;
+  exception
+    when no_data_found then
+      insert into CONSUMER_POSITION(group_id, consumer, part_id, last_ts, last_rid, last_scn, events_count, updated_ts)
+        values(p_group_id, p_consumer, p_part_id, null, null, null, 0, systimestamp);
+  end;
+
+  -- The position by the time (the previous versions): before the first event after it by the commit order,
+  -- or after all the events if there are none, the events of the SCN are read (the rowid is unset)
+  if v_last_scn is null and v_last_ts is not null then
+    select nvl(min(case when ts > v_last_ts or (ts = v_last_ts and rowid > v_last_rid)
+                        then nvl(commit_scn, ora_rowscn) end) - 1,
+               max(nvl(commit_scn, ora_rowscn)))
+      into v_last_scn
+      from EVENT_LOG
+     where group_id = p_group_id
+       and part_id = p_part_id;
+    v_last_rid := null;
+  end if;
+
+  select rid, key_n, action, ts, seq, tx_id, scn bulk collect into r_events from
+  (
+    select rowid rid, key_n, action, ts, seq, tx_id, nvl(commit_scn, ora_rowscn) scn from EVENT_LOG
+    where group_id = p_group_id
+      and part_id = p_part_id
+      and (v_last_scn is null
+        or nvl(commit_scn, ora_rowscn) > v_last_scn
+        or (nvl(commit_scn, ora_rowscn) = v_last_scn and rowid > v_last_rid))
+    order by scn, rid
+  )
+  where rownum <= p_rows;
+end; /* fetchConsumerEvents */
+
+procedure advanceConsumer(
+  p_group_id in varchar2
+, p_consumer in varchar2
+, p_part_id in number
+, p_events in out nocopy org$outbox_api.TEventArray
+)
+is
+begin
+  if p_events.count() = 0 then
+    return;
+  end if;
+
+  update CONSUMER_POSITION
+     set last_ts = p_events(p_events.count()).ts,
+         last_rid = p_events(p_events.count()).rid,
+         last_scn = p_events(p_events.count()).scn,
+         events_count = events_count + p_events.count(),
+         updated_ts = systimestamp
+   where group_id = p_group_id
+     and consumer = p_consumer
+     and part_id = p_part_id;
+end; /* advanceConsumer */
+
+procedure getNextConsumerEvents(
+  p_group_id in varchar2
+, p_consumer in varchar2
+, p_part_id in number
+, p_rows in number
+
+, p_qry_columns in varchar2
+, p_qry_from in varchar2
+, p_qry_pk_column in varchar2
+, p_before in number default 0
+, p_compaction in number default COMPACTION_LAST_WINS
+
+, r_upd_rows_dump out nocopy blob
+, r_upd_rows_count out number
+, r_del_rows_dump out nocopy blob
+, r_del_rows_count out number
+, r_events_dump out nocopy blob
+)
+is
+  all_events org$outbox_api.TEventArray;
+  v_upd_xml_text clob;
+  v_del_xml_text clob;
+begin
+  r_upd_rows_count := 0;
+  r_del_rows_count := 0;
+
+  fetchConsumerEvents(p_group_id, p_consumer, p_part_id, p_rows, all_events);
+
+  if all_events.count() = 0 then
+    return;
+  end if;
+
+  dumpEventRows(p_qry_columns, p_qry_from, p_qry_pk_column, p_compaction, all_events,
+                v_upd_xml_text, r_upd_rows_count, v_del_xml_text, r_del_rows_count);
+
+  org$util.gzipPackage(v_upd_xml_text, r_upd_rows_dump);
+  org$util.gzipPackage(v_del_xml_text, r_del_rows_dump);
+
+  dumpEventsMeta(all_events, p_before, p_compaction, r_events_dump);
+
+  advanceConsumer(p_group_id, p_consumer, p_part_id, all_events);
+end; /* getNextConsumerEvents */
+
+procedure dumpPayloadEvents(
+  p_pk_name in varchar2
+, p_events in out nocopy org$outbox_api.TEventArray
+, r_rows_dump out nocopy clob
+, r_rows_count out number
+)
+is
+  v_payload clob;
+  v_headers varchar2(4000);
+begin
+  org$xml_encode.initContext();
+
+  for i in 1..p_events.count() loop
+    select payload, headers into v_payload, v_headers
+      from EVENT_LOG
+     where rowid = p_events(i).rid;
+
+    -- <ROW>
+    org$xml_encode.beginRow();
+      org$xml_encode.addColumn(p_pk_name, '__pk_name');
+      org$xml_encode.addColumn(p_events(i).key, '__pk_val');
+      org$xml_encode.addColumn(p_events(i).op, '__op');
+      org$xml_encode.addColumn(toUnixTimestamp(p_events(i).ts), '__ux_ts');
+      org$xml_encode.addColumn(FROM_TZ(p_events(i).ts, DBTIMEZONE) AT TIME ZONE '00:00', '__ts');
+      org$xml_encode.addColumn(toUnixTimestamp(p_events(i).ts), '__ux_event_ts');
+      org$xml_encode.addColumn(FROM_TZ(p_events(i).ts, DBTIMEZONE) AT TIME ZONE '00:00', '__event_ts');
+      org$xml_encode.addColumn(p_events(i).seq, '__seq');
+      org$xml_encode.addColumn(p_events(i).tx_id, '__tx_id');
+      org$xml_encode.addColumn(p_events(i).scn, '__commit_scn');
+      org$xml_encode.addColumn(v_payload, '__payload');
+      org$xml_encode.addColumn(to_clob(v_headers), '__headers');
+    org$xml_encode.endRow();
+    -- </ROW>
+  end loop;
+
+  org$xml_encode.closeContext(r_rows_dump);
+  r_rows_count := p_events.count();
+end; /* dumpPayloadEvents */
+
+procedure getNextPayloadEvents(
+  p_group_id in varchar2
+, p_consumer in varchar2
+, p_part_id in number
+, p_rows in number
+, p_pk_name in varchar2
+
+, r_rows_dump out nocopy blob
+, r_rows_count out number
+)
+is
+  all_events org$outbox_api.TEventArray;
+  v_xml_text clob;
+begin
+  r_rows_count := 0;
+
+  if p_consumer is null then
+    org$outbox_api.getNewEvents(
+      p_part_id   => p_part_id
+    , p_group_id  => p_group_id
+    , p_row_count => p_rows
+    , r_events    => all_events
+    );
+  else
+    fetchConsumerEvents(p_group_id, p_consumer, p_part_id, p_rows, all_events);
+  end if;
+
+  if all_events.count() = 0 then
+    return;
+  end if;
+
+  dumpPayloadEvents(p_pk_name, all_events, v_xml_text, r_rows_count);
+  org$util.gzipPackage(v_xml_text, r_rows_dump);
+
+  if p_consumer is null then
+    org$outbox_api.markEventsAsProcessed(all_events);
+  else
+    advanceConsumer(p_group_id, p_consumer, p_part_id, all_events);
+  end if;
+end; /* getNextPayloadEvents */
+
+procedure getReplayEvents(
+  p_group_id in varchar2
+, p_consumer in varchar2
+, p_parts in varchar2
+, p_from_ts in varchar2
+, p_to_ts in varchar2
+, p_last_ts in varchar2
+, p_last_rid in varchar2
+, p_rows in number
+
+, p_qry_columns in varchar2
+, p_qry_from in varchar2
+, p_qry_pk_column in varchar2
+, p_before in number default 0
+, p_compaction in number default COMPACTION_LAST_WINS
+
+, r_upd_rows_dump out nocopy blob
+, r_upd_rows_count out number
+, r_del_rows_dump out nocopy blob
+, r_del_rows_count out number
+, r_last_ts out varchar2
+, r_last_rid out varchar2
+, r_done out number
+, r_events_dump out nocopy blob
+)
+is
+  all_events org$outbox_api.TEventArray;
+  v_upd_xml_text clob;
+  v_del_xml_text clob;
+  v_from timestamp := to_timestamp(p_from_ts, TS_FORMAT);
+  v_to timestamp := to_timestamp(p_to_ts, TS_FORMAT);
+  v_last timestamp := to_timestamp(p_last_ts, TS_FORMAT);
+begin
+  r_upd_rows_count := 0;
+  r_del_rows_count := 0;
+  r_last_ts := p_last_ts;
+  r_last_rid := p_last_rid;
+
+  select rid, key_n, action, ts, seq, tx_id, scn bulk collect into all_events from
+  (
+    select e.rowid rid, e.key_n, e.action, e.ts, e.seq, e.tx_id, nvl(e.commit_scn, e.ora_rowscn) scn from EVENT_LOG e
+    where e.group_id = p_group_id
+      and ((p_consumer is null and e.state = org$outbox_api.STATE_PROCESSED)
+        -- At or before the position of the consumer (see fetchConsumerEvents), the rowid is unset after the rewind,
+        -- the position of the previous versions is by the time
+        or exists (select null from CONSUMER_POSITION p
+                    where p.group_id = e.group_id
+                      and p.consumer = p_consumer
+                      and p.part_id = e.part_id
+                      and (p.last_scn is not null
+                           and (nvl(e.commit_scn, e.ora_rowscn) < p.last_scn
+                             or nvl(e.commit_scn, e.ora_rowscn) = p.last_scn
+                                and (p.last_rid is null or e.rowid <= p.last_rid))
+                        or p.last_scn is null and e.ts <= p.last_ts)))
+      and e.ts >= v_from and e.ts < v_to
+      and (p_parts is null or instr(',' || p_parts || ',', ',' || e.part_id || ',') > 0)
+      and (v_last is null or e.ts > v_last or (e.ts = v_last and e.rowid > chartorowid(p_last_rid)))
+    order by ts, rid
+  )
+  where rownum <= p_rows;
+
+  r_done := case when all_events.count() < p_rows then 1 else 0 end;
+
+  if all_events.count() = 0 then
+    return;
+  end if;
+
+  r_last_ts := to_char(all_events(all_events.count()).ts, TS_FORMAT);
+  r_last_rid := rowidtochar(all_events(all_events.count()).rid);
+
+  dumpEventRows(p_qry_columns, p_qry_from, p_qry_pk_column, p_compaction, all_events,
+                v_upd_xml_text, r_upd_rows_count, v_del_xml_text, r_del_rows_count);
+
+  org$util.gzipPackage(v_upd_xml_text, r_upd_rows_dump);
+  org$util.gzipPackage(v_del_xml_text, r_del_rows_dump);
+
+  dumpEventsMeta(all_events, p_before, p_compaction, r_events_dump);
+end; /* getReplayEvents */
+
+procedure registerAlert(
+  p_group_id in varchar2
+, p_part_id in number
+, r_name out varchar2
+)
+is
+begin
+  r_name := org$outbox_api.alertName(p_group_id, p_part_id);
+  dbms_alert.register(r_name);
+end; /* registerAlert */
+
+procedure waitAlert(
+  p_timeout in number
+, r_name out varchar2
+, r_status out number
+)
+is
+  v_message varchar2(1800);
+begin
+  dbms_alert.waitany(r_name, v_message, r_status, p_timeout);
+end; /* waitAlert */
+
+procedure removeAlerts
+is
+begin
+  dbms_alert.removeall();
+end; /* removeAlerts */
+
+function apiVersion return number
+is
+begin
+  return API_VERSION;
+end; /* apiVersion */
+
 end org$gate_api;
 /
 
@@ -83,10 +83,22 @@ end;
 ACTION_INSERT constant varchar2(1) := 'c';
 ACTION_UPDATE constant varchar2(1) := 'u';
 ACTION_DELETE constant varchar2(1) := 'd';
+-- The row read by the snapshot (it is not published to the outbox, see org$gate_api.getSnapshotRows)
+ACTION_READ constant varchar2(1) := 'r';
 
 STATE_NEW constant varchar2(1) := 'n';
 STATE_PROCESSED constant varchar2(1) := 'p';
 
+-- Signaling of the consumers about new events (see dbms_alert) on commit of the publishing transaction.
+-- Note: the alert is locked until the end of the transaction, so concurrent transactions
+-- publishing to the same bucket of the group are serialized. It is disabled by default,
+-- enable it (with the runner.wake_up.enabled option) for the low-contention loads only (see setSignalEnabled).
+-- The setting is kept in the OUTBOX_SETTING table, so it survives the re-creation of the package.
+SETTING_SIGNAL_ENABLED constant varchar2(64) := 'signal_enabled';
+
+-- The interval of re-reading the settings by the publishing session (seconds)
+SETTING_REFRESH_INTERVAL constant number := 10;
+
 
 -- TEvent describes a change data capture event.
 -- Currently, only events for tables with a primary numeric key are supported.
@@ -94,11 +106,32 @@ type TEvent is record (
   rid rowid,
   key number,
   op varchar2(1), 
-  ts timestamp
+  ts timestamp,
+  seq number,
+  tx_id varchar2(64),
+  scn number
 );
 
 -- TEventArray is used to increase throughput and reduce context switching.
 type TEventArray is table of TEvent;
+
+-- The payload event is published with an application-provided payload (JSON or any text),
+-- which is relayed as-is instead of the row (see the task mode "payload").
+-- @p_key_n - the key of the event, it is used as the key of the message and for the sharding.
+-- @p_payload - the payload (null - the message without a value, e.g. a tombstone for a delete).
+-- @p_headers - the headers of the message as a flat JSON object of strings, e.g.: {"type":"OrderPlaced"}.
+procedure putPayloadEvent(
+  p_group_id in varchar2
+, p_key_n in number
+, p_payload in clob
+, p_bucket_count in number
+, p_action in varchar2 default ACTION_INSERT
+, p_headers in varchar2 default null
+);
+
+-- The arrays of the keys and the actions for the batched publishing (see putEvents).
+type TKeyArray is table of number index by binary_integer;
+type TActionArray is table of varchar2(1) index by binary_integer;
   
 -- Putting insert event in the "outbox-queue".
 -- @p_group_id - unique payload code, selected by the user.
@@ -111,19 +144,59 @@ procedure putInsertEvent(
 );
 
 -- Putting update event in the "outbox-queue".
+-- @p_before - the before image: the values of the row before the change as a flat JSON object of strings
+-- with the column names as the keys, e.g.: {"id":"42","status":"NEW"} (null - not captured).
 procedure putUpdateEvent(
   p_group_id in varchar2
 , p_key_n in number
 , p_bucket_count in number
+, p_before in clob default null
 );
 
 -- Putting delete event in the "outbox-queue".
+-- @p_before - the before image: the values of the deleted row (see putUpdateEvent).
 procedure putDeleteEvent(
   p_group_id in varchar2
 , p_key_n in number
 , p_bucket_count in number
+, p_before in clob default null
+);
+
+-- Putting the events in the "outbox-queue" in a single call (e.g. from the client applications, using array binds).
+-- @p_keys - primary keys of the modified records.
+-- @p_actions - actions of the events (ACTION_INSERT, ACTION_UPDATE or ACTION_DELETE), in the same order as the keys.
+procedure putEvents(
+  p_group_id in varchar2
+, p_keys in TKeyArray
+, p_actions in TActionArray
+, p_bucket_count in number
+);
+
+-- The member of the before image (see putUpdateEvent): "name":"value" with the value escaped for JSON,
+-- "name":null if the value is null. It is used by the generated triggers to build the image, e.g.:
+-- '{' || imageValue('id', to_char(:old.id)) || ',' || imageValue('status', :old.status) || '}'
+-- (the image of the wide rows is concatenated in a clob)
+function imageValue(
+  p_name in varchar2
+, p_value in varchar2
+) return varchar2;
+
+-- Enable or disable the signaling of the consumers (see SETTING_SIGNAL_ENABLED).
+-- The setting is applied on commit, the publishing sessions read it within SETTING_REFRESH_INTERVAL.
+procedure setSignalEnabled(
+  p_enabled in boolean
 );
 
+-- Whether the signaling of the consumers is enabled (the setting is cached by the session).
+function isSignalEnabled return boolean;
+
+-- The name of the alert signaled on the publishing of the events into the bucket of the group.
+-- The name is limited to 30 characters, so a long group code is truncated.
+function alertName(
+  p_group_id in varchar2
+, p_part_id in number
+) return varchar2;
+
 -- Mark the event as processed.
 -- The method opens a transaction.
 procedure markEventsAsProcessed(
@@ -145,16 +218,84 @@ end org$outbox_api;
 
 create or replace package body orgon.org$outbox_api is
 
+-- The settings cached by the session (see SETTING_REFRESH_INTERVAL)
+g_signal_enabled boolean;
+g_settings_time number;
+
+procedure setSignalEnabled(
+  p_enabled in boolean
+)
+is
+  v_value varchar2(1);
+begin
+  v_value := case when p_enabled then 'Y' else 'N' end;
+
+  merge into OUTBOX_SETTING s
+  using (select SETTING_SIGNAL_ENABLED name from dual) v
+  on (s.name = v.name)
+  when matched then
+    update set s.value = v_value, s.updated_ts = systimestamp
+  when not matched then
+    insert (name, value, updated_ts) values (v.name, v_value, systimestamp);
+
+  -- The setting is re-read by the session at once
+  g_settings_time := null;
+end; /* setSignalEnabled */
+
+function isSignalEnabled return boolean
+is
+  v_value OUTBOX_SETTING.value%type;
+begin
+  -- The time is in hundredths of a second, it may wrap around
+  if g_settings_time is null
+     or abs(dbms_utility.get_time() - g_settings_time) >= SETTING_REFRESH_INTERVAL * 100 then
+    begin
+      select value into v_value from OUTBOX_SETTING where name = SETTING_SIGNAL_ENABLED;
+    exception
+      when no_data_found then
+        v_value := null;
+    end;
+
+    g_signal_enabled := nvl(v_value, 'N') = 'Y';
+    g_settings_time := dbms_utility.get_time();
+  end if;
+
+  return g_signal_enabled;
+end; /* isSignalEnabled */
+
+function alertName(
+  p_group_id in varchar2
+, p_part_id in number
+) return varchar2
+is
+  v_suffix varchar2(16);
+begin
+  v_suffix := '$' || to_char(p_part_id);
+  return substr('ORG$' || upper(p_group_id), 1, 30 - length(v_suffix)) || v_suffix;
+end; /* alertName */
+
 procedure putNewEvent(
   p_group_id in varchar2
 , p_key_n in number
 , p_action in varchar2
 , p_bucket_count in number
+, p_payload in clob default null
+, p_headers in varchar2 default null
+, p_before in clob default null
 ) 
 is
+  v_part_id number;
 begin
-  insert into EVENT_LOG(group_id, part_id, state, ts, key_n, action) 
-    values(p_group_id, ora_hash(p_key_n, p_bucket_count - 1), STATE_NEW, systimestamp, p_key_n, p_action);
+  v_part_id := ora_hash(p_key_n, p_bucket_count - 1);
+
+  -- The events of a transaction share its id (the commit order is captured on the reading, see getNewEvents)
+  insert into EVENT_LOG(group_id, part_id, state, ts, key_n, action, payload, headers, before_image, seq, tx_id) 
+    values(p_group_id, v_part_id, STATE_NEW, systimestamp, p_key_n, p_action, p_payload, p_headers, p_before,
+           EVENT_LOG_SEQ.nextval, dbms_transaction.local_transaction_id(true));
+
+  if isSignalEnabled() then
+    dbms_alert.signal(alertName(p_group_id, v_part_id), null);
+  end if;
 end; /* putNewEvent */
 
 procedure putInsertEvent(
@@ -171,29 +312,104 @@ procedure putUpdateEvent(
   p_group_id in varchar2
 , p_key_n in number
 , p_bucket_count in number
+, p_before in clob default null
 ) 
 is
 begin
-  putNewEvent(p_group_id, p_key_n, ACTION_UPDATE, p_bucket_count);
+  putNewEvent(p_group_id, p_key_n, ACTION_UPDATE, p_bucket_count, p_before => p_before);
 end; /* putUpdateEvent */
 
 procedure putDeleteEvent(
   p_group_id in varchar2
 , p_key_n in number
 , p_bucket_count in number
+, p_before in clob default null
 ) 
 is
 begin
-  putNewEvent(p_group_id, p_key_n, ACTION_DELETE, p_bucket_count);
+  putNewEvent(p_group_id, p_key_n, ACTION_DELETE, p_bucket_count, p_before => p_before);
 end; /* putDeleteEvent */
 
+procedure putPayloadEvent(
+  p_group_id in varchar2
+, p_key_n in number
+, p_payload in clob
+, p_bucket_count in number
+, p_action in varchar2 default ACTION_INSERT
+, p_headers in varchar2 default null
+)
+is
+begin
+  if p_action not in (ACTION_INSERT, ACTION_UPDATE, ACTION_DELETE) then
+    raise_application_error(-20002, 'Unknown action: ' || p_action);
+  end if;
+
+  putNewEvent(p_group_id, p_key_n, p_action, p_bucket_count, p_payload, p_headers);
+end; /* putPayloadEvent */
+
+procedure putEvents(
+  p_group_id in varchar2
+, p_keys in TKeyArray
+, p_actions in TActionArray
+, p_bucket_count in number
+)
+is
+  i binary_integer;
+begin
+  if p_keys.count() <> p_actions.count() then
+    raise_application_error(-20001, 'The number of the keys and the actions does not match');
+  end if;
+
+  i := p_keys.first();
+  while i is not null loop
+    if p_actions(i) not in (ACTION_INSERT, ACTION_UPDATE, ACTION_DELETE) then
+      raise_application_error(-20002, 'Unknown action: ' || p_actions(i));
+    end if;
+
+    -- The repeated signals of the alert within the transaction are delivered once on the commit
+    putNewEvent(p_group_id, p_keys(i), p_actions(i), p_bucket_count);
+
+    i := p_keys.next(i);
+  end loop;
+end; /* putEvents */
+
+function imageValue(
+  p_name in varchar2
+, p_value in varchar2
+) return varchar2
+is
+  v_value varchar2(32767) := p_value;
+begin
+  if v_value is null then
+    return '"' || p_name || '":null';
+  end if;
+
+  v_value := replace(v_value, '\', '\\');
+  v_value := replace(v_value, '"', '\"');
+  v_value := replace(v_value, chr(8), '\b');
+  v_value := replace(v_value, chr(9), '\t');
+  v_value := replace(v_value, chr(10), '\n');
+  v_value := replace(v_value, chr(12), '\f');
+  v_value := replace(v_value, chr(13), '\r');
+
+  -- The rest of the control characters
+  for c in 0..31 loop
+    if c not in (8, 9, 10, 12, 13) and instr(v_value, chr(c)) > 0 then
+      v_value := replace(v_value, chr(c), '\u00' || to_char(c, 'FM0X'));
+    end if;
+  end loop;
+
+  return '"' || p_name || '":"' || v_value || '"';
+end; /* imageValue */
+
 procedure markEventsAsProcessed(
   p_events in out nocopy TEventArray
 ) 
 is
 begin
+  -- The commit SCN is kept, the update changes the SCN of the row
   forall i in 1.. p_events.count()
-    update EVENT_LOG set state = STATE_PROCESSED
+    update EVENT_LOG set state = STATE_PROCESSED, commit_scn = nvl(commit_scn, p_events(i).scn)
       where rowid = p_events(i).rid;
 end; /* markEventsAsProcessed */
 
@@ -205,9 +421,12 @@ procedure getNewEvents(
 )
 is
 begin
-  select rowid, key_n, action, ts bulk collect into r_events from 
+  -- The SCN of the new row is of the commit of its transaction (see the ROWDEPENDENCIES of EVENT_LOG)
+  select rowid, key_n, action, ts, seq, tx_id, scn bulk collect into r_events from 
   (
-    select /*+ FIRST_ROWS(1) DYNAMIC_SAMPLING(0) */ key_n, action, ts from EVENT_LOG
+    select /*+ FIRST_ROWS(1) DYNAMIC_SAMPLING(0) */ key_n, action, ts, seq, tx_id,
+           nvl(commit_scn, ora_rowscn) scn
+      from EVENT_LOG
     where group_id = p_group_id
       and part_id = p_part_id
       and state = STATE_NEW
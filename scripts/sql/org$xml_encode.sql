@@ -65,6 +65,12 @@ procedure addColumn(
 , key in varchar2
 );
 
+-- The value is escaped (unlike the other types), the element is added even if the value is null.
+procedure addColumn(
+  obj in clob
+, key in varchar2
+);
+
 end org$xml_encode;
 /
 
@@ -194,6 +200,24 @@ begin
   putString(g_dump_clob, g_buf_str, obj, key);
 end; /* addColumn */
 
+procedure addColumn(
+  obj in clob
+, key in varchar2
+)
+is
+begin
+  addToClob(g_dump_clob, g_buf_str, '<' || key || '>');
+
+  if obj is not null and dbms_lob.getlength(obj) > 0 then
+    if g_buf_str is not null then
+      flushBuffer(buf_lob => g_dump_clob, buf_str => g_buf_str);
+    end if;
+    dbms_lob.append(g_dump_clob, dbms_xmlgen.convert(obj, dbms_xmlgen.ENTITY_ENCODE));
+  end if;
+
+  addToClob(g_dump_clob, g_buf_str, '</' || key || '>' || chr(10));
+end; /* addColumn */
+
 procedure beginRow
 is
 begin
@@ -0,0 +1,18 @@
+-- The position of the named consumers of the groups (see config "consumer" of the task), per part.
+-- The events are not marked as processed by the consumers, so the group can be consumed by several consumers.
+-- The consumers read the events in order of the commit (last_scn, last_rid) regardless of the state,
+-- last_ts is the time of the last event (informational, the position of the previous versions).
+
+create table CONSUMER_POSITION
+(
+  group_id     VARCHAR2(64) not null,
+  consumer     VARCHAR2(30) not null,
+  part_id      NUMBER not null,
+  last_ts      TIMESTAMP(3),
+  last_rid     ROWID,
+  last_scn     NUMBER,
+  events_count NUMBER not null,
+  updated_ts   TIMESTAMP(3) not null
+);
+
+alter table CONSUMER_POSITION add constraint CONSUMER_POSITION_PK primary key (GROUP_ID, CONSUMER, PART_ID);
@@ -3,12 +3,25 @@
 
 create table EVENT_LOG
 (
-  ts       TIMESTAMP(3),
-  group_id VARCHAR2(64),
-  part_id  NUMBER,
-  state    VARCHAR2(1),
-  action   VARCHAR2(1),
-  key_n    NUMBER
-);
-
-create index EVENT_LOG_IDX on ORGON.EVENT_LOG (GROUP_ID, PART_ID, STATE, TS, KEY_N, ACTION);
\ No newline at end of file
+  ts           TIMESTAMP(3),
+  group_id     VARCHAR2(64),
+  part_id      NUMBER,
+  state        VARCHAR2(1),
+  action       VARCHAR2(1),
+  key_n        NUMBER,
+  payload      CLOB,
+  headers      VARCHAR2(4000),
+  before_image CLOB,
+  seq          NUMBER,
+  tx_id        VARCHAR2(64),
+  commit_scn   NUMBER
+)
+-- The SCN of the row is of the commit of its transaction, not of the block (the commit order of the events)
+rowdependencies;
+
+-- The sequence of the events (see org$outbox_api.putNewEvent), it increases in order of the publishing
+create sequence EVENT_LOG_SEQ cache 1000;
+
+-- The columns added later are upgraded by the schema migrations (see "orgonaut schema upgrade").
+
+create index EVENT_LOG_IDX on EVENT_LOG (GROUP_ID, PART_ID, STATE, TS, KEY_N, ACTION);
\ No newline at end of file
@@ -0,0 +1,2 @@
+-- The events of the transactions are counted by the id (see the transaction markers of the tasks)
+create index EVENT_LOG_TX_IDX on EVENT_LOG (TX_ID);
@@ -0,0 +1,11 @@
+-- The settings of the outbox read by the packages at runtime (see org$outbox_api.setSignalEnabled),
+-- so they are kept when the packages are re-created by the schema migrations.
+
+create table OUTBOX_SETTING
+(
+  name       VARCHAR2(64) not null,
+  value      VARCHAR2(4000),
+  updated_ts TIMESTAMP(3) not null
+);
+
+alter table OUTBOX_SETTING add constraint OUTBOX_SETTING_PK primary key (NAME);
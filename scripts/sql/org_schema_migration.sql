@@ -0,0 +1,10 @@
+-- The applied schema migrations (see "orgonaut schema"), the highest version is the version of the schema.
+
+create table SCHEMA_MIGRATION
+(
+  version    NUMBER not null,
+  name       VARCHAR2(128) not null,
+  applied_ts TIMESTAMP(3) not null
+);
+
+alter table SCHEMA_MIGRATION add constraint SCHEMA_MIGRATION_PK primary key (VERSION);
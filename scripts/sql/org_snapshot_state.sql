@@ -0,0 +1,15 @@
+-- The position of the snapshot (initial load) of the group, per consumer (null - the unnamed consumer of the group).
+-- The null consumer is unique within the group as well (the non-null columns of the key are equal).
+
+create table SNAPSHOT_STATE
+(
+  group_id   VARCHAR2(64) not null,
+  consumer   VARCHAR2(30),
+  state      VARCHAR2(1) not null,
+  last_pk    NUMBER,
+  rows_count NUMBER not null,
+  started_ts TIMESTAMP(3) not null,
+  updated_ts TIMESTAMP(3) not null
+);
+
+alter table SNAPSHOT_STATE add constraint SNAPSHOT_STATE_UK unique (GROUP_ID, CONSUMER);
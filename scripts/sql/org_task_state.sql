@@ -0,0 +1,8 @@
+create table TASK_STATE
+(
+  tag        VARCHAR2(128) not null,
+  paused     VARCHAR2(1) not null,
+  updated_ts TIMESTAMP(3) not null
+);
+
+alter table TASK_STATE add constraint TASK_STATE_PK primary key (TAG);